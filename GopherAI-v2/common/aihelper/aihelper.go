@@ -2,20 +2,64 @@ package aihelper
 
 import (
 	"GopherAI/common/cache"
+	"GopherAI/config"
+	"GopherAI/dao/session"
 	"GopherAI/model"
 	"GopherAI/utils"
 	"context"
+	"errors"
+	"log"
 	"sync"
+	"sync/atomic"
+
+	einomodel "github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+	"github.com/google/uuid"
+)
+
+// 分支操作相关错误
+var (
+	ErrMessageNotFound     = errors.New("message not found")
+	ErrNotUserMessage      = errors.New("only user messages can be edited")
+	ErrNothingToRegenerate = errors.New("no user message to regenerate a reply for")
 )
 
+// ErrGenerationStopped 生成在产生任何内容前被停止
+var ErrGenerationStopped = errors.New("generation stopped")
+
 // AIHelper AI助手结构体，包含消息历史和AI模型
 type AIHelper struct {
-	model    AIModel
+	model AIModel
+	// 当前分支：从第一条消息到活动叶子消息的路径，发送给模型的历史即来自这里
 	messages []*model.Message
-	mu       sync.RWMutex
+	// 会话中所有分支的消息
+	tree *messageTree
+	mu   sync.RWMutex
 	//一个会话绑定一个AIHelper
 	SessionID string
 	saveFunc  func(*model.Message) (*model.Message, error)
+	// 上下文策略，决定每轮发送给模型的历史消息
+	strategy ContextStrategy
+	// 较早消息的滚动摘要（仅 summary 策略使用）
+	summary *model.Summary
+	// 会话的系统提示词与生成参数，每次调用模型时生效
+	systemPrompt string
+	genParams    model.GenerationParams
+	// 会话中停用的MCP服务名
+	disabledMCPServers []string
+	// 创建模型所用的配置，切换模型时沿用
+	modelConfig map[string]interface{}
+	// 正在进行的生成，用于停止生成
+	generation *generation
+	// 轮次队列，保证一问一答不会与其他请求交错
+	turns turnQueue
+	// 正在使用该 AIHelper 的请求数，大于 0 时不会被淘汰
+	pins atomic.Int32
+}
+
+// generation 一次正在进行的生成
+type generation struct {
+	cancel context.CancelFunc
 }
 
 // NewAIHelper 创建新的AIHelper实例
@@ -23,37 +67,70 @@ func NewAIHelper(model_ AIModel, SessionID string) *AIHelper {
 	return &AIHelper{
 		model:    model_,
 		messages: make([]*model.Message, 0),
+		tree:     newMessageTree(),
 		//异步推送到消息队列中（支持 Redis Stream 或内存队列）
 		saveFunc: func(msg *model.Message) (*model.Message, error) {
-			data := cache.GenerateMessageParam(msg.SessionID, msg.Content, msg.UserName, msg.IsUser)
+			data := cache.GenerateMessageParam(msg)
 			err := cache.PublishMessage(data)
 			return msg, err
 		},
 		SessionID: SessionID,
+		strategy:  newContextStrategyForModel(model_.GetModelType()),
 	}
 }
 
-// addMessage 添加消息到内存中并调用自定义存储函数
-func (a *AIHelper) AddMessage(Content string, UserName string, IsUser bool, Save bool) {
-	userMsg := model.Message{
+// addMessage 添加消息到当前分支末尾并调用自定义存储函数
+func (a *AIHelper) AddMessage(Content string, UserName string, IsUser bool, Save bool) *model.Message {
+	kind := model.MessageKindAssistant
+	if IsUser {
+		kind = model.MessageKindUser
+	}
+	userMsg := &model.Message{
 		SessionID: a.SessionID,
 		Content:   Content,
 		UserName:  UserName,
 		IsUser:    IsUser,
+		Kind:      kind,
+	}
+	a.appendMessage(userMsg, Save)
+	return userMsg
+}
+
+// appendMessage 为消息生成ID并挂到当前分支的叶子消息下
+func (a *AIHelper) appendMessage(msg *model.Message, save bool) {
+	a.mu.Lock()
+	if msg.MessageID == "" {
+		msg.MessageID = uuid.New().String()
 	}
-	a.messages = append(a.messages, &userMsg)
-	if Save {
-		a.saveFunc(&userMsg)
+	msg.ParentID = ""
+	if n := len(a.messages); n > 0 {
+		msg.ParentID = a.messages[n-1].MessageID
+	}
+	a.tree.add(msg)
+	a.messages = append(a.messages, msg)
+	a.mu.Unlock()
+
+	if save {
+		a.saveFunc(msg)
 	}
 }
 
+// loadMessages 用数据库中的消息初始化消息树和当前分支（不开启存储功能）
+func (a *AIHelper) loadMessages(msgs []model.Message, activeLeafID string) {
+	tree, path := buildMessageTree(msgs, activeLeafID)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.tree = tree
+	a.messages = path
+}
+
 // SaveMessage 保存消息到数据库（通过回调函数避免循环依赖）
 // 通过传入func，自己调用外部的保存函数，即可支持同步异步等多种策略
 func (a *AIHelper) SetSaveFunc(saveFunc func(*model.Message) (*model.Message, error)) {
 	a.saveFunc = saveFunc
 }
 
-// GetMessages 获取所有消息历史
+// GetMessages 获取当前分支的消息历史
 func (a *AIHelper) GetMessages() []*model.Message {
 	a.mu.RLock()
 	defer a.mu.RUnlock()
@@ -62,61 +139,371 @@ func (a *AIHelper) GetMessages() []*model.Message {
 	return out
 }
 
+// GetHistory 获取当前分支的历史记录，附带每条消息的兄弟分支
+func (a *AIHelper) GetHistory() []model.History {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.tree.history(a.messages)
+}
+
+// GetPathTo 获取从第一条消息到指定消息的路径（可以不在当前分支上）
+func (a *AIHelper) GetPathTo(messageID string) ([]*model.Message, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if _, ok := a.tree.nodes[messageID]; !ok {
+		return nil, ErrMessageNotFound
+	}
+	return a.tree.pathTo(messageID), nil
+}
+
 // 同步生成
 func (a *AIHelper) GenerateResponse(userName string, ctx context.Context, userQuestion string) (*model.Message, error) {
 
 	//调用存储函数
 	a.AddMessage(userQuestion, userName, true, true)
 
-	a.mu.RLock()
-	//将model.Message转化成schema.Message
-	messages := utils.ConvertToSchemaMessages(a.messages)
-	a.mu.RUnlock()
+	return a.respond(ctx, userName, nil)
+}
 
-	//调用模型生成回复
-	schemaMsg, err := a.model.GenerateResponse(ctx, messages)
+// 流式生成
+func (a *AIHelper) StreamResponse(userName string, ctx context.Context, cb StreamCallback, userQuestion string) (*model.Message, error) {
+
+	//调用存储函数
+	a.AddMessage(userQuestion, userName, true, true)
+
+	return a.respond(ctx, userName, cb)
+}
+
+// Regenerate 为当前分支最后一个问题重新生成回答，新回答作为原回答的兄弟分支
+// cb 为空时同步生成，否则流式生成
+func (a *AIHelper) Regenerate(userName string, ctx context.Context, cb StreamCallback) (*model.Message, error) {
+	a.mu.Lock()
+	oldLeaf := ""
+	n := len(a.messages)
+	if n > 0 {
+		oldLeaf = a.messages[n-1].MessageID
+	}
+	// 去掉最后的回答及其工具调用，回到对应的问题上（上次生成失败时最后一条就是问题）
+	for n > 0 && !a.messages[n-1].IsUser {
+		n--
+	}
+	a.messages = a.messages[:n]
+	if len(a.messages) == 0 {
+		a.messages = a.tree.pathTo(oldLeaf)
+		a.mu.Unlock()
+		return nil, ErrNothingToRegenerate
+	}
+	a.mu.Unlock()
+
+	reply, err := a.respond(ctx, userName, cb)
 	if err != nil {
+		// 生成失败时恢复到原来的分支
+		a.mu.Lock()
+		a.messages = a.tree.pathTo(oldLeaf)
+		a.mu.Unlock()
 		return nil, err
 	}
+	return reply, nil
+}
 
-	//将schema.Message转化成model.Message
-	modelMsg := utils.ConvertToModelMessage(a.SessionID, userName, schemaMsg)
+// EditMessage 修改当前分支上的一个用户问题：在原问题的父消息下创建新问题（新分支）并生成回答
+// cb 为空时同步生成，否则流式生成
+func (a *AIHelper) EditMessage(userName string, ctx context.Context, cb StreamCallback, messageID string, userQuestion string) (*model.Message, error) {
+	a.mu.Lock()
+	target, ok := a.tree.nodes[messageID]
+	if !ok {
+		a.mu.Unlock()
+		return nil, ErrMessageNotFound
+	}
+	if !target.IsUser {
+		a.mu.Unlock()
+		return nil, ErrNotUserMessage
+	}
+	a.messages = a.tree.pathTo(target.ParentID)
+	a.mu.Unlock()
 
-	//调用存储函数
-	a.AddMessage(modelMsg.Content, userName, false, true)
+	a.AddMessage(userQuestion, userName, true, true)
+	return a.respond(ctx, userName, cb)
+}
 
-	return modelMsg, nil
+// SwitchBranch 切换到包含指定消息的分支，并沿最新的子消息走到该分支末尾
+func (a *AIHelper) SwitchBranch(messageID string) error {
+	a.mu.Lock()
+	if _, ok := a.tree.nodes[messageID]; !ok {
+		a.mu.Unlock()
+		return ErrMessageNotFound
+	}
+	a.messages = a.tree.pathTo(a.tree.latestLeaf(messageID))
+	a.mu.Unlock()
+
+	a.saveActiveLeaf()
+	return nil
 }
 
-// 流式生成
-func (a *AIHelper) StreamResponse(userName string, ctx context.Context, cb StreamCallback, userQuestion string) (*model.Message, error) {
+// AcquireTurn 获取会话的轮次，返回结束轮次时需调用的函数
+// 会话正忙时按配置排队等待（期间通过 onPosition 报告排队位置）或返回 ErrSessionBusy
+func (a *AIHelper) AcquireTurn(ctx context.Context, onPosition func(int)) (func(), error) {
+	wait := config.GetConfig().AIHelperConfig.QueueTurns
+	if err := a.turns.acquire(ctx, wait, onPosition); err != nil {
+		return nil, err
+	}
+	var once sync.Once
+	return func() { once.Do(a.turns.release) }, nil
+}
 
-	//调用存储函数
-	a.AddMessage(userQuestion, userName, true, true)
+// Unpin 结束对 AIHelper 的使用，与 GetOrCreateAIHelper 成对调用
+func (a *AIHelper) Unpin() {
+	a.pins.Add(-1)
+}
+
+// Stop 停止会话正在进行的生成，没有正在进行的生成时返回 false
+func (a *AIHelper) Stop() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.generation == nil {
+		return false
+	}
+	a.generation.cancel()
+	return true
+}
+
+// startGeneration 登记一次生成，返回可被 Stop 取消的 ctx 以及结束时需调用的函数
+func (a *AIHelper) startGeneration(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	g := &generation{cancel: cancel}
+
+	a.mu.Lock()
+	a.generation = g
+	a.mu.Unlock()
+
+	return ctx, func() {
+		a.mu.Lock()
+		if a.generation == g {
+			a.generation = nil
+		}
+		a.mu.Unlock()
+		cancel()
+	}
+}
+
+// respond 以当前分支为历史调用模型，并把回答追加到当前分支
+// 流式生成被停止（Stop 或客户端断开）时，已生成的部分内容会带上停止标记保存
+// 生成过程中的工具调用作为 tool_call / tool_result 消息保存在问题和回答之间，生成失败时不保存
+// 没有保存回答时，服务商已返回的用量仍然记录
+func (a *AIHelper) respond(ctx context.Context, userName string, cb StreamCallback) (*model.Message, error) {
+	ctx, done := a.startGeneration(ctx)
+	defer done()
+	ctx, answeredBy := withAnsweredModel(ctx)
+	ctx, tools := withToolRecorder(ctx)
+	ctx, usages := withUsageRecorder(ctx)
+	answered := false
+	defer func() {
+		if !answered {
+			a.saveReportedUsage(userName, usages)
+		}
+	}()
+	ctx = withOptionsResolver(ctx, func(modelType string) ([]einomodel.Option, error) {
+		return a.validGenerationOptions(ctx, modelType)
+	})
 
 	a.mu.RLock()
+	//将model.Message转化成schema.Message
 	messages := utils.ConvertToSchemaMessages(a.messages)
 	a.mu.RUnlock()
 
-	content, err := a.model.StreamResponse(ctx, messages, cb)
-	if err != nil {
-		return nil, err
+	//按上下文策略裁剪历史，避免超出模型上下文长度，再在最前面加上系统提示词
+	messages = a.buildContext(ctx, messages)
+	messages = utils.PrependSystemMessage(a.getSystemPrompt(), messages)
+
+	var modelMsg *model.Message
+	if cb == nil {
+		//调用模型生成回复，要求结构化格式时校验回答并在不符合时让模型修复
+		var schemaMsg *schema.Message
+		var err error
+		if s := responseSchemaFrom(ctx); s != nil {
+			schemaMsg, err = a.generateStructured(ctx, messages, s, a.generationOptions(ctx, "")...)
+		} else {
+			schemaMsg, err = a.getModel().GenerateResponse(ctx, messages, a.generationOptions(ctx, "")...)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ErrGenerationStopped
+			}
+			return nil, err
+		}
+		//将schema.Message转化成model.Message
+		modelMsg = utils.ConvertToModelMessage(a.SessionID, userName, schemaMsg)
+	} else {
+		content, err := a.getModel().StreamResponse(ctx, messages, cb, a.generationOptions(ctx, "")...)
+		stopped := err != nil && ctx.Err() != nil
+		if stopped && content == "" {
+			return nil, ErrGenerationStopped
+		}
+		if err != nil && !stopped {
+			return nil, err
+		}
+		//转化成model.Message
+		modelMsg = &model.Message{
+			SessionID: a.SessionID,
+			UserName:  userName,
+			Content:   content,
+			IsUser:    false,
+			Stopped:   stopped,
+		}
 	}
-	//转化成model.Message
-	modelMsg := &model.Message{
-		SessionID: a.SessionID,
-		UserName:  userName,
-		Content:   content,
-		IsUser:    false,
+
+	// 记录实际回答的模型（可能是备用模型）
+	modelMsg.ModelType = *answeredBy
+	if modelMsg.ModelType == "" {
+		modelMsg.ModelType = a.GetModelType()
 	}
+	answerUsage, otherUsages := usages.take(modelMsg.ModelType, messages, modelMsg.Content)
+	emitUsage(ctx, answerUsage)
 
 	//调用存储函数
-	a.AddMessage(modelMsg.Content, userName, false, true)
+	for _, msg := range tools.messages(a.SessionID, userName, modelMsg.ModelType) {
+		a.appendMessage(msg, true)
+	}
+	modelMsg.Kind = model.MessageKindAssistant
+	a.appendMessage(modelMsg, true)
+	a.saveActiveLeaf()
+	answered = true
+	a.saveUsage(userName, modelMsg.MessageID, modelMsg.ModelType, answerUsage, otherUsages)
 
 	return modelMsg, nil
 }
 
+// saveActiveLeaf 持久化当前分支的叶子消息，会话重新加载时据此恢复分支
+func (a *AIHelper) saveActiveLeaf() {
+	a.mu.RLock()
+	leaf := ""
+	if n := len(a.messages); n > 0 {
+		leaf = a.messages[n-1].MessageID
+	}
+	a.mu.RUnlock()
+
+	if err := session.UpdateActiveLeaf(a.SessionID, leaf); err != nil {
+		log.Printf("[AIHelper] session=%s save active leaf failed: %v", a.SessionID, err)
+	}
+}
+
 // GetModelType 获取模型类型
 func (a *AIHelper) GetModelType() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
 	return a.model.GetModelType()
 }
+
+// SetModel 替换当前会话使用的模型及其配置，消息历史保持不变，返回被替换的旧模型
+// 上下文策略按新模型重新创建，旧策略持有的模型随即释放
+func (a *AIHelper) SetModel(model_ AIModel, config map[string]interface{}) AIModel {
+	a.mu.Lock()
+	old, oldStrategy := a.model, a.strategy
+	a.model = model_
+	a.modelConfig = config
+	a.strategy = newContextStrategyForModel(model_.GetModelType())
+	a.mu.Unlock()
+	closeStrategy(oldStrategy)
+	return old
+}
+
+// buildContext 使用当前的上下文策略选出本轮发送给模型的消息，并保证工具调用与结果成对
+func (a *AIHelper) buildContext(ctx context.Context, messages []*schema.Message) []*schema.Message {
+	a.mu.RLock()
+	strategy := a.strategy
+	a.mu.RUnlock()
+	return pairToolMessages(strategy.BuildContext(ctx, a, messages))
+}
+
+// GetModelConfig 获取创建当前模型所用配置的副本
+func (a *AIHelper) GetModelConfig() map[string]interface{} {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	out := make(map[string]interface{}, len(a.modelConfig))
+	for k, v := range a.modelConfig {
+		out[k] = v
+	}
+	return out
+}
+
+// getSystemPrompt 获取会话的系统提示词
+func (a *AIHelper) getSystemPrompt() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.systemPrompt
+}
+
+// GenerationParams 计算 modelType 模型实际使用的生成参数并校验：模型默认值、会话参数、本次请求的参数依次覆盖
+// modelType 为空时使用会话当前的模型
+func (a *AIHelper) GenerationParams(modelType string, override model.GenerationParams) (model.GenerationParams, error) {
+	if modelType == "" {
+		modelType = a.GetModelType()
+	}
+	a.mu.RLock()
+	params := MergeGenerationParams(DefaultGenerationParams(modelType), a.genParams)
+	a.mu.RUnlock()
+	params = MergeGenerationParams(params, override)
+	return params, ValidateGenerationParams(modelType, params)
+}
+
+// generationOptions 将 modelType 模型实际使用的生成参数（含 ctx 中本次请求的参数）、结构化输出模式和停用的MCP服务转换为模型选项
+// 参数已在请求入口校验过，这里不再校验
+func (a *AIHelper) generationOptions(ctx context.Context, modelType string) []einomodel.Option {
+	opts, _ := a.validGenerationOptions(ctx, modelType)
+	return opts
+}
+
+// validGenerationOptions 同 generationOptions，生成参数超出 modelType 模型的范围时一并返回错误，用于切换到备用模型前重新校验
+func (a *AIHelper) validGenerationOptions(ctx context.Context, modelType string) ([]einomodel.Option, error) {
+	if modelType == "" {
+		modelType = a.GetModelType()
+	}
+	params, err := a.GenerationParams(modelType, requestGenerationParams(ctx))
+	var extraFields map[string]any
+	if s := responseSchemaFrom(ctx); s != nil {
+		if format := s.responseFormat(modelType); format != nil {
+			extraFields = map[string]any{"response_format": format}
+		}
+	}
+	opts := GenerationOptions(params, extraFields)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if len(a.disabledMCPServers) > 0 {
+		opts = append(opts, withDisabledMCPServers(a.disabledMCPServers))
+	}
+	return opts, err
+}
+
+// GetDisabledMCPServers 获取会话中停用的MCP服务名
+func (a *AIHelper) GetDisabledMCPServers() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return append([]string(nil), a.disabledMCPServers...)
+}
+
+// SetMCPServerEnabled 在会话中启用或停用一个MCP服务并持久化
+func (a *AIHelper) SetMCPServerEnabled(name string, enabled bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	disabled := make([]string, 0, len(a.disabledMCPServers)+1)
+	for _, n := range a.disabledMCPServers {
+		if n != name {
+			disabled = append(disabled, n)
+		}
+	}
+	if !enabled {
+		disabled = append(disabled, name)
+	}
+	if err := session.UpdateDisabledMCPServers(a.SessionID, disabled); err != nil {
+		return err
+	}
+	a.disabledMCPServers = disabled
+	return nil
+}
+
+// getModel 获取当前模型
+func (a *AIHelper) getModel() AIModel {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.model
+}
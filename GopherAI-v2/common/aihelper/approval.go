@@ -0,0 +1,116 @@
+package aihelper
+
+import (
+	"GopherAI/config"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// 工具调用策略
+const (
+	ToolPolicyAuto = "auto" // 直接调用
+	ToolPolicyAsk  = "ask"  // 流式对话中暂停，等待用户批准
+	ToolPolicyDeny = "deny" // 禁止调用
+)
+
+// defaultToolApprovalTimeout 未配置时等待用户批准的时间（秒）
+const defaultToolApprovalTimeout = 120
+
+// ErrApprovalNotFound 待批准的工具调用不存在、已处理或不属于当前用户
+var ErrApprovalNotFound = errors.New("tool approval not found")
+
+// ValidToolPolicy 是否为合法的工具调用策略，空字符串表示使用默认策略
+func ValidToolPolicy(policy string) bool {
+	switch policy {
+	case "", ToolPolicyAuto, ToolPolicyAsk, ToolPolicyDeny:
+		return true
+	}
+	return false
+}
+
+// ToolApproval 一次等待用户批准的工具调用
+type ToolApproval struct {
+	ID        string `json:"approvalId"`
+	Server    string `json:"server"`    // 工具所属的MCP服务
+	Tool      string `json:"tool"`      // 工具名（不含服务名前缀）
+	Arguments string `json:"arguments"` // 模型给出的调用参数（JSON）
+	Timeout   int    `json:"timeout"`   // 等待批准的时间（秒），超时视为拒绝
+}
+
+// ToolApprovalNotifier 工具调用需要用户批准时调用，用于通知前端
+type ToolApprovalNotifier func(approval *ToolApproval)
+
+type approvalNotifierKey struct{}
+
+// WithToolApprovalNotifier 返回带有审批通知的 ctx，只有支持审批的请求（流式对话）才设置
+// 未设置时策略为 ask 的工具调用会被直接拒绝
+func WithToolApprovalNotifier(ctx context.Context, notify ToolApprovalNotifier) context.Context {
+	return context.WithValue(ctx, approvalNotifierKey{}, notify)
+}
+
+// pendingApproval 等待中的审批
+type pendingApproval struct {
+	userName string
+	result   chan bool // 容量为 1，写入审批结果
+}
+
+var (
+	approvals   = make(map[string]*pendingApproval)
+	approvalsMu sync.Mutex
+)
+
+// ResolveToolApproval 批准或拒绝一次工具调用
+func ResolveToolApproval(userName string, approvalID string, approved bool) error {
+	approvalsMu.Lock()
+	defer approvalsMu.Unlock()
+	p, ok := approvals[approvalID]
+	if !ok || p.userName != userName {
+		return ErrApprovalNotFound
+	}
+	delete(approvals, approvalID)
+	p.result <- approved
+	return nil
+}
+
+// requestApproval 通知前端并等待用户批准工具调用；不支持审批、超时或请求取消时返回 false
+func requestApproval(ctx context.Context, userName string, server string, tool string, arguments string) bool {
+	notify, ok := ctx.Value(approvalNotifierKey{}).(ToolApprovalNotifier)
+	if !ok || notify == nil {
+		return false
+	}
+
+	timeout := config.GetConfig().ToolApprovalTimeout
+	if timeout <= 0 {
+		timeout = defaultToolApprovalTimeout
+	}
+	approval := &ToolApproval{
+		ID:        uuid.New().String(),
+		Server:    server,
+		Tool:      tool,
+		Arguments: arguments,
+		Timeout:   timeout,
+	}
+	p := &pendingApproval{userName: userName, result: make(chan bool, 1)}
+	approvalsMu.Lock()
+	approvals[approval.ID] = p
+	approvalsMu.Unlock()
+	defer func() {
+		approvalsMu.Lock()
+		delete(approvals, approval.ID)
+		approvalsMu.Unlock()
+	}()
+
+	notify(approval)
+	select {
+	case approved := <-p.result:
+		return approved
+	case <-ctx.Done():
+		return false
+	case <-time.After(time.Duration(timeout) * time.Second):
+		return false
+	}
+}
@@ -0,0 +1,146 @@
+package aihelper
+
+import (
+	"GopherAI/model"
+	"GopherAI/utils"
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// CompareCallback 对比模式的流式回调，modelType 为产生该片段的模型，会被多个协程同时调用
+type CompareCallback func(modelType string, msg string)
+
+// CompareResult 对比模式中一个模型的回答
+type CompareResult struct {
+	ModelType    string
+	Message      *model.Message   // 生成失败时为空
+	ToolMessages []*model.Message // 生成回答过程中的工具调用，位于问题和回答之间
+	Usage        *Usage
+	Err          error
+
+	otherUsages map[string]*Usage // 该模型回答过程中其他模型的用量
+}
+
+// Compare 用多个模型同时回答同一个问题：各模型基于相同的历史并发流式生成，
+// 每个回答都作为该问题下的一个分支保存，当前分支切到第一个成功的回答，用户可再切换到其他回答继续对话
+// events 不为空时为每个模型返回其生成过程中的事件回调
+// 所有模型都失败时返回第一个模型的错误
+func (a *AIHelper) Compare(userName string, ctx context.Context, cb CompareCallback, events func(modelType string) *StreamEvents, userQuestion string, modelTypes []string) ([]CompareResult, error) {
+	ctx, done := a.startGeneration(ctx)
+	defer done()
+
+	question := a.AddMessage(userQuestion, userName, true, true)
+
+	a.mu.RLock()
+	messages := utils.ConvertToSchemaMessages(a.messages)
+	config := make(map[string]interface{}, len(a.modelConfig))
+	for k, v := range a.modelConfig {
+		config[k] = v
+	}
+	a.mu.RUnlock()
+	messages = a.buildContext(ctx, messages)
+	messages = utils.PrependSystemMessage(a.getSystemPrompt(), messages)
+
+	results := make([]CompareResult, len(modelTypes))
+	var wg sync.WaitGroup
+	for i, modelType := range modelTypes {
+		wg.Add(1)
+		go func(i int, modelType string) {
+			defer wg.Done()
+			results[i].ModelType = modelType
+
+			// 对比时要求由指定的模型回答，不使用备用模型
+			m, err := GetGlobalFactory().createModel(ctx, modelType, config)
+			if err != nil {
+				results[i].Err = err
+				return
+			}
+			defer closeModel(m)
+
+			mctx, tools := withToolRecorder(ctx)
+			mctx, usages := withUsageRecorder(mctx)
+			if events != nil {
+				mctx = WithStreamEvents(mctx, events(modelType))
+			}
+			// 各模型的默认生成参数不同，分别计算
+			opts := a.generationOptions(ctx, modelType)
+			content, err := m.StreamResponse(mctx, messages, func(msg string) {
+				cb(modelType, msg)
+			}, opts...)
+			stopped := err != nil && ctx.Err() != nil
+			if err != nil && (!stopped || content == "") {
+				if stopped {
+					err = ErrGenerationStopped
+				}
+				results[i].Err = err
+				a.saveReportedUsage(userName, usages)
+				return
+			}
+			results[i].Usage, results[i].otherUsages = usages.take(modelType, messages, content)
+			emitUsage(mctx, results[i].Usage)
+
+			// 工具调用消息和回答串成该模型的分支
+			parentID := question.MessageID
+			toolMsgs := tools.messages(a.SessionID, userName, modelType)
+			for _, msg := range toolMsgs {
+				msg.MessageID = uuid.New().String()
+				msg.ParentID = parentID
+				parentID = msg.MessageID
+			}
+			results[i].ToolMessages = toolMsgs
+			results[i].Message = &model.Message{
+				MessageID: uuid.New().String(),
+				ParentID:  parentID,
+				SessionID: a.SessionID,
+				UserName:  userName,
+				Kind:      model.MessageKindAssistant,
+				Content:   content,
+				IsUser:    false,
+				Stopped:   stopped,
+				ModelType: modelType,
+			}
+		}(i, modelType)
+	}
+	wg.Wait()
+
+	// 按请求中的顺序保存回答，当前分支切到第一个成功的回答
+	a.mu.Lock()
+	var active *CompareResult
+	for i := range results {
+		r := &results[i]
+		if r.Message == nil {
+			continue
+		}
+		for _, msg := range r.ToolMessages {
+			a.tree.add(msg)
+		}
+		a.tree.add(r.Message)
+		if active == nil {
+			active = r
+		}
+	}
+	if active != nil {
+		a.messages = append(a.messages, active.ToolMessages...)
+		a.messages = append(a.messages, active.Message)
+	}
+	a.mu.Unlock()
+
+	if active == nil {
+		// 所有模型都失败，问题保留在当前分支上，与普通对话生成失败时一致
+		return results, results[0].Err
+	}
+	for _, r := range results {
+		if r.Message == nil {
+			continue
+		}
+		for _, msg := range r.ToolMessages {
+			a.saveFunc(msg)
+		}
+		a.saveFunc(r.Message)
+		a.saveUsage(userName, r.Message.MessageID, r.ModelType, r.Usage, r.otherUsages)
+	}
+	a.saveActiveLeaf()
+	return results, nil
+}
@@ -0,0 +1,319 @@
+package aihelper
+
+import (
+	"GopherAI/config"
+	"GopherAI/dao/summary"
+	"GopherAI/model"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// 上下文策略名称，对应配置中的 contextConfig.*.strategy
+const (
+	ContextStrategyAll      = "all"
+	ContextStrategySliding  = "sliding"
+	ContextStrategyKeepEnds = "keepEnds"
+	ContextStrategySummary  = "summary"
+)
+
+// ContextStrategy 从会话的完整历史中选出本轮发送给模型的消息
+type ContextStrategy interface {
+	BuildContext(ctx context.Context, a *AIHelper, history []*schema.Message) []*schema.Message
+}
+
+// NewContextStrategy 根据配置创建上下文策略，未知策略按 sliding 处理
+func NewContextStrategy(conf config.ContextConfig) ContextStrategy {
+	switch conf.Strategy {
+	case ContextStrategyAll:
+		return allStrategy{}
+	case ContextStrategyKeepEnds:
+		return &keepEndsStrategy{maxTokens: conf.MaxTokens, keepFirst: conf.KeepFirst, keepLast: conf.KeepLast}
+	case ContextStrategySummary:
+		summaryModel := conf.SummaryModel
+		if summaryModel == "" {
+			summaryModel = defaultModelType
+		}
+		return &summaryStrategy{maxTokens: conf.MaxTokens, keepLast: conf.KeepLast, summaryModel: summaryModel}
+	default:
+		return &slidingStrategy{maxTokens: conf.MaxTokens}
+	}
+}
+
+// newContextStrategyForModel 按模型类型读取配置创建上下文策略
+func newContextStrategyForModel(modelType string) ContextStrategy {
+	return NewContextStrategy(config.GetConfig().GetContextConfig(modelType))
+}
+
+// closeStrategy 释放上下文策略持有的模型（如摘要模型）
+func closeStrategy(s ContextStrategy) {
+	if closer, ok := s.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// getStrategy 获取当前的上下文策略
+func (a *AIHelper) getStrategy() ContextStrategy {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.strategy
+}
+
+// =================== all：发送全部历史 ===================
+
+type allStrategy struct{}
+
+func (allStrategy) BuildContext(ctx context.Context, a *AIHelper, history []*schema.Message) []*schema.Message {
+	return history
+}
+
+// =================== sliding：按 token 预算保留最近的消息 ===================
+
+type slidingStrategy struct {
+	maxTokens int
+}
+
+func (s *slidingStrategy) BuildContext(ctx context.Context, a *AIHelper, history []*schema.Message) []*schema.Message {
+	return trimToBudget(history, s.maxTokens)
+}
+
+// trimToBudget 保留开头的 system 消息，其余消息从最新往前保留，直到超出预算
+// 最后一条消息（本轮问题）无论如何都会保留
+func trimToBudget(messages []*schema.Message, maxTokens int) []*schema.Message {
+	if maxTokens <= 0 || CountMessagesTokens(messages) <= maxTokens {
+		return messages
+	}
+
+	head := 0
+	for head < len(messages) && messages[head].Role == schema.System {
+		head++
+	}
+	used := CountMessagesTokens(messages[:head])
+
+	start := len(messages)
+	for start > head {
+		cost := CountMessagesTokens(messages[start-1 : start])
+		if used+cost > maxTokens && start < len(messages) {
+			break
+		}
+		used += cost
+		start--
+	}
+
+	out := make([]*schema.Message, 0, head+len(messages)-start)
+	out = append(out, messages[:head]...)
+	return append(out, messages[start:]...)
+}
+
+// =================== keepEnds：保留最前 N 条和最近 M 条 ===================
+
+type keepEndsStrategy struct {
+	maxTokens int
+	keepFirst int
+	keepLast  int
+}
+
+func (s *keepEndsStrategy) BuildContext(ctx context.Context, a *AIHelper, history []*schema.Message) []*schema.Message {
+	first := min(s.keepFirst, len(history))
+	tailStart := max(len(history)-s.keepLast, first)
+	if s.keepLast <= 0 {
+		tailStart = first
+	}
+	head := history[:first]
+	tail := history[tailStart:]
+
+	// 仍超出预算时从 tail 的较早部分开始丢弃
+	budget := s.maxTokens - CountMessagesTokens(head)
+	if s.maxTokens > 0 {
+		for len(tail) > 1 && CountMessagesTokens(tail) > budget {
+			tail = tail[1:]
+		}
+	}
+
+	out := make([]*schema.Message, 0, len(head)+len(tail))
+	out = append(out, head...)
+	return append(out, tail...)
+}
+
+// =================== summary：滚动摘要较早的对话 ===================
+
+type summaryStrategy struct {
+	maxTokens    int
+	keepLast     int
+	summaryModel string
+
+	mu  sync.Mutex
+	llm AIModel // 摘要模型，第一次摘要时创建，之后复用
+}
+
+func (s *summaryStrategy) BuildContext(ctx context.Context, a *AIHelper, history []*schema.Message) []*schema.Message {
+	content, covered := a.getSummary()
+	covered = min(covered, len(history))
+
+	messages := withSummary(content, history[covered:])
+	if s.maxTokens <= 0 || CountMessagesTokens(messages) <= s.maxTokens {
+		return messages
+	}
+
+	// 超出预算：把最近 keepLast 条之前、尚未摘要的消息压缩进摘要
+	cut := max(len(history)-max(s.keepLast, 1), covered)
+	if cut > covered {
+		newContent, err := s.summarize(ctx, a, content, history[covered:cut])
+		if err != nil {
+			log.Printf("[summaryStrategy] session=%s summarize failed: %v", a.SessionID, err)
+		} else {
+			a.saveSummary(newContent, cut)
+			messages = withSummary(newContent, history[cut:])
+		}
+	}
+
+	// 摘要后仍可能超出预算（或摘要失败），用滑动窗口兜底
+	return trimToBudget(messages, s.maxTokens)
+}
+
+// model 获取摘要模型，第一次使用时按会话的模型配置创建（rag、mcp 等模型需要其中的用户名和知识库）
+func (s *summaryStrategy) model(ctx context.Context, a *AIHelper) (AIModel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.llm == nil {
+		llm, err := GetGlobalFactory().CreateAIModel(ctx, s.summaryModel, a.GetModelConfig())
+		if err != nil {
+			return nil, err
+		}
+		s.llm = llm
+	}
+	return s.llm, nil
+}
+
+// Close 释放摘要模型
+func (s *summaryStrategy) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.llm != nil {
+		closeModel(s.llm)
+		s.llm = nil
+	}
+}
+
+// summarize 调用摘要模型，将旧摘要与新增消息合并为新的摘要
+func (s *summaryStrategy) summarize(ctx context.Context, a *AIHelper, previous string, messages []*schema.Message) (string, error) {
+	llm, err := s.model(ctx, a)
+	if err != nil {
+		return "", err
+	}
+
+	var transcript strings.Builder
+	for _, msg := range messages {
+		switch {
+		case msg.Role == schema.User:
+			transcript.WriteString(fmt.Sprintf("用户: %s\n", msg.Content))
+		case msg.Role == schema.Tool:
+			transcript.WriteString(fmt.Sprintf("工具 %s 返回: %s\n", msg.ToolName, msg.Content))
+		case len(msg.ToolCalls) > 0:
+			for _, call := range msg.ToolCalls {
+				transcript.WriteString(fmt.Sprintf("助手调用工具 %s: %s\n", call.Function.Name, call.Function.Arguments))
+			}
+		default:
+			transcript.WriteString(fmt.Sprintf("助手: %s\n", msg.Content))
+		}
+	}
+
+	prompt := fmt.Sprintf(`请将以下对话内容与已有摘要合并，生成一份简洁的新摘要。
+保留用户的目标、关键事实、已得出的结论和尚未解决的问题，不要编造内容。
+
+已有摘要：
+%s
+
+新增对话：
+%s`, previous, transcript.String())
+
+	resp, err := llm.GenerateResponse(ctx, []*schema.Message{
+		schema.SystemMessage("你是一个对话摘要助手，只输出摘要正文。"),
+		schema.UserMessage(prompt),
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.Content, nil
+}
+
+// withSummary 在消息前插入摘要
+func withSummary(content string, messages []*schema.Message) []*schema.Message {
+	if content == "" {
+		return messages
+	}
+	out := make([]*schema.Message, 0, len(messages)+1)
+	out = append(out, schema.SystemMessage("以下是此前对话的摘要，请结合摘要继续对话：\n"+content))
+	return append(out, messages...)
+}
+
+// saveSummary 更新内存中的摘要并持久化，摘要覆盖当前分支的前 covered 条消息
+func (a *AIHelper) saveSummary(content string, covered int) {
+	a.mu.Lock()
+	if covered <= 0 || covered > len(a.messages) {
+		a.mu.Unlock()
+		return
+	}
+	s := &model.Summary{
+		SessionID:     a.SessionID,
+		Content:       content,
+		LastMessageID: a.messages[covered-1].MessageID,
+	}
+	a.summary = s
+	a.mu.Unlock()
+
+	if err := summary.SaveSummary(s); err != nil {
+		log.Printf("[summaryStrategy] session=%s save summary failed: %v", a.SessionID, err)
+	}
+}
+
+// getSummary 获取当前摘要及其覆盖的当前分支消息数，摘要不在当前分支上时返回空
+func (a *AIHelper) getSummary() (string, int) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.summary == nil {
+		return "", 0
+	}
+	for i, msg := range a.messages {
+		if msg.MessageID == a.summary.LastMessageID {
+			return a.summary.Content, i + 1
+		}
+	}
+	return "", 0
+}
+
+// pairToolMessages 按上下文策略裁剪后，工具调用和工具结果可能不再成对，模型接口会拒绝这样的消息：
+// 去掉没有对应调用的工具结果，以及结果不完整的工具调用
+func pairToolMessages(messages []*schema.Message) []*schema.Message {
+	out := make([]*schema.Message, 0, len(messages))
+	for i := 0; i < len(messages); i++ {
+		msg := messages[i]
+		switch {
+		case msg.Role == schema.Tool:
+			// 与调用成对的结果在处理调用时已经加入
+		case msg.Role == schema.Assistant && len(msg.ToolCalls) > 0:
+			end := i + 1
+			answered := make(map[string]bool)
+			for ; end < len(messages) && messages[end].Role == schema.Tool; end++ {
+				answered[messages[end].ToolCallID] = true
+			}
+			complete := true
+			for _, call := range msg.ToolCalls {
+				complete = complete && answered[call.ID]
+			}
+			if complete {
+				out = append(out, messages[i:end]...)
+			} else if msg.Content != "" {
+				out = append(out, schema.AssistantMessage(msg.Content, nil))
+			}
+			i = end - 1
+		default:
+			out = append(out, msg)
+		}
+	}
+	return out
+}
@@ -0,0 +1,118 @@
+package aihelper
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// tokens 估算为 n 个 token 的英文内容，加上每条消息的开销后一条消息为 n+4 个 token
+func tokens(n int) string {
+	return strings.Repeat("abcd", n)
+}
+
+func contents(msgs []*schema.Message) []string {
+	out := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		out = append(out, msg.Content)
+	}
+	return out
+}
+
+func TestTrimToBudget(t *testing.T) {
+	system := schema.SystemMessage(tokens(6)) // 10
+	q1 := schema.UserMessage(tokens(6))       // 10
+	a1 := schema.AssistantMessage(tokens(6), nil)
+	q2 := schema.UserMessage(tokens(16)) // 20
+
+	tests := []struct {
+		name      string
+		messages  []*schema.Message
+		maxTokens int
+		want      []*schema.Message
+	}{
+		{name: "no limit", messages: []*schema.Message{system, q1, a1, q2}, maxTokens: 0, want: []*schema.Message{system, q1, a1, q2}},
+		{name: "within budget", messages: []*schema.Message{system, q1, a1, q2}, maxTokens: 50, want: []*schema.Message{system, q1, a1, q2}},
+		{name: "drop oldest", messages: []*schema.Message{system, q1, a1, q2}, maxTokens: 40, want: []*schema.Message{system, a1, q2}},
+		{name: "keep system", messages: []*schema.Message{system, q1, a1, q2}, maxTokens: 30, want: []*schema.Message{system, q2}},
+		// 本轮问题超出预算时仍然保留
+		{name: "keep last", messages: []*schema.Message{system, q1, a1, q2}, maxTokens: 15, want: []*schema.Message{system, q2}},
+		{name: "no system", messages: []*schema.Message{q1, a1, q2}, maxTokens: 30, want: []*schema.Message{a1, q2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := trimToBudget(tt.messages, tt.maxTokens)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %d messages %v, want %d", len(got), contents(got), len(tt.want))
+			}
+		})
+	}
+}
+
+func toolCall(id string) schema.ToolCall {
+	return schema.ToolCall{ID: id, Function: schema.FunctionCall{Name: "search", Arguments: "{}"}}
+}
+
+func TestPairToolMessages(t *testing.T) {
+	question := schema.UserMessage("问题")
+	answer := schema.AssistantMessage("回答", nil)
+	call := schema.AssistantMessage("", []schema.ToolCall{toolCall("c1"), toolCall("c2")})
+	callWithText := schema.AssistantMessage("我先查一下", []schema.ToolCall{toolCall("c1"), toolCall("c2")})
+	result1 := schema.ToolMessage("结果1", "c1")
+	result2 := schema.ToolMessage("结果2", "c2")
+
+	tests := []struct {
+		name     string
+		messages []*schema.Message
+		want     []string // 期望的消息内容，工具调用消息内容为空
+		calls    int      // 期望保留的工具调用消息数
+	}{
+		{
+			name:     "complete pair",
+			messages: []*schema.Message{question, call, result1, result2, answer},
+			want:     []string{"问题", "", "结果1", "结果2", "回答"},
+			calls:    1,
+		},
+		{
+			// 裁剪掉了工具调用，只剩结果
+			name:     "orphan results",
+			messages: []*schema.Message{result1, result2, answer},
+			want:     []string{"回答"},
+		},
+		{
+			name:     "incomplete call",
+			messages: []*schema.Message{question, call, result1, answer},
+			want:     []string{"问题", "回答"},
+		},
+		{
+			// 结果不完整但调用带有文字时保留文字
+			name:     "incomplete call with text",
+			messages: []*schema.Message{question, callWithText, result2, answer},
+			want:     []string{"问题", "我先查一下", "回答"},
+		},
+		{
+			name:     "no tools",
+			messages: []*schema.Message{question, answer},
+			want:     []string{"问题", "回答"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := pairToolMessages(tt.messages)
+			if !reflect.DeepEqual(contents(got), tt.want) {
+				t.Fatalf("got %q, want %q", contents(got), tt.want)
+			}
+			calls := 0
+			for _, msg := range got {
+				if len(msg.ToolCalls) > 0 {
+					calls++
+				}
+			}
+			if calls != tt.calls {
+				t.Fatalf("got %d tool call messages, want %d", calls, tt.calls)
+			}
+		})
+	}
+}
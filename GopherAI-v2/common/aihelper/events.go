@@ -0,0 +1,121 @@
+package aihelper
+
+import (
+	"context"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// maxSourceSnippet 下发给前端的参考文档片段最多保留的字符数
+const maxSourceSnippet = 300
+
+// ToolCallEvent 模型发起的一次工具调用
+type ToolCallEvent struct {
+	CallID    string `json:"callId"`
+	Name      string `json:"name"`      // 交给模型的工具名（含服务名前缀）
+	Arguments string `json:"arguments"` // 调用参数（JSON）
+}
+
+// ToolResultEvent 一次工具调用的结果
+type ToolResultEvent struct {
+	CallID    string `json:"callId"`
+	Name      string `json:"name"`
+	Result    string `json:"result"`          // 交给模型的结果文本
+	Error     string `json:"error,omitempty"` // 调用失败或被拒绝的原因
+	LatencyMs int64  `json:"latencyMs"`
+}
+
+// StepEvent 模型在调用工具前输出的文字，不属于最终回答
+type StepEvent struct {
+	Content string `json:"content"`
+}
+
+// Source 知识库检索到的一篇参考文档
+type Source struct {
+	ID       string                 `json:"id"`
+	Snippet  string                 `json:"snippet"`
+	Score    float64                `json:"score,omitempty"`
+	MetaData map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// Usage 一次生成的 token 用量
+type Usage struct {
+	PromptTokens     int  `json:"promptTokens"`
+	CompletionTokens int  `json:"completionTokens"`
+	TotalTokens      int  `json:"totalTokens"`
+	Estimated        bool `json:"estimated,omitempty"` // 服务商未返回用量，按本地规则估算
+}
+
+// StreamEvents 流式生成过程中除文字片段外需要通知前端的事件，不需要的回调可为空
+type StreamEvents struct {
+	Step       func(step *StepEvent)
+	ToolCall   func(call *ToolCallEvent)
+	ToolResult func(result *ToolResultEvent)
+	Sources    func(sources []Source)
+	Usage      func(usage *Usage)
+}
+
+type streamEventsKey struct{}
+
+// WithStreamEvents 返回带有事件回调的 ctx，生成过程中的工具调用、参考文档和用量通过它通知调用方
+func WithStreamEvents(ctx context.Context, events *StreamEvents) context.Context {
+	return context.WithValue(ctx, streamEventsKey{}, events)
+}
+
+// streamEventsFrom 取出 ctx 中的事件回调，未设置时返回空回调
+func streamEventsFrom(ctx context.Context) *StreamEvents {
+	if events, ok := ctx.Value(streamEventsKey{}).(*StreamEvents); ok && events != nil {
+		return events
+	}
+	return &StreamEvents{}
+}
+
+func emitStep(ctx context.Context, step *StepEvent) {
+	markSideEffect(ctx)
+	if events := streamEventsFrom(ctx); events.Step != nil {
+		events.Step(step)
+	}
+}
+
+func emitToolCall(ctx context.Context, call *ToolCallEvent) {
+	markSideEffect(ctx)
+	if events := streamEventsFrom(ctx); events.ToolCall != nil {
+		events.ToolCall(call)
+	}
+}
+
+func emitToolResult(ctx context.Context, result *ToolResultEvent) {
+	markSideEffect(ctx)
+	if events := streamEventsFrom(ctx); events.ToolResult != nil {
+		events.ToolResult(result)
+	}
+}
+
+// emitSources 通知检索到的参考文档，文档内容截断为片段
+func emitSources(ctx context.Context, docs []*schema.Document) {
+	events := streamEventsFrom(ctx)
+	if events.Sources == nil || len(docs) == 0 {
+		return
+	}
+	markSideEffect(ctx)
+	sources := make([]Source, 0, len(docs))
+	for _, doc := range docs {
+		snippet := []rune(doc.Content)
+		if len(snippet) > maxSourceSnippet {
+			snippet = snippet[:maxSourceSnippet]
+		}
+		sources = append(sources, Source{
+			ID:       doc.ID,
+			Snippet:  string(snippet),
+			Score:    doc.Score(),
+			MetaData: doc.MetaData,
+		})
+	}
+	events.Sources(sources)
+}
+
+func emitUsage(ctx context.Context, usage *Usage) {
+	if events := streamEventsFrom(ctx); events.Usage != nil {
+		events.Usage(usage)
+	}
+}
@@ -1,17 +1,64 @@
 package aihelper
 
 import (
+	"GopherAI/config"
 	"context"
+	"encoding/json"
 	"fmt"
+	"log"
 	"sync"
 )
 
 // ModelCreator 定义模型创建函数类型（需要 context）
 type ModelCreator func(ctx context.Context, config map[string]interface{}) (AIModel, error)
 
+// 注册表中模型的接入方式
+const (
+	ProviderOpenAI = "openai" // OpenAI 兼容接口
+	ProviderOllama = "ollama"
+	ProviderRAG    = "rag"
+	ProviderMCP    = "mcp"
+	ProviderMock   = "mock" // 模拟模型，不调用任何服务
+)
+
+// providerCreator 按注册表中的配置和会话的模型配置创建模型
+type providerCreator func(ctx context.Context, conf config.ModelConfig, sessionConfig map[string]interface{}) (AIModel, error)
+
+var providerCreators = map[string]providerCreator{
+	ProviderOpenAI: func(ctx context.Context, conf config.ModelConfig, sessionConfig map[string]interface{}) (AIModel, error) {
+		return NewOpenAIModel(ctx, conf)
+	},
+	// Ollama 本地模型
+	ProviderOllama: func(ctx context.Context, conf config.ModelConfig, sessionConfig map[string]interface{}) (AIModel, error) {
+		return NewOllamaModel(ctx, conf)
+	},
+	// 阿里百炼 RAG 模型
+	ProviderRAG: func(ctx context.Context, conf config.ModelConfig, sessionConfig map[string]interface{}) (AIModel, error) {
+		username, ok := sessionConfig["username"].(string)
+		if !ok {
+			return nil, fmt.Errorf("RAG model requires username")
+		}
+		knowledgeBase, _ := sessionConfig["knowledgeBase"].(string)
+		return NewAliRAGModel(ctx, conf, username, knowledgeBase)
+	},
+	// MCP 模型（集成MCP服务）
+	ProviderMCP: func(ctx context.Context, conf config.ModelConfig, sessionConfig map[string]interface{}) (AIModel, error) {
+		username, ok := sessionConfig["username"].(string)
+		if !ok {
+			return nil, fmt.Errorf("MCP model requires username")
+		}
+		return NewMCPModel(ctx, conf, username)
+	},
+	// 模拟模型，用于离线开发和端到端测试
+	ProviderMock: func(ctx context.Context, conf config.ModelConfig, sessionConfig map[string]interface{}) (AIModel, error) {
+		return NewMockModel(conf)
+	},
+}
+
 // AIModelFactory AI模型工厂
 type AIModelFactory struct {
 	creators map[string]ModelCreator
+	catalog  []config.ModelConfig // 注册表中成功注册的模型，保持配置文件中的顺序
 }
 
 var (
@@ -25,51 +72,66 @@ func GetGlobalFactory() *AIModelFactory {
 		globalFactory = &AIModelFactory{
 			creators: make(map[string]ModelCreator),
 		}
-		globalFactory.registerCreators()
+		globalFactory.registerCreators(config.GetConfig().Models)
 	})
 	return globalFactory
 }
 
-// 注册模型
-func (f *AIModelFactory) registerCreators() {
-	//OpenAI
-	f.creators["1"] = func(ctx context.Context, config map[string]interface{}) (AIModel, error) {
-		return NewOpenAIModel(ctx)
-	}
-
-	// 阿里百炼 RAG 模型
-	f.creators["2"] = func(ctx context.Context, config map[string]interface{}) (AIModel, error) {
-		username, ok := config["username"].(string)
+// 按注册表注册模型
+func (f *AIModelFactory) registerCreators(models []config.ModelConfig) {
+	for _, conf := range models {
+		create, ok := providerCreators[conf.Provider]
 		if !ok {
-			return nil, fmt.Errorf("RAG model requires username")
+			log.Printf("[AIModelFactory] skip model %q: unsupported provider %q", conf.ID, conf.Provider)
+			continue
 		}
-		return NewAliRAGModel(ctx, username)
-	}
-
-	// MCP 模型（集成MCP服务）
-	f.creators["3"] = func(ctx context.Context, config map[string]interface{}) (AIModel, error) {
-		username, ok := config["username"].(string)
-		if !ok {
-			return nil, fmt.Errorf("MCP model requires username")
+		if _, exists := f.creators[conf.ID]; exists {
+			log.Printf("[AIModelFactory] skip model %q: duplicate id", conf.ID)
+			continue
 		}
-		return NewMCPModel(ctx, username)
+		conf := conf
+		f.creators[conf.ID] = func(ctx context.Context, sessionConfig map[string]interface{}) (AIModel, error) {
+			return create(ctx, conf, sessionConfig)
+		}
+		f.catalog = append(f.catalog, conf)
 	}
+}
 
-	//Ollama（目前提供接口实现，暂不提供应用，因为考虑到本地模型会占用很多空间）todo做
-	f.creators["4"] = func(ctx context.Context, config map[string]interface{}) (AIModel, error) {
-		baseURL, _ := config["baseURL"].(string)
-		modelName, ok := config["modelName"].(string)
-		if !ok {
-			return nil, fmt.Errorf("Ollama model requires modelName")
+// Models 获取注册表中的模型目录
+func (f *AIModelFactory) Models() []config.ModelConfig {
+	out := make([]config.ModelConfig, len(f.catalog))
+	copy(out, f.catalog)
+	return out
+}
+
+// CreateAIModel 根据类型创建 AI 模型，注册表中的模型会带上重试、备用模型切换和熔断
+// 开启了语义缓存且会话配置中有用户名时，再在最外层加上语义缓存
+func (f *AIModelFactory) CreateAIModel(ctx context.Context, modelType string, config map[string]interface{}) (AIModel, error) {
+	m, err := f.createModel(ctx, modelType, config)
+	if err != nil {
+		return nil, err
+	}
+	var fallbacks []string
+	semanticCache := false
+	for _, conf := range f.catalog {
+		if conf.ID == modelType {
+			fallbacks = conf.Fallbacks
+			semanticCache = conf.SemanticCache
+			break
 		}
-		return NewOllamaModel(ctx, baseURL, modelName)
 	}
-	// 阿里百炼 mcp 模型
+	resilient := newResilientModel(m, fallbacks, config)
 
+	username, _ := config["username"].(string)
+	if !semanticCache || username == "" {
+		return resilient, nil
+	}
+	knowledgeBase, _ := config["knowledgeBase"].(string)
+	return newSemanticCacheModel(resilient, username, knowledgeBase), nil
 }
 
-// CreateAIModel 根据类型创建 AI 模型
-func (f *AIModelFactory) CreateAIModel(ctx context.Context, modelType string, config map[string]interface{}) (AIModel, error) {
+// createModel 创建不带重试和备用模型的原始模型
+func (f *AIModelFactory) createModel(ctx context.Context, modelType string, config map[string]interface{}) (AIModel, error) {
 	creator, ok := f.creators[modelType]
 	if !ok {
 		return nil, fmt.Errorf("unsupported model type: %s", modelType)
@@ -83,10 +145,39 @@ func (f *AIModelFactory) CreateAIHelper(ctx context.Context, modelType string, S
 	if err != nil {
 		return nil, err
 	}
-	return NewAIHelper(model, SessionID), nil
+	helper := NewAIHelper(model, SessionID)
+	helper.modelConfig = config
+	return helper, nil
+}
+
+// HasModelType 判断模型类型是否已注册
+func (f *AIModelFactory) HasModelType(modelType string) bool {
+	_, ok := f.creators[modelType]
+	return ok
 }
 
 // RegisterModel 可扩展注册
 func (f *AIModelFactory) RegisterModel(modelType string, creator ModelCreator) {
 	f.creators[modelType] = creator
 }
+
+// EncodeModelConfig 将模型配置编码为 JSON，便于随会话持久化
+func EncodeModelConfig(config map[string]interface{}) string {
+	if len(config) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// DecodeModelConfig 解析随会话持久化的模型配置，解析失败时返回空配置
+func DecodeModelConfig(data string) map[string]interface{} {
+	config := make(map[string]interface{})
+	if data != "" {
+		_ = json.Unmarshal([]byte(data), &config)
+	}
+	return config
+}
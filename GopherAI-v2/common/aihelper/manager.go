@@ -1,41 +1,130 @@
 package aihelper
 
 import (
+	"GopherAI/common/cache"
+	"GopherAI/config"
+	"GopherAI/dao/message"
+	"GopherAI/dao/session"
+	"GopherAI/dao/summary"
+	"GopherAI/model"
+	"container/list"
 	"context"
+	"errors"
+	"log"
 	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 var ctx = context.Background()
 
+// defaultModelType 未保存模型类型的旧会话默认使用 OpenAI 模型
+const defaultModelType = "1"
+
+// ErrSessionNotFound 会话不存在或不属于当前用户
+var ErrSessionNotFound = errors.New("session not found")
+
+// helperEntry LRU 链表中的节点
+type helperEntry struct {
+	userName   string
+	sessionID  string
+	helper     *AIHelper
+	lastAccess time.Time
+}
+
 // AIHelperManager AI助手管理器，管理用户-会话-AIHelper的映射关系
+// AIHelper 在首次访问时从数据库加载，超出容量或空闲过久时被淘汰，再次访问时重新加载
 type AIHelperManager struct {
-	helpers map[string]map[string]*AIHelper // map[用户账号（唯一）]map[会话ID]*AIHelper
-	mu      sync.RWMutex
+	helpers    map[string]map[string]*list.Element // map[用户账号（唯一）]map[会话ID]*list.Element
+	lru        *list.List                          // 最近访问的节点在队头
+	maxHelpers int
+	idleTTL    time.Duration
+	mu         sync.Mutex
 }
 
 // NewAIHelperManager 创建新的管理器实例
 func NewAIHelperManager() *AIHelperManager {
+	conf := config.GetConfig().AIHelperConfig
 	return &AIHelperManager{
-		helpers: make(map[string]map[string]*AIHelper),
+		helpers:    make(map[string]map[string]*list.Element),
+		lru:        list.New(),
+		maxHelpers: conf.MaxHelpers,
+		idleTTL:    time.Duration(conf.IdleTTL) * time.Second,
 	}
 }
 
-// 获取或创建AIHelper
+// 获取或创建AIHelper，内存中不存在时会从数据库加载该会话的历史消息和模型配置
+// modelType 只用于没有保存模型的旧会话，不会切换已有会话的模型，切换需在持有轮次时调用 SwitchModel
+// 返回的AIHelper被固定，不会被淘汰，使用完后需调用 Unpin
 func (m *AIHelperManager) GetOrCreateAIHelper(userName string, sessionID string, modelType string, config map[string]interface{}) (*AIHelper, error) {
+	if helper, ok := m.pinAIHelper(userName, sessionID); ok {
+		return helper, nil
+	}
+	return m.loadAIHelper(userName, sessionID, modelType, config)
+}
+
+// pinAIHelper 查找内存中的AIHelper并固定
+func (m *AIHelperManager) pinAIHelper(userName string, sessionID string) (*AIHelper, bool) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// 获取用户的会话映射
-	userHelpers, exists := m.helpers[userName]
+	elem, exists := m.lookup(userName, sessionID)
 	if !exists {
-		userHelpers = make(map[string]*AIHelper)
-		m.helpers[userName] = userHelpers
+		return nil, false
 	}
+	m.touch(elem)
+	helper := elem.Value.(*helperEntry).helper
+	helper.pins.Add(1)
+	return helper, true
+}
 
-	// 检查会话是否已存在
-	helper, exists := userHelpers[sessionID]
-	if exists {
-		return helper, nil
+// SwitchModel 为会话切换模型：保留消息历史，重建 AIModel 并持久化新的模型配置
+// 调用方需持有会话的轮次，否则旧模型可能在使用中被关闭
+// 会话原有的配置（如助手配置指定的知识库）会保留，config 中的同名项覆盖原值
+func (m *AIHelperManager) SwitchModel(helper *AIHelper, modelType string, config map[string]interface{}) error {
+	if modelType == helper.GetModelType() {
+		return nil
+	}
+	log.Printf("[AIHelperManager] session=%s switch model %s -> %s", helper.SessionID, helper.GetModelType(), modelType)
+
+	merged := helper.GetModelConfig()
+	for k, v := range config {
+		merged[k] = v
+	}
+
+	newModel, err := GetGlobalFactory().CreateAIModel(ctx, modelType, merged)
+	if err != nil {
+		return err
+	}
+	if err := session.UpdateSessionModel(helper.SessionID, modelType, EncodeModelConfig(merged)); err != nil {
+		closeModel(newModel)
+		return err
+	}
+	closeModel(helper.SetModel(newModel, merged))
+	return nil
+}
+
+// loadAIHelper 从数据库加载会话并创建AIHelper放入管理器，返回的AIHelper已被固定
+func (m *AIHelperManager) loadAIHelper(userName string, sessionID string, modelType string, config map[string]interface{}) (*AIHelper, error) {
+	// 加载历史消息和创建模型都比较耗时，不持有锁
+	sess, msgs, err := loadSession(userName, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 优先使用会话上保存的模型；旧数据没有保存时使用请求的模型，并补写到会话上
+	if sess.ModelType != "" {
+		modelType = sess.ModelType
+		config = DecodeModelConfig(sess.ModelConfig)
+	} else {
+		if modelType == "" {
+			modelType = defaultModelType
+		}
+		if err := session.UpdateSessionModel(sessionID, modelType, EncodeModelConfig(config)); err != nil {
+			log.Printf("[AIHelperManager] save model of session=%s failed: %v", sessionID, err)
+		}
 	}
 
 	// 创建新的AIHelper
@@ -44,23 +133,112 @@ func (m *AIHelperManager) GetOrCreateAIHelper(userName string, sessionID string,
 	if err != nil {
 		return nil, err
 	}
+	// 添加消息到内存中(不开启存储功能)
+	helper.loadMessages(msgs, sess.ActiveLeafID)
+	if s, err := summary.GetSummaryBySessionID(sessionID); err == nil {
+		helper.summary = s
+	}
+	helper.systemPrompt = sess.SystemPrompt
+	helper.genParams = sess.GenerationParams
+	helper.disabledMCPServers = sess.DisabledMCPServers
 
-	userHelpers[sessionID] = helper
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// 加载期间可能已有其他请求创建了同一个会话的AIHelper
+	if elem, ok := m.lookup(userName, sessionID); ok {
+		closeModel(helper.model)
+		m.touch(elem)
+		existing := elem.Value.(*helperEntry).helper
+		existing.pins.Add(1)
+		return existing, nil
+	}
+	helper.pins.Add(1)
+
+	userHelpers, exists := m.helpers[userName]
+	if !exists {
+		userHelpers = make(map[string]*list.Element)
+		m.helpers[userName] = userHelpers
+	}
+	userHelpers[sessionID] = m.lru.PushFront(&helperEntry{
+		userName:   userName,
+		sessionID:  sessionID,
+		helper:     helper,
+		lastAccess: time.Now(),
+	})
+
+	m.evictOverflow()
 	return helper, nil
 }
 
-// 获取指定用户的指定会话的AIHelper
+// loadSession 校验会话归属并读取会话及其历史消息
+func loadSession(userName string, sessionID string) (*model.Session, []model.Message, error) {
+	sess, err := session.GetSessionByID(sessionID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrSessionNotFound
+		}
+		return nil, nil, err
+	}
+	if sess.UserName != userName {
+		return nil, nil, ErrSessionNotFound
+	}
+	msgs, err := message.GetMessagesBySessionID(sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	backfillMessageTree(msgs)
+	return sess, msgs, nil
+}
+
+// backfillMessageTree 为没有消息ID的旧数据生成ID，并按时间顺序串成一条分支
+func backfillMessageTree(msgs []model.Message) {
+	parentID := ""
+	for i := range msgs {
+		m := &msgs[i]
+		if m.MessageID == "" {
+			m.MessageID = uuid.New().String()
+			m.ParentID = parentID
+			if err := message.UpdateMessageTree(m.ID, m.MessageID, m.ParentID); err != nil {
+				log.Printf("[AIHelperManager] backfill message id=%d failed: %v", m.ID, err)
+			}
+		}
+		parentID = m.MessageID
+	}
+}
+
+// closeModel 释放模型持有的连接（如 MCP 客户端）
+func closeModel(m AIModel) {
+	if closer, ok := m.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// GetSessionHistory 获取会话当前分支的历史记录，内存中没有时直接读数据库，不会创建AIHelper
+func (m *AIHelperManager) GetSessionHistory(userName string, sessionID string) ([]model.History, error) {
+	if helper, ok := m.GetAIHelper(userName, sessionID); ok {
+		return helper.GetHistory(), nil
+	}
+
+	sess, msgs, err := loadSession(userName, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	tree, path := buildMessageTree(msgs, sess.ActiveLeafID)
+	return tree.history(path), nil
+}
+
+// 获取指定用户的指定会话的AIHelper（仅查找内存）
 func (m *AIHelperManager) GetAIHelper(userName string, sessionID string) (*AIHelper, bool) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	userHelpers, exists := m.helpers[userName]
+	elem, exists := m.lookup(userName, sessionID)
 	if !exists {
 		return nil, false
 	}
-
-	helper, exists := userHelpers[sessionID]
-	return helper, exists
+	m.touch(elem)
+	return elem.Value.(*helperEntry).helper, true
 }
 
 // 移除指定用户的指定会话的AIHelper
@@ -68,23 +246,17 @@ func (m *AIHelperManager) RemoveAIHelper(userName string, sessionID string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	userHelpers, exists := m.helpers[userName]
+	elem, exists := m.lookup(userName, sessionID)
 	if !exists {
 		return
 	}
-
-	delete(userHelpers, sessionID)
-
-	// 如果用户没有会话了，清理用户映射
-	if len(userHelpers) == 0 {
-		delete(m.helpers, userName)
-	}
+	m.remove(elem)
 }
 
-// 获取指定用户的所有会话ID
+// 获取指定用户当前驻留在内存中的会话ID
 func (m *AIHelperManager) GetUserSessions(userName string) []string {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
 	userHelpers, exists := m.helpers[userName]
 	if !exists {
@@ -100,6 +272,96 @@ func (m *AIHelperManager) GetUserSessions(userName string) []string {
 	return sessionIDs
 }
 
+// StartEvictionLoop 启动后台协程，定期淘汰空闲过久的AIHelper
+func (m *AIHelperManager) StartEvictionLoop() {
+	interval := time.Duration(config.GetConfig().AIHelperConfig.EvictInterval) * time.Second
+	if m.idleTTL <= 0 || interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if n := m.evictIdle(time.Now()); n > 0 {
+				log.Printf("[AIHelperManager] evicted %d idle helpers", n)
+			}
+		}
+	}()
+}
+
+// evictIdle 从队尾开始淘汰最后访问时间早于 now-idleTTL 的AIHelper
+func (m *AIHelperManager) evictIdle(now time.Time) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	evicted := 0
+	for elem := m.lru.Back(); elem != nil; {
+		entry := elem.Value.(*helperEntry)
+		if now.Sub(entry.lastAccess) < m.idleTTL {
+			break
+		}
+		prev := elem.Prev()
+		if evictable(entry.helper) {
+			m.remove(elem)
+			evicted++
+		}
+		elem = prev
+	}
+	return evicted
+}
+
+// evictOverflow 超出容量时淘汰最久未访问的AIHelper（跳过不能淘汰的），调用方需持有锁
+func (m *AIHelperManager) evictOverflow() {
+	if m.maxHelpers <= 0 {
+		return
+	}
+	for elem := m.lru.Back(); elem != nil && m.lru.Len() > m.maxHelpers; {
+		prev := elem.Prev()
+		if evictable(elem.Value.(*helperEntry).helper) {
+			m.remove(elem)
+		}
+		elem = prev
+	}
+}
+
+// evictable AIHelper 是否可以淘汰：
+// 正在使用（已取得但可能尚未开始轮次）或正在执行轮次的不淘汰，否则模型会在使用中被关闭，重新加载后还会出现两个轮次队列；
+// 还有消息未写入数据库的不淘汰，否则重新加载时会缺少这些消息
+func evictable(helper *AIHelper) bool {
+	return helper.pins.Load() <= 0 && !helper.turns.isBusy() && !cache.HasPendingMessages(helper.SessionID)
+}
+
+// lookup 查找节点，调用方需持有锁
+func (m *AIHelperManager) lookup(userName string, sessionID string) (*list.Element, bool) {
+	userHelpers, exists := m.helpers[userName]
+	if !exists {
+		return nil, false
+	}
+	elem, exists := userHelpers[sessionID]
+	return elem, exists
+}
+
+// touch 刷新访问时间并移动到队头，调用方需持有锁
+func (m *AIHelperManager) touch(elem *list.Element) {
+	elem.Value.(*helperEntry).lastAccess = time.Now()
+	m.lru.MoveToFront(elem)
+}
+
+// remove 从映射和链表中删除节点，调用方需持有锁
+func (m *AIHelperManager) remove(elem *list.Element) {
+	entry := m.lru.Remove(elem).(*helperEntry)
+	closeModel(entry.helper.getModel())
+	closeStrategy(entry.helper.getStrategy())
+
+	userHelpers := m.helpers[entry.userName]
+	delete(userHelpers, entry.sessionID)
+	// 如果用户没有会话了，清理用户映射，并关闭用户注册的MCP服务的连接
+	if len(userHelpers) == 0 {
+		delete(m.helpers, entry.userName)
+		closeUserMCPServers(entry.userName)
+	}
+}
+
 // 全局管理器实例
 var globalManager *AIHelperManager
 var once sync.Once
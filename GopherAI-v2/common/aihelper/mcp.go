@@ -0,0 +1,424 @@
+package aihelper
+
+import (
+	"GopherAI/common/fixture"
+	"GopherAI/config"
+	"GopherAI/dao/mcpserver"
+	"GopherAI/model"
+	"GopherAI/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	einomodel "github.com/cloudwego/eino/components/model"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// MCP服务的传输方式
+const (
+	MCPTransportStdio          = "stdio"
+	MCPTransportSSE            = "sse"
+	MCPTransportStreamableHTTP = "streamable-http"
+)
+
+// defaultMCPTimeout 未配置超时时，初始化、获取工具和调用工具的超时时间
+const defaultMCPTimeout = 30 * time.Second
+
+// mcpServer 一个MCP服务的连接，连接在第一次使用时建立，由所有 mcp 模型共享
+// 工具目录缓存在连接上，收到 notifications/tools/list_changed 后版本号加一，下次使用时重新获取
+type mcpServer struct {
+	conf config.MCPServerConfig
+
+	mu     sync.Mutex
+	client *client.Client
+
+	// toolsMu 串行化工具目录的获取，并发的调用方等待同一次获取完成
+	toolsMu      sync.Mutex
+	tools        []mcp.Tool
+	toolsVersion uint64        // tools 对应的目录版本
+	toolsLatest  atomic.Uint64 // 服务端目录的最新版本
+}
+
+func newMCPServer(conf config.MCPServerConfig) *mcpServer {
+	return &mcpServer{conf: conf}
+}
+
+// timeout 单次请求的超时时间
+func (s *mcpServer) timeout() time.Duration {
+	if s.conf.Timeout > 0 {
+		return time.Duration(s.conf.Timeout) * time.Second
+	}
+	return defaultMCPTimeout
+}
+
+// connect 获取或建立连接；建立失败不缓存，下次使用时重试
+func (s *mcpServer) connect(ctx context.Context) (*client.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		return s.client, nil
+	}
+
+	c, err := s.newClient()
+	if err != nil {
+		return nil, err
+	}
+	// 通知在接收协程中同步处理，这里只做标记，不能阻塞
+	c.OnNotification(func(notification mcp.JSONRPCNotification) {
+		if notification.Method == mcp.MethodNotificationToolsListChanged {
+			log.Printf("MCP tools list changed: %s", s.conf.Name)
+			s.toolsLatest.Add(1)
+		}
+	})
+	// 连接的生命周期不跟随当前请求
+	if err := c.Start(context.Background()); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("mcp server %s start failed: %w", s.conf.Name, err)
+	}
+
+	initRequest := mcp.InitializeRequest{}
+	initRequest.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	initRequest.Params.ClientInfo = mcp.Implementation{
+		Name:    "MCP-Go AIHelper Client",
+		Version: "1.0.0",
+	}
+	initRequest.Params.Capabilities = mcp.ClientCapabilities{}
+
+	initCtx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+	if _, err := c.Initialize(initCtx, initRequest); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("mcp server %s initialize failed: %w", s.conf.Name, err)
+	}
+	s.client = c
+	// 会话建立后立即获取工具目录
+	s.toolsLatest.Add(1)
+	return c, nil
+}
+
+// newClient 按配置的传输方式创建客户端
+func (s *mcpServer) newClient() (*client.Client, error) {
+	switch s.conf.Transport {
+	case MCPTransportStdio:
+		if s.conf.Command == "" {
+			return nil, fmt.Errorf("mcp server %s: command is required for stdio", s.conf.Name)
+		}
+		// stdio 客户端创建时即启动子进程
+		return client.NewStdioMCPClient(s.conf.Command, s.conf.Env, s.conf.Args...)
+	case MCPTransportSSE:
+		return client.NewSSEMCPClient(s.conf.URL, transport.WithHeaders(s.conf.Headers))
+	case MCPTransportStreamableHTTP, "":
+		opts := []transport.StreamableHTTPCOption{transport.WithHTTPHeaders(s.conf.Headers)}
+		if httpClient := fixture.HTTPClient("mcp-" + s.conf.Name); httpClient != nil {
+			// 记录或回放时不持续监听：监听的长连接没有结束，无法记录和回放
+			opts = append(opts, transport.WithHTTPBasicClient(httpClient))
+		} else {
+			// 持续监听服务端推送的通知
+			opts = append(opts, transport.WithContinuousListening())
+		}
+		t, err := transport.NewStreamableHTTP(s.conf.URL, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("create mcp transport failed: %w", err)
+		}
+		return client.NewClient(t), nil
+	default:
+		return nil, fmt.Errorf("mcp server %s: unsupported transport %q", s.conf.Name, s.conf.Transport)
+	}
+}
+
+// toolPolicy 工具的调用策略，未配置时为 auto
+func (s *mcpServer) toolPolicy(tool string) string {
+	if policy, ok := s.conf.ToolPolicies[tool]; ok && policy != "" {
+		return policy
+	}
+	if s.conf.ToolPolicy != "" {
+		return s.conf.ToolPolicy
+	}
+	return ToolPolicyAuto
+}
+
+// reset 关闭连接，下次使用时重新建立
+func (s *mcpServer) reset(c *client.Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == c {
+		s.client = nil
+		c.Close()
+	}
+}
+
+// close 关闭连接
+func (s *mcpServer) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		s.client.Close()
+		s.client = nil
+	}
+}
+
+// listTools 获取服务提供的工具（优先使用缓存）
+func (s *mcpServer) listTools(ctx context.Context) ([]mcp.Tool, error) {
+	c, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.toolsMu.Lock()
+	defer s.toolsMu.Unlock()
+	// 获取期间收到的变更通知会使版本号再次增加，下次使用时重新获取
+	latest := s.toolsLatest.Load()
+	if s.toolsVersion == latest {
+		return s.tools, nil
+	}
+
+	listCtx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+	result, err := c.ListTools(listCtx, mcp.ListToolsRequest{})
+	if err != nil {
+		if ctx.Err() == nil {
+			// 服务可能已重启，丢弃连接以便下次重连
+			s.reset(c)
+		}
+		return nil, fmt.Errorf("mcp server %s list tools failed: %w", s.conf.Name, err)
+	}
+	s.tools = result.Tools
+	s.toolsVersion = latest
+	log.Printf("MCP tools loaded from %s: %d", s.conf.Name, len(result.Tools))
+	return result.Tools, nil
+}
+
+// callTool 调用工具，返回工具结果文本；工具返回 isError 时作为错误返回
+func (s *mcpServer) callTool(ctx context.Context, toolName string, args map[string]interface{}) (string, error) {
+	c, err := s.connect(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+	result, err := c.CallTool(callCtx, mcp.CallToolRequest{
+		Params: mcp.CallToolParams{
+			Name:      toolName,
+			Arguments: args,
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("mcp tool call failed: %w", err)
+	}
+
+	// 提取工具结果文本
+	var text string
+	for _, content := range result.Content {
+		if textContent, ok := content.(mcp.TextContent); ok {
+			text += textContent.Text + "\n"
+		}
+	}
+	if result.IsError {
+		return "", fmt.Errorf("%s", strings.TrimSpace(text))
+	}
+	return text, nil
+}
+
+// =================== 全局MCP服务 ===================
+
+var (
+	globalMCPServers     []*mcpServer
+	globalMCPServersOnce sync.Once
+)
+
+// getGlobalMCPServers 配置文件中的MCP服务，连接由所有会话共享
+func getGlobalMCPServers() []*mcpServer {
+	globalMCPServersOnce.Do(func() {
+		for _, conf := range config.GetConfig().MCPServers {
+			globalMCPServers = append(globalMCPServers, newMCPServer(conf))
+		}
+	})
+	return globalMCPServers
+}
+
+// =================== 用户注册的MCP服务 ===================
+
+// ErrMCPKeyMissing 未配置请求头加密密钥
+var ErrMCPKeyMissing = errors.New("mcp header encryption key is not configured")
+
+// userMCPServer 用户服务的连接及其对应的配置版本
+type userMCPServer struct {
+	server    *mcpServer
+	userName  string
+	updatedAt time.Time
+}
+
+var (
+	userMCPServers   = make(map[uint]*userMCPServer)
+	userMCPServersMu sync.Mutex
+)
+
+// loadUserMCPServers 获取用户注册的MCP服务，连接按服务ID复用，服务配置更新后重新建立
+func loadUserMCPServers(username string) []*mcpServer {
+	if username == "" {
+		return nil
+	}
+	records, err := mcpserver.GetServersByUserName(username)
+	if err != nil {
+		log.Printf("load mcp servers of %s failed: %v", username, err)
+		return nil
+	}
+
+	userMCPServersMu.Lock()
+	defer userMCPServersMu.Unlock()
+	servers := make([]*mcpServer, 0, len(records))
+	for i := range records {
+		r := &records[i]
+		if cached, ok := userMCPServers[r.ID]; ok {
+			if cached.updatedAt.Equal(r.UpdatedAt) {
+				servers = append(servers, cached.server)
+				continue
+			}
+			cached.server.close()
+			delete(userMCPServers, r.ID)
+		}
+		conf, err := UserMCPServerConfig(r)
+		if err != nil {
+			log.Printf("skip mcp server %s of %s: %v", r.Name, username, err)
+			continue
+		}
+		server := newMCPServer(conf)
+		userMCPServers[r.ID] = &userMCPServer{server: server, userName: username, updatedAt: r.UpdatedAt}
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+// CloseUserMCPServer 关闭用户服务的连接，在服务被修改或删除后调用
+func CloseUserMCPServer(id uint) {
+	userMCPServersMu.Lock()
+	defer userMCPServersMu.Unlock()
+	if cached, ok := userMCPServers[id]; ok {
+		cached.server.close()
+		delete(userMCPServers, id)
+	}
+}
+
+// closeUserMCPServers 关闭用户所有服务的连接，在用户的会话全部被淘汰后调用
+// 服务仍保留在缓存中，下次使用时重新建立连接
+func closeUserMCPServers(username string) {
+	userMCPServersMu.Lock()
+	defer userMCPServersMu.Unlock()
+	for _, cached := range userMCPServers {
+		if cached.userName == username {
+			cached.server.close()
+		}
+	}
+}
+
+// UserMCPServerConfig 解密用户服务的请求头，转换为连接配置
+func UserMCPServerConfig(r *model.UserMCPServer) (config.MCPServerConfig, error) {
+	conf := config.MCPServerConfig{
+		Name:         r.Name,
+		Transport:    r.Transport,
+		URL:          r.URL,
+		Timeout:      r.Timeout,
+		ToolPolicy:   r.ToolPolicy,
+		ToolPolicies: r.ToolPolicies,
+	}
+	if r.Headers == "" {
+		return conf, nil
+	}
+	key, err := mcpHeaderKey()
+	if err != nil {
+		return conf, err
+	}
+	plain, err := utils.Decrypt(key, r.Headers)
+	if err != nil {
+		return conf, fmt.Errorf("decrypt headers failed: %w", err)
+	}
+	if err := json.Unmarshal(plain, &conf.Headers); err != nil {
+		return conf, fmt.Errorf("decode headers failed: %w", err)
+	}
+	return conf, nil
+}
+
+// EncryptMCPHeaders 加密用户服务的请求头，用于保存到数据库
+func EncryptMCPHeaders(headers map[string]string) (string, error) {
+	if len(headers) == 0 {
+		return "", nil
+	}
+	key, err := mcpHeaderKey()
+	if err != nil {
+		return "", err
+	}
+	plain, err := json.Marshal(headers)
+	if err != nil {
+		return "", err
+	}
+	return utils.Encrypt(key, plain)
+}
+
+// mcpHeaderKey 从配置指定的环境变量读取请求头加密密钥
+func mcpHeaderKey() (string, error) {
+	key := os.Getenv(config.GetConfig().UserMCPConfig.EncryptionKeyEnv)
+	if key == "" {
+		return "", ErrMCPKeyMissing
+	}
+	return key, nil
+}
+
+// TestMCPServer 连接MCP服务并获取工具列表，用于保存服务前检查连通性，返回工具名
+func TestMCPServer(ctx context.Context, conf config.MCPServerConfig) ([]string, error) {
+	server := newMCPServer(conf)
+	defer server.close()
+	tools, err := server.listTools(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		names = append(names, tool.Name)
+	}
+	return names, nil
+}
+
+// =================== 会话中的服务开关 ===================
+
+// mcpOptions mcp 模型专有的调用选项
+type mcpOptions struct {
+	disabledServers []string
+}
+
+// withDisabledMCPServers 本次调用不使用的MCP服务，其他模型会忽略该选项
+func withDisabledMCPServers(names []string) einomodel.Option {
+	return einomodel.WrapImplSpecificOptFn(func(o *mcpOptions) {
+		o.disabledServers = names
+	})
+}
+
+// =================== 工具命名 ===================
+
+// mcpToolSeparator 分隔工具名中的服务名和原始工具名
+const mcpToolSeparator = "__"
+
+// maxToolNameLength 模型接口允许的工具名最大长度
+const maxToolNameLength = 64
+
+var invalidToolNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// namespacedToolName 生成交给模型的工具名：<服务名>__<工具名>，避免不同服务的同名工具冲突
+// 只保留模型接口允许的字符，超长时截断
+func namespacedToolName(server, tool string) string {
+	name := invalidToolNameChars.ReplaceAllString(server, "_") + mcpToolSeparator +
+		invalidToolNameChars.ReplaceAllString(tool, "_")
+	if len(name) > maxToolNameLength {
+		name = name[:maxToolNameLength]
+	}
+	return name
+}
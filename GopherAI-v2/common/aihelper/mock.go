@@ -0,0 +1,225 @@
+package aihelper
+
+import (
+	"GopherAI/config"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+// 模拟模型的回答方式
+const (
+	MockModeEcho   = "echo"
+	MockModeScript = "script"
+)
+
+// mockTokenPattern 流式输出时的切分方式：英文单词和数字连同其后的空白为一个 token，其余字符（如汉字）单独成 token
+var mockTokenPattern = regexp.MustCompile(`(?s)[A-Za-z0-9_]+\s*|\s+|.`)
+
+// mockFixture 模拟模型的规则文件
+type mockFixture struct {
+	Replies []mockReply `json:"replies"`
+	Default string      `json:"default"` // 没有规则匹配时的回答，为空时复述问题
+}
+
+// mockReply 一条规则：最后一个问题包含 Match 时按该规则回答，Match 为空的规则匹配所有问题
+type mockReply struct {
+	Match     string         `json:"match"`
+	Content   string         `json:"content"`
+	ToolCalls []mockToolCall `json:"toolCalls"` // 回答前模拟的工具调用
+	Error     string         `json:"error"`     // 不为空时返回该错误，不回答
+}
+
+// mockToolCall 一次模拟的工具调用，结果直接取自规则
+type mockToolCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Result    string `json:"result"`
+	Error     string `json:"error"`
+}
+
+// mockCalls 各模拟模型的累计调用次数，用于按配置注入错误
+var (
+	mockCalls   = make(map[string]int)
+	mockCallsMu sync.Mutex
+)
+
+// =================== Mock 实现 ===================
+
+// MockModel 不调用任何服务的模拟模型，回答只取决于配置、规则文件和问题，便于离线开发和端到端测试
+type MockModel struct {
+	conf      config.MockConfig
+	fixture   *mockFixture
+	modelType string
+}
+
+// NewMockModel 创建模拟模型，script 模式在创建时读取规则文件
+func NewMockModel(conf config.ModelConfig) (*MockModel, error) {
+	m := &MockModel{conf: conf.Mock, modelType: conf.ID}
+	switch conf.Mock.Mode {
+	case "", MockModeEcho:
+	case MockModeScript:
+		data, err := os.ReadFile(conf.Mock.Fixture)
+		if err != nil {
+			return nil, fmt.Errorf("read mock fixture failed: %w", err)
+		}
+		m.fixture = new(mockFixture)
+		if err := json.Unmarshal(data, m.fixture); err != nil {
+			return nil, fmt.Errorf("parse mock fixture failed: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported mock mode %q", conf.Mock.Mode)
+	}
+	return m, nil
+}
+
+func (m *MockModel) GenerateResponse(ctx context.Context, messages []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	reply, err := m.reply(ctx, messages)
+	if err != nil {
+		return nil, fmt.Errorf("mock generate failed: %w", err)
+	}
+	content := strings.Join(m.tokens(reply.Content, opts), "")
+	resp := schema.AssistantMessage(content, nil)
+	resp.ResponseMeta = &schema.ResponseMeta{FinishReason: "stop", Usage: m.usage(messages, reply, content)}
+	recordUsage(ctx, m.modelType, resp.ResponseMeta.Usage)
+	return resp, nil
+}
+
+func (m *MockModel) StreamResponse(ctx context.Context, messages []*schema.Message, cb StreamCallback, opts ...model.Option) (string, error) {
+	reply, err := m.reply(ctx, messages)
+	if err != nil {
+		return "", fmt.Errorf("mock stream failed: %w", err)
+	}
+	var fullResp strings.Builder
+	for _, token := range m.tokens(reply.Content, opts) {
+		if err := m.wait(ctx, m.conf.TokenDelayMs); err != nil {
+			return fullResp.String(), err
+		}
+		fullResp.WriteString(token)
+		cb(token)
+	}
+	recordUsage(ctx, m.modelType, m.usage(messages, reply, fullResp.String()))
+	return fullResp.String(), nil
+}
+
+func (m *MockModel) GetModelType() string { return m.modelType }
+
+// reply 按注入的错误、规则选出本次的回答，并模拟回答前的等待和工具调用
+func (m *MockModel) reply(ctx context.Context, messages []*schema.Message) (*mockReply, error) {
+	if err := m.wait(ctx, m.conf.LatencyMs); err != nil {
+		return nil, err
+	}
+	if m.shouldFail() {
+		return nil, errors.New(m.conf.Error)
+	}
+
+	question := ""
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == schema.User {
+			question = messages[i].Content
+			break
+		}
+	}
+	reply := &mockReply{Content: question}
+	if m.fixture != nil {
+		reply = m.match(question)
+	}
+	if reply.Error != "" {
+		return nil, errors.New(reply.Error)
+	}
+	m.callTools(ctx, reply.ToolCalls)
+	return reply, nil
+}
+
+// match 返回第一条匹配问题的规则
+func (m *MockModel) match(question string) *mockReply {
+	for i := range m.fixture.Replies {
+		if strings.Contains(question, m.fixture.Replies[i].Match) {
+			return &m.fixture.Replies[i]
+		}
+	}
+	if m.fixture.Default != "" {
+		return &mockReply{Content: m.fixture.Default}
+	}
+	return &mockReply{Content: question}
+}
+
+// shouldFail 按 FailFirst / FailEvery 判断本次调用是否注入错误，调用次数按模型类型累计
+func (m *MockModel) shouldFail() bool {
+	if m.conf.Error == "" {
+		return false
+	}
+	mockCallsMu.Lock()
+	mockCalls[m.modelType]++
+	n := mockCalls[m.modelType]
+	mockCallsMu.Unlock()
+	if n <= m.conf.FailFirst {
+		return true
+	}
+	return m.conf.FailEvery > 0 && (n-m.conf.FailFirst)%m.conf.FailEvery == 0
+}
+
+// callTools 模拟工具调用：与 MCP 模型一样通知前端并记录，结果取自规则
+func (m *MockModel) callTools(ctx context.Context, calls []mockToolCall) {
+	if len(calls) == 0 {
+		return
+	}
+	invocations := make([]toolInvocation, len(calls))
+	for i, call := range calls {
+		callID := fmt.Sprintf("mock_call_%d", i+1)
+		emitToolCall(ctx, &ToolCallEvent{CallID: callID, Name: call.Name, Arguments: call.Arguments})
+		invocations[i] = toolInvocation{
+			callID:    callID,
+			name:      call.Name,
+			arguments: call.Arguments,
+			result:    call.Result,
+			err:       call.Error,
+		}
+		emitToolResult(ctx, &ToolResultEvent{CallID: callID, Name: call.Name, Result: call.Result, Error: call.Error})
+	}
+	recordToolCalls(ctx, invocations)
+}
+
+// tokens 将回答切分为流式输出的 token，设置了 maxTokens 时截断
+func (m *MockModel) tokens(content string, opts []model.Option) []string {
+	tokens := mockTokenPattern.FindAllString(content, -1)
+	common := model.GetCommonOptions(nil, opts...)
+	if common.MaxTokens != nil && *common.MaxTokens < len(tokens) {
+		tokens = tokens[:*common.MaxTokens]
+	}
+	return tokens
+}
+
+// usage 按本地规则计算用量，作为服务商返回的用量，工具调用的参数和结果计入输入
+func (m *MockModel) usage(messages []*schema.Message, reply *mockReply, content string) *schema.TokenUsage {
+	prompt := CountMessagesTokens(messages)
+	for _, call := range reply.ToolCalls {
+		prompt += CountTokens(call.Arguments) + CountTokens(call.Result)
+	}
+	completion := CountTokens(content)
+	return &schema.TokenUsage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion}
+}
+
+// wait 等待 ms 毫秒，期间 ctx 被取消时返回错误
+func (m *MockModel) wait(ctx context.Context, ms int) error {
+	if ms <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(time.Duration(ms) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -1,22 +1,25 @@
 package aihelper
 
 import (
+	"GopherAI/common/fixture"
 	"GopherAI/common/rag"
 	"GopherAI/config"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/cloudwego/eino-ext/components/model/ollama"
 	"github.com/cloudwego/eino-ext/components/model/openai"
 	"github.com/cloudwego/eino/components/model"
 	"github.com/cloudwego/eino/schema"
-	"github.com/mark3labs/mcp-go/client"
-	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/eino-contrib/jsonschema"
 	"github.com/mark3labs/mcp-go/mcp"
 )
 
@@ -24,56 +27,89 @@ type StreamCallback func(msg string)
 
 // AIModel 定义AI模型接口
 type AIModel interface {
-	GenerateResponse(ctx context.Context, messages []*schema.Message) (*schema.Message, error)
-	StreamResponse(ctx context.Context, messages []*schema.Message, cb StreamCallback) (string, error)
+	GenerateResponse(ctx context.Context, messages []*schema.Message, opts ...model.Option) (*schema.Message, error)
+	// StreamResponse 流式生成，出错（如 ctx 被取消）时同时返回已生成的部分内容
+	StreamResponse(ctx context.Context, messages []*schema.Message, cb StreamCallback, opts ...model.Option) (string, error)
 	GetModelType() string
 }
 
 // =================== OpenAI 实现 ===================
 type OpenAIModel struct {
-	llm model.ToolCallingChatModel
+	llm       model.ToolCallingChatModel
+	modelType string
 }
 
-func NewOpenAIModel(ctx context.Context) (*OpenAIModel, error) {
-	key := os.Getenv("OPENAI_API_KEY")
-	modelName := os.Getenv("OPENAI_MODEL_NAME")
-	baseURL := os.Getenv("OPENAI_BASE_URL")
+// NewOpenAIModel 创建 OpenAI 兼容接口的模型
+func NewOpenAIModel(ctx context.Context, conf config.ModelConfig) (*OpenAIModel, error) {
+	llm, err := newOpenAIChatModel(ctx, conf)
+	if err != nil {
+		return nil, fmt.Errorf("create openai model failed: %w", err)
+	}
+	return &OpenAIModel{llm: llm, modelType: conf.ID}, nil
+}
 
-	llm, err := openai.NewChatModel(ctx, &openai.ChatModelConfig{
-		BaseURL: baseURL,
-		Model:   modelName,
-		APIKey:  key,
+// newOpenAIChatModel 按注册表配置创建 OpenAI 兼容接口的 ChatModel
+func newOpenAIChatModel(ctx context.Context, conf config.ModelConfig) (model.ToolCallingChatModel, error) {
+	return openai.NewChatModel(ctx, &openai.ChatModelConfig{
+		BaseURL:    baseURLOf(conf),
+		Model:      modelNameOf(conf),
+		APIKey:     apiKeyOf(conf),
+		HTTPClient: fixture.HTTPClient(ProviderOpenAI),
 	})
-	if err != nil {
-		return nil, fmt.Errorf("create openai model failed: %v", err)
+}
+
+// modelNameOf 模型名，注册表配置指定的环境变量不为空时使用环境变量
+func modelNameOf(conf config.ModelConfig) string {
+	if v := os.Getenv(conf.ModelEnv); conf.ModelEnv != "" && v != "" {
+		return v
+	}
+	return conf.Model
+}
+
+// baseURLOf 服务地址，注册表配置指定的环境变量不为空时使用环境变量
+func baseURLOf(conf config.ModelConfig) string {
+	if v := os.Getenv(conf.BaseURLEnv); conf.BaseURLEnv != "" && v != "" {
+		return v
 	}
-	return &OpenAIModel{llm: llm}, nil
+	return conf.BaseURL
 }
 
-func (o *OpenAIModel) GenerateResponse(ctx context.Context, messages []*schema.Message) (*schema.Message, error) {
-	resp, err := o.llm.Generate(ctx, messages)
+// apiKeyOf 从注册表配置指定的环境变量中读取 API Key
+func apiKeyOf(conf config.ModelConfig) string {
+	if conf.APIKeyEnv == "" {
+		return ""
+	}
+	return os.Getenv(conf.APIKeyEnv)
+}
+
+func (o *OpenAIModel) GenerateResponse(ctx context.Context, messages []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	resp, err := o.llm.Generate(ctx, messages, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("openai generate failed: %v", err)
+		return nil, fmt.Errorf("openai generate failed: %w", err)
 	}
+	recordUsage(ctx, o.modelType, usageOf(resp))
 	return resp, nil
 }
 
-func (o *OpenAIModel) StreamResponse(ctx context.Context, messages []*schema.Message, cb StreamCallback) (string, error) {
-	stream, err := o.llm.Stream(ctx, messages)
+func (o *OpenAIModel) StreamResponse(ctx context.Context, messages []*schema.Message, cb StreamCallback, opts ...model.Option) (string, error) {
+	stream, err := o.llm.Stream(ctx, messages, opts...)
 	if err != nil {
-		return "", fmt.Errorf("openai stream failed: %v", err)
+		return "", fmt.Errorf("openai stream failed: %w", err)
 	}
 	defer stream.Close()
 
 	var fullResp strings.Builder
-
+	var usage *schema.TokenUsage // 用量在最后一个片段中返回
 	for {
 		msg, err := stream.Recv()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return "", fmt.Errorf("openai stream recv failed: %v", err)
+			return fullResp.String(), fmt.Errorf("openai stream recv failed: %w", err)
+		}
+		if u := usageOf(msg); u != nil {
+			usage = u
 		}
 		if len(msg.Content) > 0 {
 			fullResp.WriteString(msg.Content) // 聚合
@@ -81,99 +117,104 @@ func (o *OpenAIModel) StreamResponse(ctx context.Context, messages []*schema.Mes
 			cb(msg.Content) // 实时调用cb函数，方便主动发送给前端
 		}
 	}
+	recordUsage(ctx, o.modelType, usage)
 
 	return fullResp.String(), nil //返回完整内容，方便后续存储
 }
 
-func (o *OpenAIModel) GetModelType() string { return "1" }
+func (o *OpenAIModel) GetModelType() string { return o.modelType }
 
 // =================== Ollama 实现 ===================
 
 // OllamaModel Ollama模型实现
 type OllamaModel struct {
-	llm model.ToolCallingChatModel
+	llm       model.ToolCallingChatModel
+	modelType string
 }
 
-func NewOllamaModel(ctx context.Context, baseURL, modelName string) (*OllamaModel, error) {
+func NewOllamaModel(ctx context.Context, conf config.ModelConfig) (*OllamaModel, error) {
 	llm, err := ollama.NewChatModel(ctx, &ollama.ChatModelConfig{
-		BaseURL: baseURL,
-		Model:   modelName,
+		BaseURL:    baseURLOf(conf),
+		Model:      modelNameOf(conf),
+		HTTPClient: fixture.HTTPClient(ProviderOllama),
 	})
 	if err != nil {
-		return nil, fmt.Errorf("create ollama model failed: %v", err)
+		return nil, fmt.Errorf("create ollama model failed: %w", err)
 	}
-	return &OllamaModel{llm: llm}, nil
+	return &OllamaModel{llm: llm, modelType: conf.ID}, nil
 }
 
-func (o *OllamaModel) GenerateResponse(ctx context.Context, messages []*schema.Message) (*schema.Message, error) {
-	resp, err := o.llm.Generate(ctx, messages)
+func (o *OllamaModel) GenerateResponse(ctx context.Context, messages []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	resp, err := o.llm.Generate(ctx, messages, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("ollama generate failed: %v", err)
+		return nil, fmt.Errorf("ollama generate failed: %w", err)
 	}
+	recordUsage(ctx, o.modelType, usageOf(resp))
 	return resp, nil
 }
 
-func (o *OllamaModel) StreamResponse(ctx context.Context, messages []*schema.Message, cb StreamCallback) (string, error) {
-	stream, err := o.llm.Stream(ctx, messages)
+func (o *OllamaModel) StreamResponse(ctx context.Context, messages []*schema.Message, cb StreamCallback, opts ...model.Option) (string, error) {
+	stream, err := o.llm.Stream(ctx, messages, opts...)
 	if err != nil {
-		return "", fmt.Errorf("ollama stream failed: %v", err)
+		return "", fmt.Errorf("ollama stream failed: %w", err)
 	}
 	defer stream.Close()
 	var fullResp strings.Builder
+	var usage *schema.TokenUsage // 用量在最后一个片段中返回
 	for {
 		msg, err := stream.Recv()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return "", fmt.Errorf("openai stream recv failed: %v", err)
+			return fullResp.String(), fmt.Errorf("openai stream recv failed: %w", err)
+		}
+		if u := usageOf(msg); u != nil {
+			usage = u
 		}
 		if len(msg.Content) > 0 {
 			fullResp.WriteString(msg.Content) // 聚合
 			cb(msg.Content)                   // 实时调用cb函数，方便主动发送给前端
 		}
 	}
+	recordUsage(ctx, o.modelType, usage)
 	return fullResp.String(), nil //返回完整内容，方便后续存储
 }
 
-func (o *OllamaModel) GetModelType() string { return "4" }
+func (o *OllamaModel) GetModelType() string { return o.modelType }
 
 // =================== RAG 实现 ===================
 type AliRAGModel struct {
-	llm      model.ToolCallingChatModel
-	username string // 用于获取用户的文档
+	llm           model.ToolCallingChatModel
+	modelType     string
+	username      string // 用于获取用户的文档
+	knowledgeBase string // 指定的知识库文件名，为空时使用用户上传的文件
 }
 
-func NewAliRAGModel(ctx context.Context, username string) (*AliRAGModel, error) {
-	key := os.Getenv("OPENAI_API_KEY")
-	conf := config.GetConfig()
-	modelName := conf.RagModelConfig.RagChatModelName
-	baseURL := conf.RagModelConfig.RagBaseUrl
-
-	llm, err := openai.NewChatModel(ctx, &openai.ChatModelConfig{
-		BaseURL: baseURL,
-		Model:   modelName,
-		APIKey:  key,
-	})
+func NewAliRAGModel(ctx context.Context, conf config.ModelConfig, username string, knowledgeBase string) (*AliRAGModel, error) {
+	llm, err := newOpenAIChatModel(ctx, conf)
 	if err != nil {
-		return nil, fmt.Errorf("create ali rag model failed: %v", err)
+		return nil, fmt.Errorf("create ali rag model failed: %w", err)
 	}
 	return &AliRAGModel{
-		llm:      llm,
-		username: username,
+		llm:           llm,
+		modelType:     conf.ID,
+		username:      username,
+		knowledgeBase: knowledgeBase,
 	}, nil
 }
 
-func (o *AliRAGModel) GenerateResponse(ctx context.Context, messages []*schema.Message) (*schema.Message, error) {
+func (o *AliRAGModel) GenerateResponse(ctx context.Context, messages []*schema.Message, opts ...model.Option) (*schema.Message, error) {
 	// 1. 创建 RAG 查询器
-	ragQuery, err := rag.NewRAGQuery(ctx, o.username)
+	ragQuery, err := rag.NewRAGQuery(ctx, o.username, o.knowledgeBase)
 	if err != nil {
 		log.Printf("Failed to create RAG query (user may not have uploaded file): %v", err)
 		// 如果用户没有上传文件，直接使用原始问题
-		resp, err := o.llm.Generate(ctx, messages)
+		resp, err := o.llm.Generate(ctx, messages, opts...)
 		if err != nil {
-			return nil, fmt.Errorf("ali rag generate failed: %v", err)
+			return nil, fmt.Errorf("ali rag generate failed: %w", err)
 		}
+		recordUsage(ctx, o.modelType, usageOf(resp))
 		return resp, nil
 	}
 
@@ -189,13 +230,16 @@ func (o *AliRAGModel) GenerateResponse(ctx context.Context, messages []*schema.M
 	if err != nil {
 		log.Printf("Failed to retrieve documents: %v", err)
 		// 检索失败，使用原始问题
-		resp, err := o.llm.Generate(ctx, messages)
+		resp, err := o.llm.Generate(ctx, messages, opts...)
 		if err != nil {
-			return nil, fmt.Errorf("ali rag generate failed: %v", err)
+			return nil, fmt.Errorf("ali rag generate failed: %w", err)
 		}
+		recordUsage(ctx, o.modelType, usageOf(resp))
 		return resp, nil
 	}
 
+	emitSources(ctx, docs)
+
 	// 4. 构建包含检索结果的提示词
 	ragPrompt := rag.BuildRAGPrompt(query, docs)
 
@@ -208,20 +252,21 @@ func (o *AliRAGModel) GenerateResponse(ctx context.Context, messages []*schema.M
 	}
 
 	// 6. 调用 LLM 生成回答
-	resp, err := o.llm.Generate(ctx, ragMessages)
+	resp, err := o.llm.Generate(ctx, ragMessages, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("ali rag generate failed: %v", err)
+		return nil, fmt.Errorf("ali rag generate failed: %w", err)
 	}
+	recordUsage(ctx, o.modelType, usageOf(resp))
 	return resp, nil
 }
 
-func (o *AliRAGModel) StreamResponse(ctx context.Context, messages []*schema.Message, cb StreamCallback) (string, error) {
+func (o *AliRAGModel) StreamResponse(ctx context.Context, messages []*schema.Message, cb StreamCallback, opts ...model.Option) (string, error) {
 	// 1. 创建 RAG 查询器
-	ragQuery, err := rag.NewRAGQuery(ctx, o.username)
+	ragQuery, err := rag.NewRAGQuery(ctx, o.username, o.knowledgeBase)
 	if err != nil {
 		log.Printf("Failed to create RAG query (user may not have uploaded file): %v", err)
 		// 如果用户没有上传文件，直接使用原始问题
-		return o.streamWithoutRAG(ctx, messages, cb)
+		return o.streamWithoutRAG(ctx, messages, cb, opts...)
 	}
 
 	// 2. 获取用户最后一条消息作为查询
@@ -236,9 +281,11 @@ func (o *AliRAGModel) StreamResponse(ctx context.Context, messages []*schema.Mes
 	if err != nil {
 		log.Printf("Failed to retrieve documents: %v", err)
 		// 检索失败，使用原始问题
-		return o.streamWithoutRAG(ctx, messages, cb)
+		return o.streamWithoutRAG(ctx, messages, cb, opts...)
 	}
 
+	emitSources(ctx, docs)
+
 	// 4. 构建包含检索结果的提示词
 	ragPrompt := rag.BuildRAGPrompt(query, docs)
 
@@ -251,398 +298,379 @@ func (o *AliRAGModel) StreamResponse(ctx context.Context, messages []*schema.Mes
 	}
 
 	// 6. 流式调用 LLM
-	stream, err := o.llm.Stream(ctx, ragMessages)
+	stream, err := o.llm.Stream(ctx, ragMessages, opts...)
 	if err != nil {
-		return "", fmt.Errorf("ali rag stream failed: %v", err)
+		return "", fmt.Errorf("ali rag stream failed: %w", err)
 	}
 	defer stream.Close()
 
 	var fullResp strings.Builder
-
+	var usage *schema.TokenUsage // 用量在最后一个片段中返回
 	for {
 		msg, err := stream.Recv()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return "", fmt.Errorf("ali rag stream recv failed: %v", err)
+			return fullResp.String(), fmt.Errorf("ali rag stream recv failed: %w", err)
+		}
+		if u := usageOf(msg); u != nil {
+			usage = u
 		}
 		if len(msg.Content) > 0 {
 			fullResp.WriteString(msg.Content)
 			cb(msg.Content)
 		}
 	}
+	recordUsage(ctx, o.modelType, usage)
 
 	return fullResp.String(), nil
 }
 
 // streamWithoutRAG 当没有 RAG 文档时的流式响应
-func (o *AliRAGModel) streamWithoutRAG(ctx context.Context, messages []*schema.Message, cb StreamCallback) (string, error) {
-	stream, err := o.llm.Stream(ctx, messages)
+func (o *AliRAGModel) streamWithoutRAG(ctx context.Context, messages []*schema.Message, cb StreamCallback, opts ...model.Option) (string, error) {
+	stream, err := o.llm.Stream(ctx, messages, opts...)
 	if err != nil {
-		return "", fmt.Errorf("ali rag stream failed: %v", err)
+		return "", fmt.Errorf("ali rag stream failed: %w", err)
 	}
 	defer stream.Close()
 
 	var fullResp strings.Builder
-
+	var usage *schema.TokenUsage // 用量在最后一个片段中返回
 	for {
 		msg, err := stream.Recv()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return "", fmt.Errorf("ali rag stream recv failed: %v", err)
+			return fullResp.String(), fmt.Errorf("ali rag stream recv failed: %w", err)
+		}
+		if u := usageOf(msg); u != nil {
+			usage = u
 		}
 		if len(msg.Content) > 0 {
 			fullResp.WriteString(msg.Content)
 			cb(msg.Content)
 		}
 	}
+	recordUsage(ctx, o.modelType, usage)
 
 	return fullResp.String(), nil
 }
 
-func (o *AliRAGModel) GetModelType() string { return "2" }
+func (o *AliRAGModel) GetModelType() string { return o.modelType }
 
 // =================== MCP 实现 ===================
 
-// MCPModel MCP模型实现，集成MCP服务
+// 工具调用被拒绝的原因
+var (
+	errToolDenied   = errors.New("tool call denied by policy")
+	errToolRejected = errors.New("tool call rejected by user")
+)
+
+// defaultMaxToolIterations 未配置时，一轮对话中模型最多调用工具的次数
+const defaultMaxToolIterations = 5
+
+// MCPModel MCP模型实现：使用模型原生的工具调用能力，工具来自全局配置的MCP服务和用户注册的MCP服务
+// 每轮对话按 ReAct 方式循环：模型请求调用工具 → 执行工具（同一步的多个调用并发执行）→ 把结果交给模型，
+// 直到模型给出最终回答或达到迭代上限
 type MCPModel struct {
-	llm        model.ToolCallingChatModel
-	mcpClient  *client.Client
-	username   string
-	mcpBaseURL string
+	llm           model.ToolCallingChatModel
+	modelType     string
+	username      string
+	maxIterations int
 }
 
-// NewMCPModel 创建MCP模型实例
-func NewMCPModel(ctx context.Context, username string) (*MCPModel, error) {
-	key := os.Getenv("OPENAI_API_KEY")
-	conf := config.GetConfig()
-	modelName := conf.RagModelConfig.RagChatModelName
-	baseURL := conf.RagModelConfig.RagBaseUrl
+// mcpTool 交给模型的工具对应的MCP服务和原始工具名
+type mcpTool struct {
+	server *mcpServer
+	name   string
+}
+
+// mcpToolset 一轮对话可用的工具
+type mcpToolset struct {
+	infos  []*schema.ToolInfo
+	routes map[string]mcpTool // 交给模型的工具名 -> MCP服务中的工具
+}
 
+// NewMCPModel 创建MCP模型实例
+func NewMCPModel(ctx context.Context, conf config.ModelConfig, username string) (*MCPModel, error) {
 	// 创建LLM
-	llm, err := openai.NewChatModel(ctx, &openai.ChatModelConfig{
-		BaseURL: baseURL,
-		Model:   modelName,
-		APIKey:  key,
-	})
+	llm, err := newOpenAIChatModel(ctx, conf)
 	if err != nil {
-		return nil, fmt.Errorf("create mcp model failed: %v", err)
+		return nil, fmt.Errorf("create mcp model failed: %w", err)
 	}
 
-	mcpBaseURL := "http://localhost:8081/mcp"
+	maxIterations := conf.MaxToolIterations
+	if maxIterations <= 0 {
+		maxIterations = defaultMaxToolIterations
+	}
 
 	return &MCPModel{
-		llm:        llm,
-		mcpBaseURL: mcpBaseURL,
-		username:   username,
+		llm:           llm,
+		modelType:     conf.ID,
+		username:      username,
+		maxIterations: maxIterations,
 	}, nil
 }
 
-// getMCPClient 获取或创建MCP客户端
-func (m *MCPModel) getMCPClient(ctx context.Context) (*client.Client, error) {
-	if m.mcpClient == nil {
-		// 创建MCP客户端
-		httpTransport, err := transport.NewStreamableHTTP(m.mcpBaseURL)
-		if err != nil {
-			return nil, fmt.Errorf("create mcp transport failed: %v", err)
-		}
-
-		m.mcpClient = client.NewClient(httpTransport)
-
-		// 初始化MCP客户端
-		initRequest := mcp.InitializeRequest{}
-		initRequest.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
-		initRequest.Params.ClientInfo = mcp.Implementation{
-			Name:    "MCP-Go AIHelper Client",
-			Version: "1.0.0",
-		}
-		initRequest.Params.Capabilities = mcp.ClientCapabilities{}
-
-		if _, err := m.mcpClient.Initialize(ctx, initRequest); err != nil {
-			return nil, fmt.Errorf("mcp client initialize failed: %v", err)
-		}
-	}
-	return m.mcpClient, nil
-}
-
-// GenerateResponse 生成响应，集成MCP工具
-func (m *MCPModel) GenerateResponse(ctx context.Context, messages []*schema.Message) (*schema.Message, error) {
-	if len(messages) == 0 {
-		return nil, fmt.Errorf("no messages provided")
-	}
-
-	// 获取最后一条消息
-	lastMessage := messages[len(messages)-1]
-	query := lastMessage.Content
-
-	// 第一次调用AI：告诉AI使用固定的JSON格式
-	firstPrompt := m.buildFirstPrompt(query)
-	firstMessages := make([]*schema.Message, len(messages))
-	copy(firstMessages, messages)
-	firstMessages[len(firstMessages)-1] = &schema.Message{
-		Role:    schema.User,
-		Content: firstPrompt,
-	}
-
-	// 调用LLM生成第一次响应
-	firstResp, err := m.llm.Generate(ctx, firstMessages)
-	if err != nil {
-		return nil, fmt.Errorf("mcp first generate failed: %v", err)
-	}
-	log.Println("first resp is ", firstResp)
-	// 解析AI响应
-	aiResult := firstResp.Content
-	toolCall, err := m.parseAIResponse(aiResult)
-	if err != nil {
-		log.Printf("Failed to parse AI response: %v", err)
-		return firstResp, nil
-	}
-
-	// 情况1：AI不调用工具，直接返回响应
-	if !toolCall.IsToolCall {
-		log.Println("toolCall IsToolCall is false ", firstResp)
-		return firstResp, nil
-	}
-	log.Println("toolCall IsToolCall is true ", firstResp)
-	// 情况2：AI要调用工具
-	// 获取MCP客户端
-	mcpClient, err := m.getMCPClient(ctx)
-	if err != nil {
-		log.Printf("MCP client error: %v", err)
-		return firstResp, nil
-	}
-
-	// 调用MCP工具
-	toolResult, err := m.callMCPTool(ctx, mcpClient, toolCall.ToolName, toolCall.Args)
+// GenerateResponse 生成响应，模型可以多次调用MCP工具
+func (m *MCPModel) GenerateResponse(ctx context.Context, messages []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	content, err := m.run(ctx, messages, nil, opts...)
 	if err != nil {
-		log.Printf("MCP tool call failed: %v", err)
-		return firstResp, nil
-	}
-
-	// 第二次调用AI：将工具结果告诉AI
-	secondPrompt := m.buildSecondPrompt(query, toolCall.ToolName, toolCall.Args, toolResult)
-	secondMessages := make([]*schema.Message, len(messages))
-	copy(secondMessages, messages)
-	secondMessages[len(secondMessages)-1] = &schema.Message{
-		Role:    schema.User,
-		Content: secondPrompt,
+		return nil, err
 	}
+	return schema.AssistantMessage(content, nil), nil
+}
 
-	// 调用LLM生成最终响应
-	finalResp, err := m.llm.Generate(ctx, secondMessages)
-
-	if err != nil {
-		return nil, fmt.Errorf("mcp second generate failed: %v", err)
-	}
-	log.Println("最终响应为：", finalResp)
-	return finalResp, nil
+// StreamResponse 流式响应，模型可以多次调用MCP工具，每一步的文字内容都会实时输出，
+// 调用工具的步骤结束时通过 ctx 中的 StreamEvents 通知，这些文字不计入返回的回答
+func (m *MCPModel) StreamResponse(ctx context.Context, messages []*schema.Message, cb StreamCallback, opts ...model.Option) (string, error) {
+	return m.run(ctx, messages, cb, opts...)
 }
 
-// StreamResponse 流式响应，集成MCP工具
-func (m *MCPModel) StreamResponse(ctx context.Context, messages []*schema.Message, cb StreamCallback) (string, error) {
+// run 执行 ReAct 循环，cb 为空时使用同步接口，只返回最后一步（最终回答）的文字内容
+func (m *MCPModel) run(ctx context.Context, messages []*schema.Message, cb StreamCallback, opts ...model.Option) (string, error) {
 	if len(messages) == 0 {
 		return "", fmt.Errorf("no messages provided")
 	}
 
-	// 获取最后一条消息
-	lastMessage := messages[len(messages)-1]
-	query := lastMessage.Content
-
-	// 第一次调用AI：告诉AI使用固定的JSON格式
-	firstPrompt := m.buildFirstPrompt(query)
-	firstMessages := make([]*schema.Message, len(messages))
-	copy(firstMessages, messages)
-	firstMessages[len(firstMessages)-1] = &schema.Message{
-		Role:    schema.User,
-		Content: firstPrompt,
-	}
-
-	// 第一次调用使用同步接口（非流式）
-	firstResp, err := m.llm.Generate(ctx, firstMessages)
-	if err != nil {
-		return "", fmt.Errorf("mcp first generate failed: %v", err)
+	llm := m.llm
+	// 没有可用的MCP服务时退化为普通对话
+	tools := m.loadTools(ctx, m.activeServers(opts...))
+	if len(tools.infos) > 0 {
+		var err error
+		if llm, err = m.llm.WithTools(tools.infos); err != nil {
+			return "", fmt.Errorf("mcp bind tools failed: %w", err)
+		}
 	}
 
-	aiResult := firstResp.Content
-	toolCall, err := m.parseAIResponse(aiResult)
-	if err != nil {
-		log.Printf("Failed to parse AI response: %v", err)
-		return aiResult, nil
-	}
+	// 本轮对话中的消息：历史 + 模型的工具调用 + 工具结果
+	msgs := make([]*schema.Message, len(messages), len(messages)+2*m.maxIterations)
+	copy(msgs, messages)
 
-	// 情况1：AI不调用工具，直接返回响应
-	if !toolCall.IsToolCall {
-		return aiResult, nil
-	}
+	for i := 0; ; i++ {
+		callOpts := opts
+		if i == m.maxIterations {
+			// 达到迭代上限，要求模型根据已有的工具结果直接回答
+			callOpts = append(append([]model.Option{}, opts...), model.WithToolChoice(schema.ToolChoiceForbidden))
+		}
 
-	// 情况2：AI要调用工具
-	// 获取MCP客户端
-	mcpClient, err := m.getMCPClient(ctx)
-	if err != nil {
-		log.Printf("MCP client error: %v", err)
-		return aiResult, nil
-	}
+		var content strings.Builder
+		msg, err := m.step(ctx, llm, msgs, cb, &content, callOpts...)
+		if err != nil {
+			return content.String(), err
+		}
+		if len(msg.ToolCalls) == 0 || i == m.maxIterations {
+			return content.String(), nil
+		}
+		// 调用工具前的文字只是中间步骤，单独通知，不作为回答
+		if content.Len() > 0 {
+			emitStep(ctx, &StepEvent{Content: content.String()})
+		}
 
-	// 调用MCP工具
-	toolResult, err := m.callMCPTool(ctx, mcpClient, toolCall.ToolName, toolCall.Args)
-	if err != nil {
-		log.Printf("MCP tool call failed: %v", err)
-		return aiResult, nil
+		msgs = append(msgs, msg)
+		msgs = append(msgs, m.callTools(ctx, tools, msg.ToolCalls)...)
 	}
+}
 
-	// 第二次调用AI：将工具结果告诉AI，使用流式接口
-	secondPrompt := m.buildSecondPrompt(query, toolCall.ToolName, toolCall.Args, toolResult)
-	secondMessages := make([]*schema.Message, len(messages))
-	copy(secondMessages, messages)
-	secondMessages[len(secondMessages)-1] = &schema.Message{
-		Role:    schema.User,
-		Content: secondPrompt,
+// step 调用一次模型，返回模型输出的完整消息（可能包含工具调用），文字内容同时写入 content
+func (m *MCPModel) step(ctx context.Context, llm model.ToolCallingChatModel, msgs []*schema.Message, cb StreamCallback, content *strings.Builder, opts ...model.Option) (*schema.Message, error) {
+	if cb == nil {
+		msg, err := llm.Generate(ctx, msgs, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("mcp generate failed: %w", err)
+		}
+		recordUsage(ctx, m.modelType, usageOf(msg))
+		content.WriteString(msg.Content)
+		return msg, nil
 	}
 
-	// 调用LLM生成最终响应（流式）
-	stream, err := m.llm.Stream(ctx, secondMessages)
+	stream, err := llm.Stream(ctx, msgs, opts...)
 	if err != nil {
-		return "", fmt.Errorf("mcp second stream failed: %v", err)
+		return nil, fmt.Errorf("mcp stream failed: %w", err)
 	}
 	defer stream.Close()
 
-	var finalResp strings.Builder
-
+	var chunks []*schema.Message
 	for {
-		msg, err := stream.Recv()
+		chunk, err := stream.Recv()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return "", fmt.Errorf("mcp second stream recv failed: %v", err)
+			return nil, fmt.Errorf("mcp stream recv failed: %w", err)
 		}
-		if len(msg.Content) > 0 {
-			finalResp.WriteString(msg.Content)
-			cb(msg.Content)
+		chunks = append(chunks, chunk)
+		if len(chunk.Content) > 0 {
+			content.WriteString(chunk.Content)
+			cb(chunk.Content)
 		}
 	}
+	if len(chunks) == 0 {
+		return schema.AssistantMessage("", nil), nil
+	}
 
-	return finalResp.String(), nil
-}
-
-// AIToolCall 表示AI工具调用请求
-type AIToolCall struct {
-	IsToolCall bool                   `json:"isToolCall"`
-	ToolName   string                 `json:"toolName"`
-	Args       map[string]interface{} `json:"args"`
-}
-
-// buildFirstPrompt 构建第一次调用的提示词
-func (m *MCPModel) buildFirstPrompt(query string) string {
-	return fmt.Sprintf(`你是一个智能助手，可以调用MCP工具来获取信息。
-
-可用工具:
-- get_weather: 获取指定城市的天气信息，参数: city（城市名称，支持中文和英文，如北京、Shanghai等）
-
-重要规则:
-1. 如果需要调用工具，必须严格返回以下JSON格式：
-{
-  "isToolCall": true,
-  "toolName": "工具名称",
-  "args": {"参数名": "参数值"}
-}
-2. 如果不需要调用工具，直接返回自然语言回答
-3. 请根据用户问题决定是否需要调用工具
-
-用户问题: %s
-
-请根据需要调用适当的工具，然后给出综合的回答。`, query)
-}
-
-// buildSecondPrompt 构建第二次调用的提示词
-func (m *MCPModel) buildSecondPrompt(query, toolName string, args map[string]interface{}, toolResult string) string {
-	return fmt.Sprintf(`你是一个智能助手，可以调用MCP工具来获取信息。
-
-工具执行结果:
-工具名称: %s
-工具参数: %v
-工具结果: %s
-
-用户问题: %s
-
-请根据工具结果和用户问题，给出最终的综合回答。`, toolName, args, toolResult, query)
+	// 流式输出的工具调用分散在多个片段中，需要合并
+	msg, err := schema.ConcatMessages(chunks)
+	if err != nil {
+		return nil, fmt.Errorf("mcp concat stream failed: %w", err)
+	}
+	recordUsage(ctx, m.modelType, usageOf(msg))
+	return msg, nil
 }
 
-// parseAIResponse 解析AI响应，检查是否包含工具调用
-func (m *MCPModel) parseAIResponse(response string) (*AIToolCall, error) {
-	// 尝试解析为JSON
-	var toolCall AIToolCall
-	if err := json.Unmarshal([]byte(response), &toolCall); err == nil {
-		return &toolCall, nil
+// activeServers 本轮对话使用的MCP服务：全局服务和用户注册的服务，去掉会话中停用的服务
+func (m *MCPModel) activeServers(opts ...model.Option) []*mcpServer {
+	mcpOpts := model.GetImplSpecificOptions(&mcpOptions{}, opts...)
+	disabled := make(map[string]bool, len(mcpOpts.disabledServers))
+	for _, name := range mcpOpts.disabledServers {
+		disabled[name] = true
 	}
 
-	// 如果不是JSON，检查是否包含工具调用关键词
-	if strings.Contains(response, "get_weather") {
-		// 尝试提取城市名称
-		city := m.extractCityFromResponse(response)
-		if city != "" {
-			return &AIToolCall{
-				IsToolCall: true,
-				ToolName:   "get_weather",
-				Args:       map[string]interface{}{"city": city},
-			}, nil
+	var servers []*mcpServer
+	for _, group := range [][]*mcpServer{getGlobalMCPServers(), loadUserMCPServers(m.username)} {
+		for _, server := range group {
+			if !disabled[server.conf.Name] {
+				servers = append(servers, server)
+			}
 		}
 	}
-
-	// 不是工具调用
-	return &AIToolCall{IsToolCall: false}, nil
+	return servers
 }
 
-// callMCPTool 调用MCP工具
-func (m *MCPModel) callMCPTool(ctx context.Context, client *client.Client, toolName string, args map[string]interface{}) (string, error) {
-	callToolRequest := mcp.CallToolRequest{
-		Params: mcp.CallToolParams{
-			Name:      toolName,
-			Arguments: args,
-		},
-	}
-
-	result, err := client.CallTool(ctx, callToolRequest)
-	if err != nil {
-		return "", fmt.Errorf("mcp tool call failed: %v", err)
+// loadTools 并发获取各MCP服务的工具，工具名加上服务名前缀后交给模型
+// 连接或获取工具失败的服务会被跳过，不影响本轮对话
+func (m *MCPModel) loadTools(ctx context.Context, servers []*mcpServer) mcpToolset {
+	serverTools := make([][]mcp.Tool, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server *mcpServer) {
+			defer wg.Done()
+			tools, err := server.listTools(ctx)
+			if err != nil {
+				log.Printf("skip mcp server %s: %v", server.conf.Name, err)
+				return
+			}
+			serverTools[i] = tools
+		}(i, server)
+	}
+	wg.Wait()
+
+	toolset := mcpToolset{routes: make(map[string]mcpTool)}
+	for i, tools := range serverTools {
+		server := servers[i]
+		for _, tool := range tools {
+			name := namespacedToolName(server.conf.Name, tool.Name)
+			if _, ok := toolset.routes[name]; ok {
+				log.Printf("skip mcp tool %s: duplicate name %s", tool.Name, name)
+				continue
+			}
+			info, err := toToolInfo(name, tool)
+			if err != nil {
+				log.Printf("skip mcp tool %s: %v", tool.Name, err)
+				continue
+			}
+			toolset.infos = append(toolset.infos, info)
+			toolset.routes[name] = mcpTool{server: server, name: tool.Name}
+		}
 	}
+	return toolset
+}
 
-	// 提取工具结果文本
-	var text string
-	for _, content := range result.Content {
-		if textContent, ok := content.(mcp.TextContent); ok {
-			text += textContent.Text + "\n"
+// toToolInfo 将MCP工具的 JSON Schema 转换为 eino 的工具描述，name 为交给模型的工具名
+func toToolInfo(name string, tool mcp.Tool) (*schema.ToolInfo, error) {
+	raw := tool.RawInputSchema
+	if len(raw) == 0 {
+		var err error
+		if raw, err = json.Marshal(tool.InputSchema); err != nil {
+			return nil, err
 		}
 	}
+	params := &jsonschema.Schema{}
+	if err := json.Unmarshal(raw, params); err != nil {
+		return nil, fmt.Errorf("invalid input schema: %w", err)
+	}
+	return &schema.ToolInfo{
+		Name:        name,
+		Desc:        tool.Description,
+		ParamsOneOf: schema.NewParamsOneOfByJSONSchema(params),
+	}, nil
+}
 
-	return text, nil
+// callTools 并发执行模型在同一步中请求的工具调用，按请求顺序返回工具结果消息
+// 工具执行失败时把错误信息作为结果交给模型，由模型决定如何继续；调用过程记录到 ctx 中的 toolRecorder，
+// 并通过 ctx 中的 StreamEvents 实时通知
+func (m *MCPModel) callTools(ctx context.Context, tools mcpToolset, calls []schema.ToolCall) []*schema.Message {
+	results := make([]*schema.Message, len(calls))
+	invocations := make([]toolInvocation, len(calls))
+	var wg sync.WaitGroup
+	for i, call := range calls {
+		wg.Add(1)
+		go func(i int, call schema.ToolCall) {
+			defer wg.Done()
+			emitToolCall(ctx, &ToolCallEvent{CallID: call.ID, Name: call.Function.Name, Arguments: call.Function.Arguments})
+			start := time.Now()
+			text, err := m.callTool(ctx, tools, call)
+			invocations[i] = toolInvocation{
+				callID:    call.ID,
+				name:      call.Function.Name,
+				arguments: call.Function.Arguments,
+				result:    text,
+				latency:   time.Since(start),
+			}
+			if err != nil {
+				invocations[i].err = err.Error()
+			}
+			emitToolResult(ctx, &ToolResultEvent{
+				CallID:    call.ID,
+				Name:      call.Function.Name,
+				Result:    text,
+				Error:     invocations[i].err,
+				LatencyMs: invocations[i].latency.Milliseconds(),
+			})
+			results[i] = schema.ToolMessage(text, call.ID, schema.WithToolName(call.Function.Name))
+		}(i, call)
+	}
+	wg.Wait()
+	recordToolCalls(ctx, invocations)
+	return results
 }
 
-// extractCityFromResponse 从响应中提取城市名称
-// 直接从AI返回的JSON中提取城市，不预留城市列表
-func (m *MCPModel) extractCityFromResponse(response string) string {
-	// 尝试从JSON中提取城市
-	var toolCall AIToolCall
-	if err := json.Unmarshal([]byte(response), &toolCall); err == nil {
-		if args, ok := toolCall.Args["city"].(string); ok {
-			return args
+// callTool 按工具的调用策略执行一次工具调用，返回交给模型的结果文本
+// 调用失败或被拒绝时同时返回错误，结果文本为给模型的说明
+func (m *MCPModel) callTool(ctx context.Context, tools mcpToolset, call schema.ToolCall) (string, error) {
+	tool, ok := tools.routes[call.Function.Name]
+	if !ok {
+		err := fmt.Errorf("工具 %s 不存在", call.Function.Name)
+		return "工具调用失败: " + err.Error(), err
+	}
+	switch tool.server.toolPolicy(tool.name) {
+	case ToolPolicyAuto:
+	case ToolPolicyAsk:
+		if !requestApproval(ctx, m.username, tool.server.conf.Name, tool.name, call.Function.Arguments) {
+			log.Printf("MCP tool call rejected: %s", call.Function.Name)
+			return "工具调用被拒绝: 用户未批准", errToolRejected
+		}
+	default:
+		// deny，以及无法识别的策略
+		return "工具调用被拒绝: 不允许调用该工具", errToolDenied
+	}
+	var args map[string]interface{}
+	if call.Function.Arguments != "" {
+		if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+			err = fmt.Errorf("参数不是合法的JSON: %w", err)
+			return "工具调用失败: " + err.Error(), err
 		}
 	}
-
-	// 如果JSON解析失败，尝试从文本中提取城市名称
-	// 这部分可以根据实际需要扩展，但不再预留固定城市列表
-	return ""
+	result, err := tool.server.callTool(ctx, tool.name, args)
+	if err != nil {
+		log.Printf("MCP tool call failed: %s: %v", call.Function.Name, err)
+		return fmt.Sprintf("工具调用失败: %v", err), err
+	}
+	return result, nil
 }
 
 // GetModelType 获取模型类型
-func (m *MCPModel) GetModelType() string { return "3" }
-
-// Close 关闭MCP客户端
-func (m *MCPModel) Close() {
-	if m.mcpClient != nil {
-		m.mcpClient.Close()
-	}
-}
+func (m *MCPModel) GetModelType() string { return m.modelType }
@@ -0,0 +1,314 @@
+package aihelper
+
+import (
+	"GopherAI/config"
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	einomodel "github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// fakeChatModel 按顺序返回预设回答的模型，记录每次调用收到的消息和工具选择
+type fakeChatModel struct {
+	mu      sync.Mutex
+	replies []*schema.Message
+	calls   []fakeCall
+	tools   []*schema.ToolInfo
+}
+
+type fakeCall struct {
+	msgs       []*schema.Message
+	toolChoice *schema.ToolChoice
+}
+
+func (f *fakeChatModel) next(msgs []*schema.Message, opts ...einomodel.Option) (*schema.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	options := einomodel.GetCommonOptions(&einomodel.Options{}, opts...)
+	f.calls = append(f.calls, fakeCall{msgs: append([]*schema.Message{}, msgs...), toolChoice: options.ToolChoice})
+	if len(f.calls) > len(f.replies) {
+		return nil, fmt.Errorf("unexpected call %d", len(f.calls))
+	}
+	return f.replies[len(f.calls)-1], nil
+}
+
+func (f *fakeChatModel) Generate(ctx context.Context, msgs []*schema.Message, opts ...einomodel.Option) (*schema.Message, error) {
+	return f.next(msgs, opts...)
+}
+
+// Stream 把回答的文字逐字输出，工具调用放在最后一个片段中
+func (f *fakeChatModel) Stream(ctx context.Context, msgs []*schema.Message, opts ...einomodel.Option) (*schema.StreamReader[*schema.Message], error) {
+	reply, err := f.next(msgs, opts...)
+	if err != nil {
+		return nil, err
+	}
+	var chunks []*schema.Message
+	for _, r := range reply.Content {
+		chunks = append(chunks, schema.AssistantMessage(string(r), nil))
+	}
+	if len(reply.ToolCalls) > 0 {
+		chunks = append(chunks, schema.AssistantMessage("", reply.ToolCalls))
+	}
+	return schema.StreamReaderFromArray(chunks), nil
+}
+
+func (f *fakeChatModel) WithTools(tools []*schema.ToolInfo) (einomodel.ToolCallingChatModel, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tools = tools
+	return f, nil
+}
+
+// mcpToolCall 调用测试服务 test 中的工具
+func mcpToolCall(id string, tool string, arguments string) schema.ToolCall {
+	return schema.ToolCall{
+		ID:       id,
+		Type:     "function",
+		Function: schema.FunctionCall{Name: namespacedToolName("test", tool), Arguments: arguments},
+	}
+}
+
+// echoTool 返回 "echo: <text>" 的工具，calls 记录被调用的次数
+func echoTool(calls *atomic.Int32) server.ServerTool {
+	return server.ServerTool{
+		Tool: mcp.NewTool("echo", mcp.WithString("text")),
+		Handler: func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			calls.Add(1)
+			return mcp.NewToolResultText("echo: " + req.GetString("text", "")), nil
+		},
+	}
+}
+
+// useToolServer 启动提供 tools 的 streamable HTTP 服务 test，在测试期间作为唯一的全局MCP服务
+func useToolServer(t *testing.T, conf config.MCPServerConfig, tools ...server.ServerTool) {
+	t.Helper()
+	s := server.NewMCPServer("test", "1.0.0", server.WithToolCapabilities(true))
+	s.AddTools(tools...)
+	ts := httptest.NewServer(server.NewStreamableHTTPServer(s))
+	t.Cleanup(ts.Close)
+
+	conf.Name = "test"
+	conf.Transport = MCPTransportStreamableHTTP
+	conf.URL = ts.URL + "/mcp"
+	conn := newMCPServer(conf)
+	getGlobalMCPServers()
+	old := globalMCPServers
+	globalMCPServers = []*mcpServer{conn}
+	t.Cleanup(func() {
+		globalMCPServers = old
+		conn.close()
+	})
+}
+
+// runMCPModel 执行一轮对话，返回回答、流式输出的片段、中间步骤的文字和记录的工具调用
+func runMCPModel(t *testing.T, ctx context.Context, m *MCPModel, stream bool) (string, string, []string, []toolInvocation) {
+	t.Helper()
+	var steps []string
+	ctx = WithStreamEvents(ctx, &StreamEvents{
+		Step: func(step *StepEvent) { steps = append(steps, step.Content) },
+	})
+	ctx, recorder := withToolRecorder(ctx)
+
+	var streamed strings.Builder
+	var cb StreamCallback
+	if stream {
+		cb = func(msg string) { streamed.WriteString(msg) }
+	}
+	content, err := m.run(ctx, []*schema.Message{schema.UserMessage("问题")}, cb)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	return content, streamed.String(), steps, recorder.calls
+}
+
+// toolResults 模型第 i 次调用收到的工具结果
+func toolResults(llm *fakeChatModel, i int) []string {
+	var results []string
+	for _, msg := range llm.calls[i].msgs {
+		if msg.Role == schema.Tool {
+			results = append(results, msg.ToolCallID+"="+msg.Content)
+		}
+	}
+	return results
+}
+
+func TestMCPModelLoop(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stream=%v", stream), func(t *testing.T) {
+			var calls atomic.Int32
+			useToolServer(t, config.MCPServerConfig{}, echoTool(&calls))
+			llm := &fakeChatModel{replies: []*schema.Message{
+				schema.AssistantMessage("先查一下", []schema.ToolCall{mcpToolCall("call-1", "echo", `{"text":"a"}`)}),
+				schema.AssistantMessage("", []schema.ToolCall{mcpToolCall("call-2", "echo", `{"text":"b"}`)}),
+				schema.AssistantMessage("答案", nil),
+			}}
+			m := &MCPModel{llm: llm, modelType: "test", maxIterations: defaultMaxToolIterations}
+
+			content, streamed, steps, invocations := runMCPModel(t, context.Background(), m, stream)
+			if content != "答案" {
+				t.Fatalf("content %q, want only the final step", content)
+			}
+			if stream && streamed != "先查一下答案" {
+				t.Fatalf("streamed %q", streamed)
+			}
+			if len(steps) != 1 || steps[0] != "先查一下" {
+				t.Fatalf("steps %q, want the text before the first tool call", steps)
+			}
+			if len(llm.tools) != 1 || llm.tools[0].Name != "test__echo" {
+				t.Fatalf("bound tools %v", llm.tools)
+			}
+			if len(llm.calls) != 3 || calls.Load() != 2 || len(invocations) != 2 {
+				t.Fatalf("model calls %d, tool calls %d, recorded %d", len(llm.calls), calls.Load(), len(invocations))
+			}
+			got := toolResults(llm, 2)
+			want := []string{"call-1=echo: a\n", "call-2=echo: b\n"}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("tool results %q, want %q", got, want)
+			}
+			for i, call := range llm.calls {
+				if call.toolChoice != nil {
+					t.Fatalf("call %d tool choice %v, want unset", i, *call.toolChoice)
+				}
+			}
+		})
+	}
+}
+
+func TestMCPModelParallelCalls(t *testing.T) {
+	// 两个调用都开始后才返回，串行执行时第一个调用会等到超时
+	var started atomic.Int32
+	bothStarted := make(chan struct{})
+	wait := server.ServerTool{
+		Tool: mcp.NewTool("wait", mcp.WithString("name")),
+		Handler: func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			if started.Add(1) == 2 {
+				close(bothStarted)
+			}
+			select {
+			case <-bothStarted:
+				return mcp.NewToolResultText("done " + req.GetString("name", "")), nil
+			case <-time.After(2 * time.Second):
+				return mcp.NewToolResultError("timeout"), nil
+			}
+		},
+	}
+	useToolServer(t, config.MCPServerConfig{}, wait)
+	llm := &fakeChatModel{replies: []*schema.Message{
+		schema.AssistantMessage("", []schema.ToolCall{
+			mcpToolCall("call-1", "wait", `{"name":"a"}`),
+			mcpToolCall("call-2", "wait", `{"name":"b"}`),
+		}),
+		schema.AssistantMessage("答案", nil),
+	}}
+	m := &MCPModel{llm: llm, modelType: "test", maxIterations: defaultMaxToolIterations}
+
+	_, _, _, invocations := runMCPModel(t, context.Background(), m, true)
+	got := toolResults(llm, 1)
+	want := []string{"call-1=done a\n", "call-2=done b\n"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("tool results %q, want %q", got, want)
+	}
+	for _, inv := range invocations {
+		if inv.err != "" {
+			t.Fatalf("invocation %s failed: %s", inv.callID, inv.err)
+		}
+	}
+}
+
+func TestMCPModelMaxIterations(t *testing.T) {
+	var calls atomic.Int32
+	useToolServer(t, config.MCPServerConfig{}, echoTool(&calls))
+	// 模型一直请求调用工具，达到上限后禁止调用工具，最后一步的工具调用不再执行
+	var replies []*schema.Message
+	for i := 0; i < 3; i++ {
+		replies = append(replies, schema.AssistantMessage(fmt.Sprintf("第%d步", i), []schema.ToolCall{
+			mcpToolCall(fmt.Sprintf("call-%d", i), "echo", `{"text":"a"}`),
+		}))
+	}
+	llm := &fakeChatModel{replies: replies}
+	m := &MCPModel{llm: llm, modelType: "test", maxIterations: 2}
+
+	content, _, steps, _ := runMCPModel(t, context.Background(), m, true)
+	if content != "第2步" {
+		t.Fatalf("content %q", content)
+	}
+	if len(steps) != 2 {
+		t.Fatalf("steps %q, want 2", steps)
+	}
+	if len(llm.calls) != 3 || calls.Load() != 2 {
+		t.Fatalf("model calls %d, tool calls %d, want 3 and 2", len(llm.calls), calls.Load())
+	}
+	for i, call := range llm.calls {
+		forbidden := call.toolChoice != nil && *call.toolChoice == schema.ToolChoiceForbidden
+		if forbidden != (i == m.maxIterations) {
+			t.Fatalf("call %d tool choice %v", i, call.toolChoice)
+		}
+	}
+}
+
+func TestMCPModelToolPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		approve  *bool // 为空时请求不支持审批
+		called   bool
+		wantText string
+		wantErr  error
+	}{
+		{name: "auto", policy: ToolPolicyAuto, called: true, wantText: "echo: a\n"},
+		{name: "default", policy: "", called: true, wantText: "echo: a\n"},
+		{name: "deny", policy: ToolPolicyDeny, wantText: "工具调用被拒绝: 不允许调用该工具", wantErr: errToolDenied},
+		{name: "ask approved", policy: ToolPolicyAsk, approve: ptr(true), called: true, wantText: "echo: a\n"},
+		{name: "ask rejected", policy: ToolPolicyAsk, approve: ptr(false), wantText: "工具调用被拒绝: 用户未批准", wantErr: errToolRejected},
+		{name: "ask unsupported", policy: ToolPolicyAsk, wantText: "工具调用被拒绝: 用户未批准", wantErr: errToolRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			useToolServer(t, config.MCPServerConfig{ToolPolicies: map[string]string{"echo": tt.policy}}, echoTool(&calls))
+			llm := &fakeChatModel{replies: []*schema.Message{
+				schema.AssistantMessage("", []schema.ToolCall{mcpToolCall("call-1", "echo", `{"text":"a"}`)}),
+				schema.AssistantMessage("答案", nil),
+			}}
+			m := &MCPModel{llm: llm, modelType: "test", maxIterations: defaultMaxToolIterations}
+
+			ctx := context.Background()
+			var approvals []*ToolApproval
+			if tt.approve != nil {
+				ctx = WithToolApprovalNotifier(ctx, func(approval *ToolApproval) {
+					approvals = append(approvals, approval)
+					if err := ResolveToolApproval("", approval.ID, *tt.approve); err != nil {
+						t.Errorf("resolve approval: %v", err)
+					}
+				})
+			}
+
+			_, _, _, invocations := runMCPModel(t, ctx, m, true)
+			if got := calls.Load() == 1; got != tt.called {
+				t.Fatalf("tool called %v, want %v", got, tt.called)
+			}
+			if got := toolResults(llm, 1); len(got) != 1 || got[0] != "call-1="+tt.wantText {
+				t.Fatalf("tool results %q, want %q", got, tt.wantText)
+			}
+			wantErr := ""
+			if tt.wantErr != nil {
+				wantErr = tt.wantErr.Error()
+			}
+			if len(invocations) != 1 || invocations[0].err != wantErr {
+				t.Fatalf("recorded %+v, want error %q", invocations, wantErr)
+			}
+			if tt.approve != nil && (len(approvals) != 1 || approvals[0].Server != "test" || approvals[0].Tool != "echo") {
+				t.Fatalf("approvals %+v", approvals)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package aihelper
+
+import (
+	"GopherAI/config"
+	"GopherAI/model"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/cloudwego/eino-ext/components/model/ollama"
+	"github.com/cloudwego/eino-ext/components/model/openai"
+	einomodel "github.com/cloudwego/eino/components/model"
+)
+
+// 生成参数取值范围的默认值，模型注册表中未声明时使用
+const (
+	defaultMaxTemperature = 2
+	defaultMaxStop        = 4
+)
+
+// ErrInvalidGenerationParams 生成参数超出模型允许的范围
+var ErrInvalidGenerationParams = errors.New("invalid generation params")
+
+// GenerationOptions 将生成参数转换为 eino 的模型选项，未设置的参数不传递
+// seed 不是通用选项，同时传入各服务商的选项，不支持的模型实现会忽略
+// extraFields 为 OpenAI 兼容接口请求体中的其他字段，与 seed 合并为一个选项（后传入的 ExtraFields 会覆盖之前的）
+func GenerationOptions(params model.GenerationParams, extraFields map[string]any) []einomodel.Option {
+	opts := make([]einomodel.Option, 0, 6)
+	if params.Temperature != nil {
+		opts = append(opts, einomodel.WithTemperature(*params.Temperature))
+	}
+	if params.MaxTokens != nil {
+		opts = append(opts, einomodel.WithMaxTokens(*params.MaxTokens))
+	}
+	if params.TopP != nil {
+		opts = append(opts, einomodel.WithTopP(*params.TopP))
+	}
+	if len(params.Stop) > 0 {
+		opts = append(opts, einomodel.WithStop(params.Stop))
+	}
+	fields := make(map[string]any, len(extraFields)+1)
+	for k, v := range extraFields {
+		fields[k] = v
+	}
+	if params.Seed != nil {
+		fields["seed"] = *params.Seed
+		opts = append(opts, ollama.WithSeed(*params.Seed))
+	}
+	if len(fields) > 0 {
+		opts = append(opts, openai.WithExtraFields(fields), withSemanticExtraFields(fields))
+	}
+	return opts
+}
+
+// MergeGenerationParams 合并生成参数，override 中设置了的字段优先
+func MergeGenerationParams(base model.GenerationParams, override model.GenerationParams) model.GenerationParams {
+	if override.Temperature != nil {
+		base.Temperature = override.Temperature
+	}
+	if override.MaxTokens != nil {
+		base.MaxTokens = override.MaxTokens
+	}
+	if override.TopP != nil {
+		base.TopP = override.TopP
+	}
+	if override.Stop != nil {
+		base.Stop = override.Stop
+	}
+	if override.Seed != nil {
+		base.Seed = override.Seed
+	}
+	return base
+}
+
+// DefaultGenerationParams 模型注册表中声明的默认生成参数
+func DefaultGenerationParams(modelType string) model.GenerationParams {
+	conf, _ := config.GetConfig().GetModelConfig(modelType)
+	return model.GenerationParams{
+		Temperature: conf.Defaults.Temperature,
+		MaxTokens:   conf.Defaults.MaxTokens,
+		TopP:        conf.Defaults.TopP,
+		Stop:        conf.Defaults.Stop,
+		Seed:        conf.Defaults.Seed,
+	}
+}
+
+// GenerationLimits 模型允许的生成参数取值范围，未声明的上限使用默认值
+func GenerationLimits(modelType string) model.GenerationLimits {
+	conf, _ := config.GetConfig().GetModelConfig(modelType)
+	limits := model.GenerationLimits{
+		MaxTemperature: conf.Limits.MaxTemperature,
+		MaxTokens:      conf.Limits.MaxTokens,
+		MaxStop:        conf.Limits.MaxStop,
+		Seed:           conf.Limits.Seed,
+	}
+	if limits.MaxTemperature <= 0 {
+		limits.MaxTemperature = defaultMaxTemperature
+	}
+	if limits.MaxTokens <= 0 {
+		limits.MaxTokens = conf.ContextLength
+	}
+	if limits.MaxStop <= 0 {
+		limits.MaxStop = defaultMaxStop
+	}
+	return limits
+}
+
+// ValidateGenerationParams 校验生成参数是否在模型允许的范围内，超出时返回包装了 ErrInvalidGenerationParams 的错误
+func ValidateGenerationParams(modelType string, params model.GenerationParams) error {
+	limits := GenerationLimits(modelType)
+	if t := params.Temperature; t != nil && (*t < 0 || *t > limits.MaxTemperature) {
+		return fmt.Errorf("%w: temperature %v out of range [0, %v]", ErrInvalidGenerationParams, *t, limits.MaxTemperature)
+	}
+	if p := params.TopP; p != nil && (*p <= 0 || *p > 1) {
+		return fmt.Errorf("%w: top_p %v out of range (0, 1]", ErrInvalidGenerationParams, *p)
+	}
+	if n := params.MaxTokens; n != nil && (*n <= 0 || (limits.MaxTokens > 0 && *n > limits.MaxTokens)) {
+		return fmt.Errorf("%w: max_tokens %d out of range [1, %d]", ErrInvalidGenerationParams, *n, limits.MaxTokens)
+	}
+	if len(params.Stop) > limits.MaxStop {
+		return fmt.Errorf("%w: at most %d stop sequences", ErrInvalidGenerationParams, limits.MaxStop)
+	}
+	for _, stop := range params.Stop {
+		if stop == "" {
+			return fmt.Errorf("%w: empty stop sequence", ErrInvalidGenerationParams)
+		}
+	}
+	if params.Seed != nil && !limits.Seed {
+		return fmt.Errorf("%w: model %s does not support seed", ErrInvalidGenerationParams, modelType)
+	}
+	return nil
+}
+
+type generationParamsKey struct{}
+
+// WithGenerationParams 返回带有本次请求生成参数的 ctx，这些参数只用于本轮对话，优先于会话的参数
+func WithGenerationParams(ctx context.Context, params model.GenerationParams) context.Context {
+	return context.WithValue(ctx, generationParamsKey{}, params)
+}
+
+// requestGenerationParams 取出本次请求的生成参数
+func requestGenerationParams(ctx context.Context) model.GenerationParams {
+	params, _ := ctx.Value(generationParamsKey{}).(model.GenerationParams)
+	return params
+}
@@ -0,0 +1,112 @@
+package aihelper
+
+import (
+	"GopherAI/config"
+	"GopherAI/model"
+	"errors"
+	"reflect"
+	"testing"
+
+	einomodel "github.com/cloudwego/eino/components/model"
+)
+
+// useTestModels 在测试期间向模型注册表加入模型
+func useTestModels(t *testing.T, models ...config.ModelConfig) {
+	t.Helper()
+	old := config.GetConfig().Models
+	config.GetConfig().Models = append(append([]config.ModelConfig(nil), old...), models...)
+	t.Cleanup(func() { config.GetConfig().Models = old })
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func TestValidateGenerationParams(t *testing.T) {
+	useTestModels(t,
+		config.ModelConfig{ID: "test-limited", ContextLength: 4096, Limits: config.GenerationLimits{MaxTemperature: 1, MaxTokens: 1024, MaxStop: 2, Seed: true}},
+		config.ModelConfig{ID: "test-default", ContextLength: 4096},
+	)
+	tests := []struct {
+		name      string
+		modelType string
+		params    model.GenerationParams
+		wantErr   bool
+	}{
+		{name: "empty", modelType: "test-limited", params: model.GenerationParams{}},
+		{name: "all in range", modelType: "test-limited", params: model.GenerationParams{
+			Temperature: ptr[float32](1), MaxTokens: ptr(1024), TopP: ptr[float32](1), Stop: []string{"a", "b"}, Seed: ptr(7),
+		}},
+		{name: "temperature above limit", modelType: "test-limited", params: model.GenerationParams{Temperature: ptr[float32](1.5)}, wantErr: true},
+		{name: "negative temperature", modelType: "test-limited", params: model.GenerationParams{Temperature: ptr[float32](-0.1)}, wantErr: true},
+		{name: "default temperature limit", modelType: "test-default", params: model.GenerationParams{Temperature: ptr[float32](1.5)}},
+		{name: "zero top_p", modelType: "test-limited", params: model.GenerationParams{TopP: ptr[float32](0)}, wantErr: true},
+		{name: "top_p above 1", modelType: "test-limited", params: model.GenerationParams{TopP: ptr[float32](1.1)}, wantErr: true},
+		{name: "max_tokens above limit", modelType: "test-limited", params: model.GenerationParams{MaxTokens: ptr(2048)}, wantErr: true},
+		{name: "max_tokens limited by context length", modelType: "test-default", params: model.GenerationParams{MaxTokens: ptr(8192)}, wantErr: true},
+		{name: "zero max_tokens", modelType: "test-limited", params: model.GenerationParams{MaxTokens: ptr(0)}, wantErr: true},
+		{name: "too many stops", modelType: "test-limited", params: model.GenerationParams{Stop: []string{"a", "b", "c"}}, wantErr: true},
+		{name: "empty stop", modelType: "test-limited", params: model.GenerationParams{Stop: []string{""}}, wantErr: true},
+		{name: "seed unsupported", modelType: "test-default", params: model.GenerationParams{Seed: ptr(7)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateGenerationParams(tt.modelType, tt.params)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("got %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidGenerationParams) {
+				t.Fatalf("got %v, want ErrInvalidGenerationParams", err)
+			}
+		})
+	}
+}
+
+func TestMergeGenerationParams(t *testing.T) {
+	base := model.GenerationParams{Temperature: ptr[float32](0.3), MaxTokens: ptr(100), Stop: []string{"a"}}
+	override := model.GenerationParams{Temperature: ptr[float32](0.9), Seed: ptr(1)}
+	got := MergeGenerationParams(base, override)
+	want := model.GenerationParams{Temperature: ptr[float32](0.9), MaxTokens: ptr(100), Stop: []string{"a"}, Seed: ptr(1)}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestGenerationOptions(t *testing.T) {
+	format := map[string]any{"type": JSONModeObject}
+	tests := []struct {
+		name        string
+		params      model.GenerationParams
+		extraFields map[string]any
+		wantCommon  einomodel.Options
+		wantExtra   map[string]any
+	}{
+		{name: "empty", params: model.GenerationParams{}},
+		{
+			name:       "common options",
+			params:     model.GenerationParams{Temperature: ptr[float32](0.5), MaxTokens: ptr(256), TopP: ptr[float32](0.9), Stop: []string{"END"}},
+			wantCommon: einomodel.Options{Temperature: ptr[float32](0.5), MaxTokens: ptr(256), TopP: ptr[float32](0.9), Stop: []string{"END"}},
+		},
+		{
+			name:      "seed",
+			params:    model.GenerationParams{Seed: ptr(42)},
+			wantExtra: map[string]any{"seed": 42},
+		},
+		{
+			// seed 与 response_format 合并为一个选项，不会互相覆盖
+			name:        "seed and response format",
+			params:      model.GenerationParams{Seed: ptr(42)},
+			extraFields: map[string]any{"response_format": format},
+			wantExtra:   map[string]any{"seed": 42, "response_format": format},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := GenerationOptions(tt.params, tt.extraFields)
+			if got := *einomodel.GetCommonOptions(nil, opts...); !reflect.DeepEqual(got, tt.wantCommon) {
+				t.Fatalf("common options %+v, want %+v", got, tt.wantCommon)
+			}
+			if got := einomodel.GetImplSpecificOptions(&semanticOptions{}, opts...).extraFields; !reflect.DeepEqual(got, tt.wantExtra) {
+				t.Fatalf("extra fields %v, want %v", got, tt.wantExtra)
+			}
+		})
+	}
+}
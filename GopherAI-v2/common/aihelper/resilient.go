@@ -0,0 +1,337 @@
+package aihelper
+
+import (
+	"GopherAI/config"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+	ollamaapi "github.com/eino-contrib/ollama/api"
+	goopenai "github.com/meguminnnnnnnnn/go-openai"
+)
+
+// ErrAllModelsUnavailable 主模型及所有备用模型都处于熔断状态
+var ErrAllModelsUnavailable = errors.New("all models are unavailable")
+
+// resilientModel 为模型调用增加重试、备用模型切换和熔断
+// 主模型调用失败（临时性错误会先按指数退避重试）后，依次尝试注册表中配置的备用模型
+type resilientModel struct {
+	primary       AIModel
+	fallbacks     []string               // 备用模型类型，按顺序尝试
+	sessionConfig map[string]interface{} // 创建备用模型所用的会话配置
+
+	mu      sync.Mutex
+	created map[string]AIModel // 已创建的备用模型，按需创建
+}
+
+func newResilientModel(primary AIModel, fallbacks []string, sessionConfig map[string]interface{}) *resilientModel {
+	return &resilientModel{
+		primary:       primary,
+		fallbacks:     fallbacks,
+		sessionConfig: sessionConfig,
+		created:       make(map[string]AIModel),
+	}
+}
+
+func (r *resilientModel) GenerateResponse(ctx context.Context, messages []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	var resp *schema.Message
+	err := r.try(ctx, opts, func(ctx context.Context, m AIModel, opts []model.Option) error {
+		var err error
+		resp, err = m.GenerateResponse(ctx, messages, opts...)
+		return err
+	})
+	return resp, err
+}
+
+// StreamResponse 流式生成；已经向前端输出内容后不再重试或切换模型，避免内容重复
+func (r *resilientModel) StreamResponse(ctx context.Context, messages []*schema.Message, cb StreamCallback, opts ...model.Option) (string, error) {
+	var content string
+	err := r.try(ctx, opts, func(ctx context.Context, m AIModel, opts []model.Option) error {
+		var err error
+		content, err = m.StreamResponse(ctx, messages, func(msg string) {
+			markSideEffect(ctx)
+			cb(msg)
+		}, opts...)
+		return err
+	})
+	return content, err
+}
+
+func (r *resilientModel) GetModelType() string { return r.primary.GetModelType() }
+
+// Close 释放主模型和已创建的备用模型
+func (r *resilientModel) Close() {
+	closeModel(r.primary)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range r.created {
+		closeModel(m)
+	}
+}
+
+// try 依次在主模型和备用模型上执行 call，直到成功；备用模型使用按其重新计算并校验的生成选项
+// call 已经产生副作用时（输出了部分内容、调用了工具或通知了前端）不再重试或切换，避免内容和工具调用重复
+func (r *resilientModel) try(ctx context.Context, opts []model.Option, call func(ctx context.Context, m AIModel, opts []model.Option) error) error {
+	conf := config.GetConfig().ResilienceConfig
+	candidates := append([]string{r.primary.GetModelType()}, r.fallbacks...)
+
+	var lastErr error
+	for _, modelType := range candidates {
+		breaker := breakerFor(modelType)
+		if !breaker.allow() {
+			log.Printf("[resilientModel] skip model %s: circuit open", modelType)
+			continue
+		}
+		m, err := r.model(ctx, modelType)
+		if err != nil {
+			log.Printf("[resilientModel] create fallback model %s failed: %v", modelType, err)
+			lastErr = err
+			continue
+		}
+		modelOpts, err := r.options(ctx, modelType, opts)
+		if err != nil {
+			log.Printf("[resilientModel] skip fallback model %s: %v", modelType, err)
+			lastErr = err
+			continue
+		}
+
+		for attempt := 0; ; attempt++ {
+			attemptCtx, effects := withSideEffects(ctx)
+			err := call(attemptCtx, m, modelOpts)
+			if err == nil {
+				breaker.success()
+				recordAnsweredModel(ctx, modelType)
+				return nil
+			}
+			lastErr = err
+			if ctx.Err() != nil || effects.Load() {
+				return err
+			}
+			transient := isTransientError(err)
+			if transient {
+				breaker.failure()
+			}
+			if !transient || attempt >= conf.MaxRetries || !breaker.allow() {
+				log.Printf("[resilientModel] model %s failed: %v", modelType, err)
+				break
+			}
+			wait := backoff(conf, attempt)
+			log.Printf("[resilientModel] model %s attempt %d failed: %v, retry in %v", modelType, attempt+1, err, wait)
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(wait):
+			}
+		}
+	}
+
+	if lastErr == nil {
+		return ErrAllModelsUnavailable
+	}
+	return lastErr
+}
+
+// model 获取候选模型，备用模型在第一次使用时创建
+func (r *resilientModel) model(ctx context.Context, modelType string) (AIModel, error) {
+	if modelType == r.primary.GetModelType() {
+		return r.primary, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.created[modelType]; ok {
+		return m, nil
+	}
+	m, err := GetGlobalFactory().createModel(ctx, modelType, r.sessionConfig)
+	if err != nil {
+		return nil, err
+	}
+	r.created[modelType] = m
+	return m, nil
+}
+
+// options 候选模型使用的生成选项：主模型使用调用方传入的选项，备用模型按 ctx 中的方式重新计算，
+// 生成参数超出备用模型的范围时返回错误，跳过该模型
+func (r *resilientModel) options(ctx context.Context, modelType string, opts []model.Option) ([]model.Option, error) {
+	if modelType == r.primary.GetModelType() {
+		return opts, nil
+	}
+	if resolve, ok := ctx.Value(optionsResolverKey{}).(func(modelType string) ([]model.Option, error)); ok {
+		return resolve(modelType)
+	}
+	return opts, nil
+}
+
+// backoff 第 attempt 次重试前的等待时间
+func backoff(conf config.ResilienceConfig, attempt int) time.Duration {
+	wait := time.Duration(conf.InitialBackoffMs) * time.Millisecond
+	for i := 0; i < attempt; i++ {
+		wait *= 2
+	}
+	if max := time.Duration(conf.MaxBackoffMs) * time.Millisecond; max > 0 && wait > max {
+		wait = max
+	}
+	return wait
+}
+
+// statusCodePattern 匹配错误信息中的 HTTP 状态码（如 "status code: 503"），用于无法取得类型化错误的服务
+var statusCodePattern = regexp.MustCompile(`status code: (\d{3})\b`)
+
+// statusCodeOf 取出模型服务返回的 HTTP 状态码，优先使用 OpenAI 兼容接口和 Ollama 客户端的错误类型，未知时返回 0
+func statusCodeOf(err error) int {
+	var apiErr *goopenai.APIError
+	if errors.As(err, &apiErr) && apiErr.HTTPStatusCode > 0 {
+		return apiErr.HTTPStatusCode
+	}
+	var reqErr *goopenai.RequestError
+	if errors.As(err, &reqErr) && reqErr.HTTPStatusCode > 0 {
+		return reqErr.HTTPStatusCode
+	}
+	var ollamaErr ollamaapi.StatusError
+	if errors.As(err, &ollamaErr) {
+		return ollamaErr.StatusCode
+	}
+	var ollamaErrPtr *ollamaapi.StatusError
+	if errors.As(err, &ollamaErrPtr) {
+		return ollamaErrPtr.StatusCode
+	}
+	if m := statusCodePattern.FindStringSubmatch(err.Error()); m != nil {
+		code, _ := strconv.Atoi(m[1])
+		return code
+	}
+	return 0
+}
+
+// isTransientError 判断是否为可重试的临时性错误：限流（429）、服务端错误（5xx）或超时
+func isTransientError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if code := statusCodeOf(err); code != 0 {
+		return code == 429 || code >= 500
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "timeout") ||
+		strings.Contains(msg, "rate limit") ||
+		strings.Contains(msg, "too many requests") ||
+		strings.Contains(msg, "connection reset") ||
+		strings.Contains(msg, "connection refused")
+}
+
+// =================== 熔断 ===================
+
+// circuitBreaker 服务连续失败达到阈值后熔断一段时间，期间跳过该服务
+// 冷却结束后放行请求试探，成功即恢复，失败则再次熔断
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !time.Now().Before(b.openUntil)
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.openUntil = time.Time{}
+}
+
+func (b *circuitBreaker) failure() {
+	conf := config.GetConfig().ResilienceConfig
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if conf.BreakerThreshold > 0 && b.failures >= conf.BreakerThreshold {
+		b.openUntil = time.Now().Add(time.Duration(conf.BreakerCooldown) * time.Second)
+	}
+}
+
+var (
+	breakers   = make(map[string]*circuitBreaker)
+	breakersMu sync.Mutex
+)
+
+// breakerFor 获取模型所属服务的熔断器，同一服务地址上的模型共享同一个熔断器
+func breakerFor(modelType string) *circuitBreaker {
+	key := modelType
+	if conf, ok := config.GetConfig().GetModelConfig(modelType); ok {
+		key = conf.Provider + "|" + baseURLOf(conf)
+	}
+
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b, ok := breakers[key]
+	if !ok {
+		b = &circuitBreaker{}
+		breakers[key] = b
+	}
+	return b
+}
+
+// =================== 记录实际回答的模型 ===================
+
+type answeredModelKey struct{}
+
+// withAnsweredModel 返回可记录实际回答模型的 ctx，发生备用模型切换时可据此得知由哪个模型回答
+func withAnsweredModel(ctx context.Context) (context.Context, *string) {
+	answered := new(string)
+	return context.WithValue(ctx, answeredModelKey{}, answered), answered
+}
+
+func recordAnsweredModel(ctx context.Context, modelType string) {
+	if answered, ok := ctx.Value(answeredModelKey{}).(*string); ok {
+		*answered = modelType
+	}
+}
+
+// answeredModel 取出已记录的实际回答的模型，未记录时返回空
+func answeredModel(ctx context.Context) string {
+	if answered, ok := ctx.Value(answeredModelKey{}).(*string); ok {
+		return *answered
+	}
+	return ""
+}
+
+// =================== 备用模型的生成选项 ===================
+
+type optionsResolverKey struct{}
+
+// withOptionsResolver 返回可为备用模型重新计算生成选项的 ctx，各模型的默认参数和允许范围不同
+func withOptionsResolver(ctx context.Context, resolve func(modelType string) ([]model.Option, error)) context.Context {
+	return context.WithValue(ctx, optionsResolverKey{}, resolve)
+}
+
+// =================== 记录调用的副作用 ===================
+
+type sideEffectsKey struct{}
+
+// withSideEffects 返回可记录本次调用是否已产生副作用的 ctx
+func withSideEffects(ctx context.Context) (context.Context, *atomic.Bool) {
+	effects := new(atomic.Bool)
+	return context.WithValue(ctx, sideEffectsKey{}, effects), effects
+}
+
+// markSideEffect 记录本次调用已产生副作用（输出内容、调用工具或通知前端），此后失败不能再重试
+func markSideEffect(ctx context.Context) {
+	if effects, ok := ctx.Value(sideEffectsKey{}).(*atomic.Bool); ok {
+		effects.Store(true)
+	}
+}
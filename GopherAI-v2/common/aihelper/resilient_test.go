@@ -0,0 +1,41 @@
+package aihelper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	ollamaapi "github.com/eino-contrib/ollama/api"
+	goopenai "github.com/meguminnnnnnnnn/go-openai"
+)
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "openai 429", err: fmt.Errorf("failed to create chat completion: %w", &goopenai.APIError{HTTPStatusCode: 429, Message: "rate limited"}), want: true},
+		{name: "openai 503", err: fmt.Errorf("failed to create chat completion: %w", &goopenai.RequestError{HTTPStatusCode: 503}), want: true},
+		// 错误信息中的其他数字不是状态码
+		{name: "openai 400 with number", err: fmt.Errorf("failed to create chat completion: %w", &goopenai.APIError{HTTPStatusCode: 400, Message: "max_tokens 512 exceeds limit"}), want: false},
+		{name: "openai 401", err: &goopenai.APIError{HTTPStatusCode: 401, Message: "invalid api key, request 500"}, want: false},
+		{name: "ollama 500", err: fmt.Errorf("ollama: %w", ollamaapi.StatusError{StatusCode: 500, Status: "500 Internal Server Error"}), want: true},
+		{name: "ollama 404", err: ollamaapi.StatusError{StatusCode: 404, ErrorMessage: "model not found"}, want: false},
+		{name: "status code in message", err: errors.New("error, status code: 502, status: bad gateway"), want: true},
+		{name: "number without status code", err: errors.New("max_tokens 512 exceeds limit"), want: false},
+		{name: "deadline", err: fmt.Errorf("call: %w", context.DeadlineExceeded), want: true},
+		{name: "timeout text", err: errors.New("i/o timeout"), want: true},
+		{name: "connection refused", err: errors.New("dial tcp: connection refused"), want: true},
+		{name: "too many requests", err: errors.New("429 too many requests"), want: true},
+		{name: "other", err: errors.New("invalid request"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientError(tt.err); got != tt.want {
+				t.Fatalf("isTransientError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
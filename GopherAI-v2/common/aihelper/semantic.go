@@ -0,0 +1,273 @@
+package aihelper
+
+import (
+	"GopherAI/common/cache"
+	"GopherAI/common/rag"
+	"GopherAI/config"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/eino/components/embedding"
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+// semanticReplayChunk 命中缓存时按流式输出回放回答，每个片段的字符数
+const semanticReplayChunk = 16
+
+// semanticEntry 缓存的一条问答
+type semanticEntry struct {
+	Query     string    `json:"query"`
+	Embedding []float32 `json:"embedding"`
+	Answer    string    `json:"answer"`
+	ModelType string    `json:"modelType"` // 实际回答的模型（可能是备用模型）
+	ExpiresAt int64     `json:"expiresAt"` // Unix 秒
+}
+
+// semanticCacheModel 在模型前加一层语义缓存：独立的问题（上下文中除系统消息外只有这一个问题）
+// 与之前的问题足够相似时直接返回之前的回答，不再调用模型
+// 作用域为同一用户、模型、知识库、系统提示词和生成参数，用户的知识库文档变化后整体失效
+type semanticCacheModel struct {
+	AIModel
+	owner         string // 用户名
+	knowledgeBase string
+
+	embedderOnce sync.Once
+	embedder     embedding.Embedder
+}
+
+func newSemanticCacheModel(m AIModel, owner string, knowledgeBase string) *semanticCacheModel {
+	return &semanticCacheModel{AIModel: m, owner: owner, knowledgeBase: knowledgeBase}
+}
+
+// InvalidateSemanticCache 删除用户的所有缓存回答，用户的知识库文档变化时调用
+func InvalidateSemanticCache(userName string) {
+	if err := cache.InvalidateSemanticEntries(userName); err != nil {
+		log.Printf("[semanticCache] invalidate user=%s failed: %v", userName, err)
+	}
+}
+
+func (s *semanticCacheModel) GenerateResponse(ctx context.Context, messages []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	lookup := s.lookup(ctx, messages, opts)
+	if entry := lookup.hit(); entry != nil {
+		s.replayed(ctx, entry)
+		return schema.AssistantMessage(entry.Answer, nil), nil
+	}
+
+	resp, err := s.AIModel.GenerateResponse(ctx, messages, opts...)
+	if err == nil {
+		lookup.store(ctx, resp.Content)
+	}
+	return resp, err
+}
+
+func (s *semanticCacheModel) StreamResponse(ctx context.Context, messages []*schema.Message, cb StreamCallback, opts ...model.Option) (string, error) {
+	lookup := s.lookup(ctx, messages, opts)
+	if entry := lookup.hit(); entry != nil {
+		s.replayed(ctx, entry)
+		answer := []rune(entry.Answer)
+		for i := 0; i < len(answer); i += semanticReplayChunk {
+			if err := ctx.Err(); err != nil {
+				return string(answer[:i]), err
+			}
+			cb(string(answer[i:min(i+semanticReplayChunk, len(answer))]))
+		}
+		return entry.Answer, nil
+	}
+
+	content, err := s.AIModel.StreamResponse(ctx, messages, cb, opts...)
+	if err == nil {
+		lookup.store(ctx, content)
+	}
+	return content, err
+}
+
+// Close 释放被包装的模型
+func (s *semanticCacheModel) Close() { closeModel(s.AIModel) }
+
+// replayed 命中缓存：回答没有调用模型，用量记为 0
+func (s *semanticCacheModel) replayed(ctx context.Context, entry *semanticEntry) {
+	recordAnsweredModel(ctx, entry.ModelType)
+	recordUsage(ctx, entry.ModelType, &schema.TokenUsage{})
+}
+
+// semanticLookup 一次查询的结果，不适用缓存（如不是独立的问题、向量化失败）时 query 为空
+type semanticLookup struct {
+	s         *semanticCacheModel
+	scope     string
+	query     string
+	embedding []float32
+	best      *semanticEntry
+}
+
+// lookup 向量化独立的问题并查找作用域中最相似的回答
+func (s *semanticCacheModel) lookup(ctx context.Context, messages []*schema.Message, opts []model.Option) *semanticLookup {
+	l := &semanticLookup{s: s}
+	var system []string
+	for _, msg := range messages {
+		if msg.Role == schema.System {
+			system = append(system, msg.Content)
+			continue
+		}
+		if l.query != "" || msg.Role != schema.User {
+			// 有历史对话时问题可能依赖上下文，不使用缓存
+			l.query = ""
+			return l
+		}
+		l.query = msg.Content
+	}
+	if l.query == "" {
+		return l
+	}
+
+	embedder := s.getEmbedder(ctx)
+	if embedder == nil {
+		l.query = ""
+		return l
+	}
+	vectors, err := embedder.EmbedStrings(ctx, []string{l.query})
+	if err != nil || len(vectors) == 0 {
+		log.Printf("[semanticCache] embed query failed: %v", err)
+		l.query = ""
+		return l
+	}
+	l.embedding = make([]float32, len(vectors[0]))
+	for i, v := range vectors[0] {
+		l.embedding[i] = float32(v)
+	}
+	l.scope = semanticScope(s.GetModelType(), s.knowledgeBase, strings.Join(system, "\n"), opts)
+
+	entries, err := cache.SemanticEntries(s.owner, l.scope)
+	if err != nil {
+		log.Printf("[semanticCache] read entries failed: %v", err)
+		return l
+	}
+	now := time.Now().Unix()
+	bestScore := config.GetConfig().SemanticCache.Threshold
+	for _, raw := range entries {
+		entry := new(semanticEntry)
+		if err := json.Unmarshal(raw, entry); err != nil || entry.ExpiresAt <= now {
+			continue
+		}
+		if score := cosineSimilarity(l.embedding, entry.Embedding); score >= bestScore {
+			bestScore = score
+			l.best = entry
+		}
+	}
+	return l
+}
+
+// hit 返回命中的回答，未命中时返回 nil
+func (l *semanticLookup) hit() *semanticEntry {
+	if l.best != nil {
+		log.Printf("[semanticCache] hit user=%s model=%s", l.s.owner, l.best.ModelType)
+	}
+	return l.best
+}
+
+// store 缓存模型对问题的回答，要求结构化格式时只缓存通过 JSON Schema 校验的回答
+func (l *semanticLookup) store(ctx context.Context, answer string) {
+	if l.query == "" || answer == "" {
+		return
+	}
+	if s := responseSchemaFrom(ctx); s != nil {
+		valid, err := s.validate(answer)
+		if err != nil {
+			return
+		}
+		answer = valid
+	}
+	conf := config.GetConfig().SemanticCache
+	ttl := time.Duration(conf.TTL) * time.Second
+	modelType := answeredModel(ctx)
+	if modelType == "" {
+		modelType = l.s.GetModelType()
+	}
+	data, err := json.Marshal(semanticEntry{
+		Query:     l.query,
+		Embedding: l.embedding,
+		Answer:    answer,
+		ModelType: modelType,
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+	})
+	if err != nil {
+		return
+	}
+	if err := cache.AddSemanticEntry(l.s.owner, l.scope, data, conf.MaxEntries, ttl); err != nil {
+		log.Printf("[semanticCache] store entry failed: %v", err)
+	}
+}
+
+// getEmbedder 第一次使用时创建向量模型，创建失败时不使用缓存
+func (s *semanticCacheModel) getEmbedder(ctx context.Context) embedding.Embedder {
+	s.embedderOnce.Do(func() {
+		embedder, err := rag.NewEmbedder(ctx)
+		if err != nil {
+			log.Printf("[semanticCache] %v", err)
+			return
+		}
+		s.embedder = embedder
+	})
+	return s.embedder
+}
+
+// semanticOptions 计算缓存作用域所需、但无法从服务商专有选项中读出的调用选项
+type semanticOptions struct {
+	extraFields map[string]any
+}
+
+// withSemanticExtraFields 记录请求体中的其他字段（seed、response_format 等）供语义缓存区分作用域，模型实现会忽略该选项
+func withSemanticExtraFields(fields map[string]any) model.Option {
+	return model.WrapImplSpecificOptFn(func(o *semanticOptions) {
+		o.extraFields = fields
+	})
+}
+
+// semanticScope 模型、知识库、系统提示词和生成参数（含 seed 和结构化输出格式）相同的问题才能共用回答
+func semanticScope(modelType string, knowledgeBase string, systemPrompt string, opts []model.Option) string {
+	common := model.GetCommonOptions(nil, opts...)
+	params := ""
+	if common.Temperature != nil {
+		params += fmt.Sprintf("temperature=%v;", *common.Temperature)
+	}
+	if common.MaxTokens != nil {
+		params += fmt.Sprintf("maxTokens=%v;", *common.MaxTokens)
+	}
+	if common.TopP != nil {
+		params += fmt.Sprintf("topP=%v;", *common.TopP)
+	}
+	if common.Stop != nil {
+		params += fmt.Sprintf("stop=%q;", common.Stop)
+	}
+	if extra := model.GetImplSpecificOptions(&semanticOptions{}, opts...).extraFields; len(extra) > 0 {
+		// map 按键排序序列化，结果稳定
+		data, _ := json.Marshal(extra)
+		params += fmt.Sprintf("extra=%s;", data)
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{modelType, knowledgeBase, systemPrompt, params}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+func cosineSimilarity(a []float32, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
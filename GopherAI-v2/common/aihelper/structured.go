@@ -0,0 +1,153 @@
+package aihelper
+
+import (
+	"GopherAI/config"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// 服务商的结构化输出模式，在模型注册表的 capabilities.jsonMode 中声明
+const (
+	JSONModeSchema = "json_schema" // 按 JSON Schema 约束输出
+	JSONModeObject = "json_object" // 只保证输出合法的 JSON
+)
+
+// ErrStructuredOutputInvalid 模型多次修复后回答仍不符合 JSON Schema
+var ErrStructuredOutputInvalid = errors.New("structured output does not match schema")
+
+// responseSchemaURL 编译请求中的 Schema 时使用的资源地址，$ref 只能引用 Schema 内部（如 #/$defs/...）
+const responseSchemaURL = "urn:gopherai:response-schema"
+
+// ResponseSchema 请求要求的回答格式，回答需是符合该 JSON Schema 的 JSON
+type ResponseSchema struct {
+	raw    json.RawMessage
+	schema *jsonschema.Schema
+}
+
+// ParseResponseSchema 解析请求中的 JSON Schema，不是合法的 Schema 时返回错误
+// 未声明 $schema 时按 2020-12 版本解析
+func ParseResponseSchema(raw json.RawMessage) (*ResponseSchema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("parse response schema: %w", err)
+	}
+	c := jsonschema.NewCompiler()
+	c.DefaultDraft(jsonschema.Draft2020)
+	// 不加载外部资源，避免请求借 $ref 读取本地文件或访问网络
+	c.UseLoader(noExternalLoader{})
+	if err := c.AddResource(responseSchemaURL, doc); err != nil {
+		return nil, fmt.Errorf("invalid response schema: %w", err)
+	}
+	s, err := c.Compile(responseSchemaURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response schema: %w", err)
+	}
+	return &ResponseSchema{raw: raw, schema: s}, nil
+}
+
+// noExternalLoader 拒绝加载任何外部 Schema
+type noExternalLoader struct{}
+
+func (noExternalLoader) Load(url string) (any, error) {
+	return nil, fmt.Errorf("external schema %s is not allowed", url)
+}
+
+type responseSchemaKey struct{}
+
+// WithResponseSchema 返回要求本轮以结构化格式回答的 ctx，只对非流式生成生效
+func WithResponseSchema(ctx context.Context, s *ResponseSchema) context.Context {
+	return context.WithValue(ctx, responseSchemaKey{}, s)
+}
+
+func responseSchemaFrom(ctx context.Context) *ResponseSchema {
+	s, _ := ctx.Value(responseSchemaKey{}).(*ResponseSchema)
+	return s
+}
+
+// instruction 约束回答格式的系统消息，服务商不支持结构化输出时只能依靠它
+func (s *ResponseSchema) instruction() *schema.Message {
+	return schema.SystemMessage(fmt.Sprintf(`请只输出一个符合以下 JSON Schema 的 JSON，不要输出任何解释或 Markdown 代码块：
+%s`, s.raw))
+}
+
+// responseFormat 按模型声明的结构化输出模式生成 OpenAI 兼容接口的 response_format 字段，不支持时返回 nil
+func (s *ResponseSchema) responseFormat(modelType string) map[string]any {
+	conf, _ := config.GetConfig().GetModelConfig(modelType)
+	switch conf.Capabilities.JSONMode {
+	case JSONModeSchema:
+		return map[string]any{
+			"type": JSONModeSchema,
+			"json_schema": map[string]any{
+				"name":   "response",
+				"schema": s.raw,
+			},
+		}
+	case JSONModeObject:
+		return map[string]any{"type": JSONModeObject}
+	}
+	return nil
+}
+
+// validate 校验回答是否符合 JSON Schema，返回去掉代码块等包装后的 JSON
+func (s *ResponseSchema) validate(content string) (string, error) {
+	content = strings.TrimSpace(content)
+	if strings.HasPrefix(content, "```") {
+		content = strings.TrimPrefix(content, "```json")
+		content = strings.TrimPrefix(content, "```")
+		content = strings.TrimSuffix(strings.TrimSpace(content), "```")
+		content = strings.TrimSpace(content)
+	}
+	value, err := jsonschema.UnmarshalJSON(strings.NewReader(content))
+	if err != nil {
+		return "", fmt.Errorf("not valid JSON: %w", err)
+	}
+	if err := s.schema.Validate(value); err != nil {
+		return "", err
+	}
+	return content, nil
+}
+
+// withSchemaInstruction 在最后一个问题前插入约束回答格式的系统消息
+func withSchemaInstruction(messages []*schema.Message, s *ResponseSchema) []*schema.Message {
+	if len(messages) == 0 {
+		return append(messages, s.instruction())
+	}
+	out := make([]*schema.Message, 0, len(messages)+1)
+	out = append(out, messages[:len(messages)-1]...)
+	out = append(out, s.instruction())
+	return append(out, messages[len(messages)-1])
+}
+
+// generateStructured 生成结构化回答：回答不符合 JSON Schema 时把错误告诉模型让其修复，超过次数后返回 ErrStructuredOutputInvalid
+// 返回的消息内容为校验通过的 JSON
+func (a *AIHelper) generateStructured(ctx context.Context, messages []*schema.Message, s *ResponseSchema, opts ...model.Option) (*schema.Message, error) {
+	maxRepairs := config.GetConfig().StructuredOutput.MaxRepairs
+	messages = withSchemaInstruction(messages, s)
+	for attempt := 0; ; attempt++ {
+		resp, err := a.getModel().GenerateResponse(ctx, messages, opts...)
+		if err != nil {
+			return nil, err
+		}
+		content, err := s.validate(resp.Content)
+		if err == nil {
+			resp.Content = content
+			return resp, nil
+		}
+		if attempt >= maxRepairs {
+			log.Printf("[AIHelper] session=%s structured output invalid after %d repairs: %v", a.SessionID, maxRepairs, err)
+			return nil, fmt.Errorf("%w: %v", ErrStructuredOutputInvalid, err)
+		}
+		messages = append(messages,
+			schema.AssistantMessage(resp.Content, nil),
+			schema.UserMessage(fmt.Sprintf("你的回答不符合要求的 JSON Schema：%v\n请只输出修正后的 JSON。", err)))
+	}
+}
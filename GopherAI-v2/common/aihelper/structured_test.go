@@ -0,0 +1,94 @@
+package aihelper
+
+import (
+	"testing"
+)
+
+func TestParseResponseSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		wantErr bool
+	}{
+		{name: "object", schema: `{"type":"object","properties":{"name":{"type":"string"}},"required":["name"]}`},
+		{name: "type list", schema: `{"type":["string","null"]}`},
+		{name: "defs and ref", schema: `{"$defs":{"n":{"type":"integer"}},"type":"array","items":{"$ref":"#/$defs/n"}}`},
+		{name: "const", schema: `{"const":"ok"}`},
+		{name: "draft 7", schema: `{"$schema":"http://json-schema.org/draft-07/schema#","definitions":{"n":{"type":"number"}},"$ref":"#/definitions/n"}`},
+		{name: "not json", schema: `{"type":`, wantErr: true},
+		{name: "unknown type", schema: `{"type":"text"}`, wantErr: true},
+		{name: "bad required", schema: `{"required":"name"}`, wantErr: true},
+		// 不加载外部 Schema
+		{name: "external ref", schema: `{"$ref":"file:///etc/passwd"}`, wantErr: true},
+		{name: "remote ref", schema: `{"$ref":"https://example.com/schema.json"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseResponseSchema([]byte(tt.schema))
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("got %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResponseSchemaValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		content string
+		want    string // 校验通过时返回的 JSON
+		wantErr bool
+	}{
+		{
+			name:    "valid object",
+			schema:  `{"type":"object","properties":{"age":{"type":"integer"}},"required":["age"]}`,
+			content: `{"age": 3}`,
+			want:    `{"age": 3}`,
+		},
+		{
+			name:    "code fence",
+			schema:  `{"type":"object"}`,
+			content: "```json\n{\"a\":1}\n```",
+			want:    `{"a":1}`,
+		},
+		{
+			name:    "bare code fence",
+			schema:  `{"type":"array"}`,
+			content: "  ```\n[1, 2]\n```  ",
+			want:    `[1, 2]`,
+		},
+		{
+			name:    "missing required",
+			schema:  `{"type":"object","required":["age"]}`,
+			content: `{}`,
+			wantErr: true,
+		},
+		{
+			name:    "wrong type",
+			schema:  `{"type":"object","properties":{"age":{"type":"integer"}}}`,
+			content: `{"age":"3"}`,
+			wantErr: true,
+		},
+		{name: "null allowed", schema: `{"type":["string","null"]}`, content: `null`, want: `null`},
+		{name: "const mismatch", schema: `{"const":"ok"}`, content: `"no"`, wantErr: true},
+		{name: "ref", schema: `{"$defs":{"n":{"type":"integer"}},"items":{"$ref":"#/$defs/n"}}`, content: `[1, 2.5]`, wantErr: true},
+		{name: "additional properties", schema: `{"type":"object","additionalProperties":false}`, content: `{"x":1}`, wantErr: true},
+		{name: "not json", schema: `{"type":"object"}`, content: `好的，结果如下`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseResponseSchema([]byte(tt.schema))
+			if err != nil {
+				t.Fatalf("parse schema: %v", err)
+			}
+			got, err := s.validate(tt.content)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("got %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package aihelper
+
+import (
+	"unicode"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// messageTokenOverhead 每条消息的角色、分隔符等格式开销
+const messageTokenOverhead = 4
+
+// CountTokens 在本地估算文本的 token 数，不依赖模型服务
+// 中日韩字符大多单独成 token，按 1 个计；其余字符按约 4 个字符 1 个 token 计
+func CountTokens(text string) int {
+	cjk, other := 0, 0
+	for _, r := range text {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			cjk++
+		} else {
+			other++
+		}
+	}
+	return cjk + (other+3)/4
+}
+
+// CountMessagesTokens 估算一组消息的 token 数
+func CountMessagesTokens(messages []*schema.Message) int {
+	total := 0
+	for _, msg := range messages {
+		total += CountTokens(msg.Content) + messageTokenOverhead
+		for _, call := range msg.ToolCalls {
+			total += CountTokens(call.Function.Name) + CountTokens(call.Function.Arguments)
+		}
+	}
+	return total
+}
@@ -0,0 +1,74 @@
+package aihelper
+
+import (
+	"GopherAI/model"
+	"context"
+	"sync"
+	"time"
+)
+
+// toolInvocation 一次工具调用的记录
+type toolInvocation struct {
+	callID    string
+	name      string // 交给模型的工具名（含服务名前缀）
+	arguments string
+	result    string // 交给模型的结果文本，失败时为错误说明
+	err       string
+	latency   time.Duration
+}
+
+// toolRecorder 收集一轮对话中的工具调用，回答保存时一并作为消息保存
+type toolRecorder struct {
+	mu    sync.Mutex
+	calls []toolInvocation
+}
+
+type toolRecorderKey struct{}
+
+// withToolRecorder 返回可记录工具调用的 ctx
+func withToolRecorder(ctx context.Context) (context.Context, *toolRecorder) {
+	r := &toolRecorder{}
+	return context.WithValue(ctx, toolRecorderKey{}, r), r
+}
+
+// recordToolCalls 按调用顺序记录模型一步中的工具调用
+func recordToolCalls(ctx context.Context, calls []toolInvocation) {
+	markSideEffect(ctx)
+	r, ok := ctx.Value(toolRecorderKey{}).(*toolRecorder)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, calls...)
+}
+
+// messages 将记录转换为消息：每次调用一条 tool_call，紧跟一条 tool_result，
+// 消息ID和父消息ID由调用方在挂到分支上时设置
+func (r *toolRecorder) messages(sessionID string, userName string, modelType string) []*model.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	msgs := make([]*model.Message, 0, 2*len(r.calls))
+	for _, call := range r.calls {
+		msgs = append(msgs, &model.Message{
+			SessionID:     sessionID,
+			UserName:      userName,
+			Kind:          model.MessageKindToolCall,
+			ModelType:     modelType,
+			ToolCallID:    call.callID,
+			ToolName:      call.name,
+			ToolArguments: call.arguments,
+		}, &model.Message{
+			SessionID:  sessionID,
+			UserName:   userName,
+			Kind:       model.MessageKindToolResult,
+			Content:    call.result,
+			ModelType:  modelType,
+			ToolCallID: call.callID,
+			ToolName:   call.name,
+			LatencyMs:  call.latency.Milliseconds(),
+			Error:      call.err,
+		})
+	}
+	return msgs
+}
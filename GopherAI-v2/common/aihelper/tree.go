@@ -0,0 +1,97 @@
+package aihelper
+
+import (
+	"GopherAI/model"
+)
+
+// messageTree 会话的消息树：每条消息通过 ParentID 指向上一条消息，
+// 编辑问题或重新生成回答时会在同一父消息下产生新的分支
+type messageTree struct {
+	nodes    map[string]*model.Message // MessageID → 消息
+	children map[string][]string       // 父消息ID → 子消息ID（按创建顺序），第一条消息的父ID为 ""
+}
+
+func newMessageTree() *messageTree {
+	return &messageTree{
+		nodes:    make(map[string]*model.Message),
+		children: make(map[string][]string),
+	}
+}
+
+// add 添加消息，调用方需保证 MessageID 已生成
+func (t *messageTree) add(msg *model.Message) {
+	t.nodes[msg.MessageID] = msg
+	t.children[msg.ParentID] = append(t.children[msg.ParentID], msg.MessageID)
+}
+
+// pathTo 返回从第一条消息到 leafID 的路径，leafID 为空时返回空路径
+func (t *messageTree) pathTo(leafID string) []*model.Message {
+	var reversed []*model.Message
+	for id := leafID; id != ""; {
+		msg, ok := t.nodes[id]
+		if !ok {
+			break
+		}
+		reversed = append(reversed, msg)
+		id = msg.ParentID
+	}
+
+	path := make([]*model.Message, len(reversed))
+	for i, msg := range reversed {
+		path[len(reversed)-1-i] = msg
+	}
+	return path
+}
+
+// latestLeaf 从 id 开始沿最新的子消息向下，返回该分支的最后一条消息
+func (t *messageTree) latestLeaf(id string) string {
+	for {
+		children := t.children[id]
+		if len(children) == 0 {
+			return id
+		}
+		id = children[len(children)-1]
+	}
+}
+
+// history 将路径转换为历史记录，并附带每条消息的兄弟分支
+func (t *messageTree) history(path []*model.Message) []model.History {
+	history := make([]model.History, 0, len(path))
+	for _, msg := range path {
+		h := model.History{
+			MessageID:     msg.MessageID,
+			ParentID:      msg.ParentID,
+			Kind:          msg.GetKind(),
+			IsUser:        msg.IsUser,
+			Content:       msg.Content,
+			Stopped:       msg.Stopped,
+			ModelType:     msg.ModelType,
+			ToolName:      msg.ToolName,
+			ToolArguments: msg.ToolArguments,
+			LatencyMs:     msg.LatencyMs,
+			Error:         msg.Error,
+		}
+		if siblings := t.children[msg.ParentID]; len(siblings) > 1 {
+			h.Siblings = append([]string(nil), siblings...)
+		}
+		history = append(history, h)
+	}
+	return history
+}
+
+// buildMessageTree 由数据库中的消息构建消息树，并确定当前分支
+// activeLeafID 不存在（如尚未持久化）时使用最新的一条消息
+func buildMessageTree(msgs []model.Message, activeLeafID string) (*messageTree, []*model.Message) {
+	tree := newMessageTree()
+	for i := range msgs {
+		tree.add(&msgs[i])
+	}
+
+	if _, ok := tree.nodes[activeLeafID]; !ok {
+		activeLeafID = ""
+		if len(msgs) > 0 {
+			activeLeafID = msgs[len(msgs)-1].MessageID
+		}
+	}
+	return tree, tree.pathTo(activeLeafID)
+}
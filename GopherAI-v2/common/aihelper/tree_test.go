@@ -0,0 +1,113 @@
+package aihelper
+
+import (
+	"GopherAI/model"
+	"reflect"
+	"testing"
+)
+
+// branchingMessages q1 下有两个回答 a1、a2（重新生成），a1 下接着问了 q2
+func branchingMessages() []model.Message {
+	return []model.Message{
+		{MessageID: "q1", ParentID: "", IsUser: true, Content: "问题1"},
+		{MessageID: "a1", ParentID: "q1", Content: "回答1"},
+		{MessageID: "q2", ParentID: "a1", IsUser: true, Content: "问题2"},
+		{MessageID: "a2", ParentID: "q1", Content: "回答1（重新生成）"},
+	}
+}
+
+func messageIDs(msgs []*model.Message) []string {
+	ids := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		ids = append(ids, msg.MessageID)
+	}
+	return ids
+}
+
+func TestMessageTreePath(t *testing.T) {
+	tree, _ := buildMessageTree(branchingMessages(), "")
+	tests := []struct {
+		leaf string
+		want []string
+	}{
+		{leaf: "q2", want: []string{"q1", "a1", "q2"}},
+		{leaf: "a2", want: []string{"q1", "a2"}},
+		{leaf: "q1", want: []string{"q1"}},
+		{leaf: "", want: []string{}},
+		{leaf: "missing", want: []string{}},
+	}
+	for _, tt := range tests {
+		if got := messageIDs(tree.pathTo(tt.leaf)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("pathTo(%q) = %v, want %v", tt.leaf, got, tt.want)
+		}
+	}
+}
+
+func TestMessageTreeLatestLeaf(t *testing.T) {
+	tree, _ := buildMessageTree(branchingMessages(), "")
+	tests := []struct {
+		from string
+		want string
+	}{
+		{from: "", want: "a2"}, // 沿最新的子消息：q1 → a2
+		{from: "a1", want: "q2"},
+		{from: "q2", want: "q2"},
+	}
+	for _, tt := range tests {
+		if got := tree.latestLeaf(tt.from); got != tt.want {
+			t.Errorf("latestLeaf(%q) = %q, want %q", tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestBuildMessageTreeActiveBranch(t *testing.T) {
+	tests := []struct {
+		name       string
+		activeLeaf string
+		want       []string
+	}{
+		{name: "saved leaf", activeLeaf: "q2", want: []string{"q1", "a1", "q2"}},
+		{name: "other branch", activeLeaf: "a2", want: []string{"q1", "a2"}},
+		// 叶子未持久化时使用最新的一条消息
+		{name: "unknown leaf", activeLeaf: "missing", want: []string{"q1", "a2"}},
+		{name: "empty leaf", activeLeaf: "", want: []string{"q1", "a2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, path := buildMessageTree(branchingMessages(), tt.activeLeaf)
+			if got := messageIDs(path); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("active branch %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageTreeHistorySiblings(t *testing.T) {
+	tree, path := buildMessageTree(branchingMessages(), "q2")
+	history := tree.history(path)
+	want := map[string][]string{
+		"q1": nil,
+		"a1": {"a1", "a2"},
+		"q2": nil,
+	}
+	if len(history) != len(want) {
+		t.Fatalf("got %d history entries, want %d", len(history), len(want))
+	}
+	for _, h := range history {
+		if !reflect.DeepEqual(h.Siblings, want[h.MessageID]) {
+			t.Errorf("siblings of %s = %v, want %v", h.MessageID, h.Siblings, want[h.MessageID])
+		}
+	}
+}
+
+func TestMessageTreeAddBranch(t *testing.T) {
+	tree, _ := buildMessageTree(branchingMessages(), "")
+	tree.add(&model.Message{MessageID: "q2b", ParentID: "a1", IsUser: true, Content: "问题2（修改）"})
+
+	if got := tree.children["a1"]; !reflect.DeepEqual(got, []string{"q2", "q2b"}) {
+		t.Fatalf("children of a1 = %v", got)
+	}
+	if got := messageIDs(tree.pathTo(tree.latestLeaf("a1"))); !reflect.DeepEqual(got, []string{"q1", "a1", "q2b"}) {
+		t.Fatalf("latest branch under a1 = %v", got)
+	}
+}
@@ -0,0 +1,113 @@
+package aihelper
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrSessionBusy 会话正在处理其他轮次，且配置为不排队
+var ErrSessionBusy = errors.New("session busy")
+
+// turnQueue 会话的轮次队列：同一时刻只有一个轮次（问题及其回答）在执行，其余按到达顺序排队
+type turnQueue struct {
+	mu      sync.Mutex
+	busy    bool
+	waiting []*turnWaiter
+}
+
+// turnWaiter 排队中的轮次
+type turnWaiter struct {
+	ready chan struct{} // 轮到该轮次时关闭
+	moved chan struct{} // 队列前移时通知，容量为 1
+}
+
+// acquire 获取轮次；wait 为 false 时若会话正忙直接返回 ErrSessionBusy
+// 排队期间每当位置变化都会调用 onPosition（位置从 1 开始）
+func (q *turnQueue) acquire(ctx context.Context, wait bool, onPosition func(int)) error {
+	q.mu.Lock()
+	if !q.busy {
+		q.busy = true
+		q.mu.Unlock()
+		return nil
+	}
+	if !wait {
+		q.mu.Unlock()
+		return ErrSessionBusy
+	}
+	w := &turnWaiter{
+		ready: make(chan struct{}),
+		moved: make(chan struct{}, 1),
+	}
+	q.waiting = append(q.waiting, w)
+	position := len(q.waiting)
+	q.mu.Unlock()
+
+	for {
+		if onPosition != nil && position > 0 {
+			onPosition(position)
+		}
+		select {
+		case <-w.ready:
+			return nil
+		case <-w.moved:
+			q.mu.Lock()
+			position = q.indexOf(w) + 1 // 已出队时为 0，下一轮循环会从 ready 返回
+			q.mu.Unlock()
+		case <-ctx.Done():
+			q.mu.Lock()
+			if i := q.indexOf(w); i >= 0 {
+				q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+				q.notifyFrom(i)
+				q.mu.Unlock()
+				return ctx.Err()
+			}
+			q.mu.Unlock()
+			// 取消的同时已经轮到了该轮次，交给下一个
+			q.release()
+			return ctx.Err()
+		}
+	}
+}
+
+// release 结束当前轮次，把会话交给队首的轮次
+func (q *turnQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.waiting) == 0 {
+		q.busy = false
+		return
+	}
+	next := q.waiting[0]
+	q.waiting = q.waiting[1:]
+	close(next.ready)
+	q.notifyFrom(0)
+}
+
+// isBusy 是否有轮次正在执行
+func (q *turnQueue) isBusy() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.busy
+}
+
+// indexOf 查找排队位置，调用方需持有锁
+func (q *turnQueue) indexOf(w *turnWaiter) int {
+	for i, o := range q.waiting {
+		if o == w {
+			return i
+		}
+	}
+	return -1
+}
+
+// notifyFrom 通知从 i 开始的轮次位置已前移，调用方需持有锁
+func (q *turnQueue) notifyFrom(i int) {
+	for _, w := range q.waiting[i:] {
+		select {
+		case w.moved <- struct{}{}:
+		default:
+		}
+	}
+}
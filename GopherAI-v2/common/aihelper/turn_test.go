@@ -0,0 +1,122 @@
+package aihelper
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// enqueue 在后台排队获取轮次，返回获取结果的 channel 和收到的排队位置
+func enqueue(t *testing.T, q *turnQueue, ctx context.Context) (<-chan error, <-chan int) {
+	t.Helper()
+	done := make(chan error, 1)
+	positions := make(chan int, 16)
+	go func() {
+		done <- q.acquire(ctx, true, func(p int) { positions <- p })
+	}()
+	return done, positions
+}
+
+func expectPosition(t *testing.T, positions <-chan int, want int) {
+	t.Helper()
+	select {
+	case got := <-positions:
+		if got != want {
+			t.Fatalf("position %d, want %d", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no position update, want %d", want)
+	}
+}
+
+func expectAcquired(t *testing.T, done <-chan error, want error) {
+	t.Helper()
+	select {
+	case err := <-done:
+		if !errors.Is(err, want) {
+			t.Fatalf("acquire got %v, want %v", err, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("acquire did not return")
+	}
+}
+
+func expectWaiting(t *testing.T, done <-chan error) {
+	t.Helper()
+	select {
+	case err := <-done:
+		t.Fatalf("acquire returned %v while the session is busy", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestTurnQueueAcquire(t *testing.T) {
+	tests := []struct {
+		name string
+		busy bool
+		wait bool
+		want error
+	}{
+		{name: "idle", busy: false, wait: false, want: nil},
+		{name: "idle wait", busy: false, wait: true, want: nil},
+		{name: "busy no wait", busy: true, wait: false, want: ErrSessionBusy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &turnQueue{busy: tt.busy}
+			if err := q.acquire(context.Background(), tt.wait, nil); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if !q.isBusy() {
+				t.Fatal("queue should be busy")
+			}
+		})
+	}
+}
+
+func TestTurnQueueOrder(t *testing.T) {
+	q := &turnQueue{}
+	if err := q.acquire(context.Background(), true, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	first, firstPos := enqueue(t, q, context.Background())
+	expectPosition(t, firstPos, 1)
+	second, secondPos := enqueue(t, q, context.Background())
+	expectPosition(t, secondPos, 2)
+
+	q.release()
+	expectAcquired(t, first, nil)
+	expectPosition(t, secondPos, 1)
+	expectWaiting(t, second)
+
+	q.release()
+	expectAcquired(t, second, nil)
+
+	q.release()
+	if q.isBusy() {
+		t.Fatal("queue should be idle after the last release")
+	}
+}
+
+func TestTurnQueueCancel(t *testing.T) {
+	q := &turnQueue{}
+	if err := q.acquire(context.Background(), true, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled, canceledPos := enqueue(t, q, ctx)
+	expectPosition(t, canceledPos, 1)
+	next, nextPos := enqueue(t, q, context.Background())
+	expectPosition(t, nextPos, 2)
+
+	cancel()
+	expectAcquired(t, canceled, context.Canceled)
+	// 取消的轮次出队，后面的轮次前移
+	expectPosition(t, nextPos, 1)
+
+	q.release()
+	expectAcquired(t, next, nil)
+}
@@ -0,0 +1,129 @@
+package aihelper
+
+import (
+	"GopherAI/dao/usage"
+	"GopherAI/model"
+	"context"
+	"log"
+	"sync"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// usageRecorder 按模型汇总一轮对话中各次模型调用返回的 token 用量
+// 工具调用循环中的每一步、上下文摘要等都会单独调用模型，用量累加到对应的模型上
+type usageRecorder struct {
+	mu      sync.Mutex
+	byModel map[string]*schema.TokenUsage
+}
+
+type usageRecorderKey struct{}
+
+// withUsageRecorder 返回可记录模型用量的 ctx
+func withUsageRecorder(ctx context.Context) (context.Context, *usageRecorder) {
+	r := &usageRecorder{byModel: make(map[string]*schema.TokenUsage)}
+	return context.WithValue(ctx, usageRecorderKey{}, r), r
+}
+
+// recordUsage 记录一次模型调用返回的用量，u 为空（服务商未返回用量）时忽略
+func recordUsage(ctx context.Context, modelType string, u *schema.TokenUsage) {
+	r, ok := ctx.Value(usageRecorderKey{}).(*usageRecorder)
+	if !ok || u == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	total, ok := r.byModel[modelType]
+	if !ok {
+		total = &schema.TokenUsage{}
+		r.byModel[modelType] = total
+	}
+	total.PromptTokens += u.PromptTokens
+	total.CompletionTokens += u.CompletionTokens
+}
+
+// usageOf 取出模型返回消息中的用量
+func usageOf(msg *schema.Message) *schema.TokenUsage {
+	if msg == nil || msg.ResponseMeta == nil {
+		return nil
+	}
+	return msg.ResponseMeta.Usage
+}
+
+// take 取出并清空记录的用量：answerModel 的用量在服务商未返回时按 prompt 和 completion 本地估算，
+// 其余模型（如摘要模型）只返回服务商给出的用量
+func (r *usageRecorder) take(answerModel string, prompt []*schema.Message, completion string) (*Usage, map[string]*Usage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	answer := &Usage{}
+	if u, ok := r.byModel[answerModel]; ok {
+		answer.PromptTokens, answer.CompletionTokens = u.PromptTokens, u.CompletionTokens
+	} else {
+		answer.PromptTokens, answer.CompletionTokens = CountMessagesTokens(prompt), CountTokens(completion)
+		answer.Estimated = true
+	}
+	answer.TotalTokens = answer.PromptTokens + answer.CompletionTokens
+
+	others := make(map[string]*Usage)
+	for modelType, u := range r.byModel {
+		if modelType == answerModel {
+			continue
+		}
+		others[modelType] = &Usage{
+			PromptTokens:     u.PromptTokens,
+			CompletionTokens: u.CompletionTokens,
+			TotalTokens:      u.PromptTokens + u.CompletionTokens,
+		}
+	}
+	r.byModel = make(map[string]*schema.TokenUsage)
+	return answer, others
+}
+
+// saveUsage 保存一轮对话的用量：回答所用模型的用量关联到回答消息，其他模型的用量不关联消息
+func (a *AIHelper) saveUsage(userName string, messageID string, answerModel string, answer *Usage, others map[string]*Usage) {
+	records := make([]*model.TokenUsage, 0, len(others)+1)
+	records = append(records, answer.record(userName, a.SessionID, messageID, answerModel))
+	for modelType, u := range others {
+		records = append(records, u.record(userName, a.SessionID, "", modelType))
+	}
+	if err := usage.CreateUsages(records); err != nil {
+		log.Printf("[AIHelper] session=%s save usage failed: %v", a.SessionID, err)
+	}
+}
+
+// saveReportedUsage 生成失败、被停止或回答未通过校验而没有保存回答时，保存服务商已返回的用量，不关联消息
+func (a *AIHelper) saveReportedUsage(userName string, r *usageRecorder) {
+	r.mu.Lock()
+	records := make([]*model.TokenUsage, 0, len(r.byModel))
+	for modelType, u := range r.byModel {
+		records = append(records, &model.TokenUsage{
+			UserName:         userName,
+			SessionID:        a.SessionID,
+			ModelType:        modelType,
+			PromptTokens:     u.PromptTokens,
+			CompletionTokens: u.CompletionTokens,
+		})
+	}
+	r.byModel = make(map[string]*schema.TokenUsage)
+	r.mu.Unlock()
+
+	if len(records) == 0 {
+		return
+	}
+	if err := usage.CreateUsages(records); err != nil {
+		log.Printf("[AIHelper] session=%s save usage failed: %v", a.SessionID, err)
+	}
+}
+
+func (u *Usage) record(userName string, sessionID string, messageID string, modelType string) *model.TokenUsage {
+	return &model.TokenUsage{
+		UserName:         userName,
+		SessionID:        sessionID,
+		MessageID:        messageID,
+		ModelType:        modelType,
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		Estimated:        u.Estimated,
+	}
+}
@@ -23,10 +23,42 @@ const (
 
 // MessageQueueParam 消息队列参数结构
 type MessageQueueParam struct {
+	MessageID string `json:"message_id"`
+	ParentID  string `json:"parent_id"`
 	SessionID string `json:"session_id"`
 	Content   string `json:"content"`
 	UserName  string `json:"user_name"`
 	IsUser    bool   `json:"is_user"`
+	Stopped   bool   `json:"stopped"`
+	ModelType string `json:"model_type"`
+	Kind      string `json:"kind"`
+
+	ToolCallID    string `json:"tool_call_id,omitempty"`
+	ToolName      string `json:"tool_name,omitempty"`
+	ToolArguments string `json:"tool_arguments,omitempty"`
+	LatencyMs     int64  `json:"latency_ms,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// ToMessage 转换为待持久化的消息
+func (p *MessageQueueParam) ToMessage() *model.Message {
+	return &model.Message{
+		MessageID: p.MessageID,
+		ParentID:  p.ParentID,
+		SessionID: p.SessionID,
+		Content:   p.Content,
+		UserName:  p.UserName,
+		IsUser:    p.IsUser,
+		Stopped:   p.Stopped,
+		ModelType: p.ModelType,
+		Kind:      p.Kind,
+
+		ToolCallID:    p.ToolCallID,
+		ToolName:      p.ToolName,
+		ToolArguments: p.ToolArguments,
+		LatencyMs:     p.LatencyMs,
+		Error:         p.Error,
+	}
 }
 
 // CacheManager 缓存管理器
@@ -119,12 +151,23 @@ func IsRedisEnabled() bool {
 }
 
 // GenerateMessageParam 生成消息队列参数
-func GenerateMessageParam(sessionID string, content string, userName string, isUser bool) []byte {
+func GenerateMessageParam(msg *model.Message) []byte {
 	param := MessageQueueParam{
-		SessionID: sessionID,
-		Content:   content,
-		UserName:  userName,
-		IsUser:    isUser,
+		MessageID: msg.MessageID,
+		ParentID:  msg.ParentID,
+		SessionID: msg.SessionID,
+		Content:   msg.Content,
+		UserName:  msg.UserName,
+		IsUser:    msg.IsUser,
+		Stopped:   msg.Stopped,
+		ModelType: msg.ModelType,
+		Kind:      msg.Kind,
+
+		ToolCallID:    msg.ToolCallID,
+		ToolName:      msg.ToolName,
+		ToolArguments: msg.ToolArguments,
+		LatencyMs:     msg.LatencyMs,
+		Error:         msg.Error,
 	}
 	data, _ := json.Marshal(param)
 	return data
@@ -133,6 +176,7 @@ func GenerateMessageParam(sessionID string, content string, userName string, isU
 // PublishMessage 发布消息到队列
 func PublishMessage(data []byte) error {
 	mgr := GetCacheManager()
+	markPending(data)
 
 	if mgr.cacheType == CacheTypeRedis {
 		return publishMessageToRedis(data)
@@ -206,16 +250,10 @@ func processMessageDirect(data []byte) error {
 	}
 
 	// 创建消息并存入数据库
-	newMsg := &model.Message{
-		SessionID: param.SessionID,
-		Content:   param.Content,
-		UserName:  param.UserName,
-		IsUser:    param.IsUser,
-	}
-
-	if _, err := message.CreateMessage(newMsg); err != nil {
+	if _, err := message.CreateMessage(param.ToMessage()); err != nil {
 		return err // 数据库错误需要重试
 	}
+	donePending(param.SessionID)
 
 	log.Printf("消息已持久化 [SessionID=%s, User=%s]", param.SessionID, param.UserName)
 	return nil
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// pendingMessageTimeout 发布后超过该时间仍未在本进程写入的消息不再视为待写入
+// Redis 模式下消息可能由其他实例消费，本进程收不到写入完成的通知
+const pendingMessageTimeout = time.Minute
+
+// pendingMessages 各会话已发布但尚未写入数据库的消息数
+type pendingMessages struct {
+	count     int
+	published time.Time // 最近一次发布的时间
+}
+
+var (
+	pending   = make(map[string]*pendingMessages) // 会话ID -> 待写入的消息
+	pendingMu sync.Mutex
+)
+
+// markPending 记录一条待写入的消息
+func markPending(data []byte) {
+	var param struct {
+		SessionID string `json:"session_id"`
+	}
+	if err := json.Unmarshal(data, &param); err != nil || param.SessionID == "" {
+		return
+	}
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	p, ok := pending[param.SessionID]
+	if !ok {
+		p = &pendingMessages{}
+		pending[param.SessionID] = p
+	}
+	p.count++
+	p.published = time.Now()
+}
+
+// donePending 一条消息已写入数据库
+func donePending(sessionID string) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	p, ok := pending[sessionID]
+	if !ok {
+		return
+	}
+	if p.count--; p.count <= 0 {
+		delete(pending, sessionID)
+	}
+}
+
+// HasPendingMessages 会话是否还有已发布但未写入数据库的消息，此时从数据库重新加载会话会缺少这些消息
+func HasPendingMessages(sessionID string) bool {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	p, ok := pending[sessionID]
+	if !ok {
+		return false
+	}
+	if time.Since(p.published) > pendingMessageTimeout {
+		delete(pending, sessionID)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	redisCli "github.com/redis/go-redis/v9"
+)
+
+// 令牌桶限流：桶容量为 burst，每秒补充 rate 个令牌，每次请求消耗一个令牌
+// Redis 模式用 Lua 脚本原子地补充和扣减，多个实例共享同一个桶；BigCache 模式在本进程内限流
+
+// bucketSweepInterval BigCache 模式清理已补满的桶的间隔
+const bucketSweepInterval = time.Minute
+
+// takeTokenScript KEYS[1] 桶的键；ARGV 依次为 rate、burst、当前时间（毫秒）
+// 返回 {是否允许, 需等待的毫秒数}
+var takeTokenScript = redisCli.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil then
+	tokens = burst
+	ts = now
+end
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) * 1000 / rate)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return {allowed, wait}
+`)
+
+// tokenBucket BigCache 模式的本地令牌桶
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Duration // 从空桶补满所需的时间，空闲超过它的桶可以删除
+}
+
+var (
+	buckets   = make(map[string]*tokenBucket)
+	bucketsMu sync.Mutex
+	lastSweep time.Time
+)
+
+// TakeToken 从 key 对应的令牌桶取一个令牌，桶不存在时视为已满
+// 令牌不足时返回 false 和需要等待的时间
+func TakeToken(key string, rate float64, burst int) (bool, time.Duration, error) {
+	if IsRedisEnabled() {
+		now := time.Now().UnixMilli()
+		res, err := takeTokenScript.Run(ctx, rdb, []string{key},
+			strconv.FormatFloat(rate, 'f', -1, 64), burst, now).Int64Slice()
+		if err != nil {
+			return false, 0, err
+		}
+		return res[0] == 1, time.Duration(res[1]) * time.Millisecond, nil
+	}
+
+	now := time.Now()
+	bucketsMu.Lock()
+	defer bucketsMu.Unlock()
+	if now.Sub(lastSweep) >= bucketSweepInterval {
+		sweepBuckets(now)
+		lastSweep = now
+	}
+
+	b, ok := buckets[key]
+	if !ok {
+		b = &tokenBucket{
+			tokens: float64(burst),
+			last:   now,
+			full:   time.Duration(float64(burst) / rate * float64(time.Second)),
+		}
+		buckets[key] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	wait := time.Duration(math.Ceil((1 - b.tokens) / rate * float64(time.Second)))
+	return false, wait, nil
+}
+
+// sweepBuckets 删除已补满的桶（与不存在的桶等价），调用方需持有 bucketsMu
+func sweepBuckets(now time.Time) {
+	for key, b := range buckets {
+		if now.Sub(b.last) >= b.full {
+			delete(buckets, key)
+		}
+	}
+}
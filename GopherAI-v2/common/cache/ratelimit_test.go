@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+// 未初始化 Redis 时使用本进程内的令牌桶
+func TestTakeToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    float64
+		burst   int
+		takes   int           // 连续取令牌的次数
+		elapsed time.Duration // 之后经过的时间
+		want    bool          // 再取一次是否放行
+	}{
+		{name: "within burst", rate: 1, burst: 3, takes: 2, want: true},
+		{name: "burst exhausted", rate: 1, burst: 3, takes: 3, want: false},
+		{name: "burst below one", rate: 1, burst: 1, takes: 1, want: false},
+		{name: "refilled", rate: 1, burst: 3, takes: 3, elapsed: 1100 * time.Millisecond, want: true},
+		{name: "partly refilled", rate: 0.5, burst: 3, takes: 3, elapsed: time.Second, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := "test:" + tt.name
+			t.Cleanup(func() {
+				bucketsMu.Lock()
+				delete(buckets, key)
+				bucketsMu.Unlock()
+			})
+			for i := 0; i < tt.takes; i++ {
+				if ok, _, err := TakeToken(key, tt.rate, tt.burst); err != nil || !ok {
+					t.Fatalf("take %d: allowed=%v err=%v", i+1, ok, err)
+				}
+			}
+			if tt.elapsed > 0 {
+				bucketsMu.Lock()
+				buckets[key].last = buckets[key].last.Add(-tt.elapsed)
+				bucketsMu.Unlock()
+			}
+			ok, wait, err := TakeToken(key, tt.rate, tt.burst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.want {
+				t.Fatalf("allowed=%v, want %v", ok, tt.want)
+			}
+			if ok && wait != 0 {
+				t.Fatalf("allowed with wait %v", wait)
+			}
+			if !ok && (wait <= 0 || wait > time.Duration(float64(time.Second)/tt.rate)) {
+				t.Fatalf("wait %v out of range (0, %v]", wait, time.Duration(float64(time.Second)/tt.rate))
+			}
+		})
+	}
+}
+
+func TestTakeTokenCapped(t *testing.T) {
+	const key = "test:capped"
+	defer func() {
+		bucketsMu.Lock()
+		delete(buckets, key)
+		bucketsMu.Unlock()
+	}()
+	if ok, _, _ := TakeToken(key, 10, 2); !ok {
+		t.Fatal("first take should be allowed")
+	}
+	bucketsMu.Lock()
+	buckets[key].last = buckets[key].last.Add(-time.Hour)
+	bucketsMu.Unlock()
+	// 空闲很久后也只能连续取 burst 个令牌
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := TakeToken(key, 10, 2); !ok {
+			t.Fatalf("take %d should be allowed", i+1)
+		}
+	}
+	if ok, _, _ := TakeToken(key, 10, 2); ok {
+		t.Fatal("take beyond burst should be limited")
+	}
+}
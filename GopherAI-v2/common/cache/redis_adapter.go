@@ -3,7 +3,6 @@ package cache
 import (
 	"GopherAI/config"
 	"GopherAI/dao/message"
-	"GopherAI/model"
 	"context"
 	"encoding/json"
 	"log"
@@ -143,16 +142,10 @@ func processRedisMessage(ctx context.Context, msgID string, values map[string]in
 		return nil
 	}
 
-	newMsg := &model.Message{
-		SessionID: param.SessionID,
-		Content:   param.Content,
-		UserName:  param.UserName,
-		IsUser:    param.IsUser,
-	}
-
-	if _, err := message.CreateMessage(newMsg); err != nil {
+	if _, err := message.CreateMessage(param.ToMessage()); err != nil {
 		return err
 	}
+	donePending(param.SessionID)
 
 	log.Printf("消息已持久化 [SessionID=%s, User=%s]", param.SessionID, param.UserName)
 	return nil
@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// 语义缓存的存储：每个作用域（同一用户、模型、知识库和系统提示词）保存最近的若干条问答，由调用方计算相似度
+// Redis 模式每个作用域是一个 List，最新的条目在最前面，并用一个 Set 记录用户的所有作用域以便整体失效；
+// BigCache 模式单条问答可能超过 BigCache 的条目大小，保存在本进程内
+
+// semanticEntry BigCache 模式的一条问答
+type semanticEntry struct {
+	data    []byte
+	expires time.Time
+}
+
+var (
+	semanticEntries   = make(map[string]map[string][]semanticEntry) // 用户 -> 作用域 -> 问答，最新的在最前面
+	semanticEntriesMu sync.Mutex
+)
+
+func semanticScopeKey(owner string, scope string) string {
+	return "gopherai:semcache:" + owner + ":" + scope
+}
+
+func semanticOwnerKey(owner string) string {
+	return "gopherai:semcache:owner:" + owner
+}
+
+// AddSemanticEntry 在 owner 的作用域 scope 中加入一条问答，作用域最多保留 maxEntries 条
+// 条目在 expiration 后过期；Redis 模式中同一作用域的条目一起过期，调用方需按条目中记录的时间再次判断
+func AddSemanticEntry(owner string, scope string, data []byte, maxEntries int, expiration time.Duration) error {
+	if IsRedisEnabled() {
+		key := semanticScopeKey(owner, scope)
+		pipe := rdb.TxPipeline()
+		pipe.LPush(ctx, key, data)
+		pipe.LTrim(ctx, key, 0, int64(maxEntries-1))
+		pipe.Expire(ctx, key, expiration)
+		pipe.SAdd(ctx, semanticOwnerKey(owner), key)
+		pipe.Expire(ctx, semanticOwnerKey(owner), expiration)
+		_, err := pipe.Exec(ctx)
+		return err
+	}
+
+	now := time.Now()
+	semanticEntriesMu.Lock()
+	defer semanticEntriesMu.Unlock()
+	scopes, ok := semanticEntries[owner]
+	if !ok {
+		scopes = make(map[string][]semanticEntry)
+		semanticEntries[owner] = scopes
+	}
+	entries := []semanticEntry{{data: data, expires: now.Add(expiration)}}
+	for _, e := range scopes[scope] {
+		if len(entries) >= maxEntries {
+			break
+		}
+		if now.Before(e.expires) {
+			entries = append(entries, e)
+		}
+	}
+	scopes[scope] = entries
+	return nil
+}
+
+// SemanticEntries 读取 owner 的作用域 scope 中未过期的问答，最新的在最前面
+func SemanticEntries(owner string, scope string) ([][]byte, error) {
+	if IsRedisEnabled() {
+		values, err := rdb.LRange(ctx, semanticScopeKey(owner, scope), 0, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+		entries := make([][]byte, 0, len(values))
+		for _, v := range values {
+			entries = append(entries, []byte(v))
+		}
+		return entries, nil
+	}
+
+	now := time.Now()
+	semanticEntriesMu.Lock()
+	defer semanticEntriesMu.Unlock()
+	var entries [][]byte
+	for _, e := range semanticEntries[owner][scope] {
+		if now.Before(e.expires) {
+			entries = append(entries, e.data)
+		}
+	}
+	if len(entries) == 0 {
+		// 作用域中的条目已全部过期
+		delete(semanticEntries[owner], scope)
+		if len(semanticEntries[owner]) == 0 {
+			delete(semanticEntries, owner)
+		}
+	}
+	return entries, nil
+}
+
+// InvalidateSemanticEntries 删除 owner 的所有问答，如用户的知识库文档发生变化时
+func InvalidateSemanticEntries(owner string) error {
+	if IsRedisEnabled() {
+		keys, err := rdb.SMembers(ctx, semanticOwnerKey(owner)).Result()
+		if err != nil {
+			return err
+		}
+		return rdb.Del(ctx, append(keys, semanticOwnerKey(owner))...).Err()
+	}
+
+	semanticEntriesMu.Lock()
+	defer semanticEntriesMu.Unlock()
+	delete(semanticEntries, owner)
+	return nil
+}
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/allegro/bigcache/v3"
+	redisCli "github.com/redis/go-redis/v9"
+)
+
+// 流式事件缓冲：一次生成过程中下发给前端的事件按 id（从 1 开始连续递增）保存，断线重连时据此补发
+// Redis 模式每个缓冲是一个 Redis Stream，条目ID为 <id>-0；BigCache 模式每个事件单独一个条目，键为 <key>:<id>，
+// 过期时间固定为 BigCache 的淘汰窗口
+
+// AppendStreamEvent 追加一条事件，调用方需保证同一个 key 的 id 连续递增
+func AppendStreamEvent(key string, id int64, data []byte, expiration time.Duration) error {
+	mgr := GetCacheManager()
+
+	if mgr.cacheType == CacheTypeRedis {
+		pipe := rdb.Pipeline()
+		pipe.XAdd(ctx, &redisCli.XAddArgs{
+			Stream: key,
+			ID:     strconv.FormatInt(id, 10) + "-0",
+			Values: map[string]interface{}{"data": string(data)},
+		})
+		pipe.Expire(ctx, key, expiration)
+		_, err := pipe.Exec(ctx)
+		return err
+	}
+
+	return mgr.bigCache.Set(streamEventKey(key, id), data)
+}
+
+// ReadStreamEvents 按顺序读取 id 大于 afterID 的事件
+func ReadStreamEvents(key string, afterID int64) ([][]byte, error) {
+	mgr := GetCacheManager()
+
+	if mgr.cacheType == CacheTypeRedis {
+		msgs, err := rdb.XRange(ctx, key, strconv.FormatInt(afterID+1, 10)+"-0", "+").Result()
+		if err != nil {
+			return nil, err
+		}
+		events := make([][]byte, 0, len(msgs))
+		for _, msg := range msgs {
+			if data, ok := msg.Values["data"].(string); ok {
+				events = append(events, []byte(data))
+			}
+		}
+		return events, nil
+	}
+
+	var events [][]byte
+	for id := afterID + 1; ; id++ {
+		data, err := mgr.bigCache.Get(streamEventKey(key, id))
+		if errors.Is(err, bigcache.ErrEntryNotFound) {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, data)
+	}
+}
+
+// StreamEventsExist 缓冲是否存在（未过期）
+func StreamEventsExist(key string) (bool, error) {
+	mgr := GetCacheManager()
+
+	if mgr.cacheType == CacheTypeRedis {
+		n, err := rdb.Exists(ctx, key).Result()
+		return n > 0, err
+	}
+
+	// 第一个事件最早写入、最早过期
+	_, err := mgr.bigCache.Get(streamEventKey(key, 1))
+	if errors.Is(err, bigcache.ErrEntryNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func streamEventKey(key string, id int64) string {
+	return key + ":" + strconv.FormatInt(id, 10)
+}
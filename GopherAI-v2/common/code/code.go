@@ -17,14 +17,23 @@ const (
 	CodeInvalidCaptcha   Code = 2008
 	CodeRecordNotFound   Code = 2009
 	CodeIllegalPassword  Code = 2010
+	CodeInvalidOptions   Code = 2011
 
-	CodeForbidden Code = 3001
+	CodeForbidden     Code = 3001
+	CodeQuotaExceeded Code = 3002
 
-	CodeServerBusy Code = 4001
+	CodeServerBusy      Code = 4001
+	CodeSessionBusy     Code = 4002
+	CodeTooManyRequests Code = 4003
 
-	AIModelNotFind    Code = 5001
-	AIModelCannotOpen Code = 5002
-	AIModelFail       Code = 5003
+	AIModelNotFind            Code = 5001
+	AIModelCannotOpen         Code = 5002
+	AIModelFail               Code = 5003
+	AIGenerationStopped       Code = 5004
+	AIStructuredOutputInvalid Code = 5005
+
+	MCPServerUnreachable Code = 5101
+	MCPServerLimit       Code = 5102
 )
 
 var msg = map[Code]string{
@@ -40,14 +49,23 @@ var msg = map[Code]string{
 	CodeInvalidCaptcha:   "验证码错误",
 	CodeRecordNotFound:   "记录不存在",
 	CodeIllegalPassword:  "密码不合法",
+	CodeInvalidOptions:   "生成参数超出模型允许的范围",
+
+	CodeForbidden:     "权限不足",
+	CodeQuotaExceeded: "token用量已达上限，请稍后再试",
 
-	CodeForbidden: "权限不足",
+	CodeServerBusy:      "服务繁忙",
+	CodeSessionBusy:     "会话正在处理上一条消息，请稍后再试",
+	CodeTooManyRequests: "请求过于频繁，请稍后再试",
 
-	CodeServerBusy: "服务繁忙",
+	AIModelNotFind:            "模型不存在",
+	AIModelCannotOpen:         "无法打开模型",
+	AIModelFail:               "模型运行失败",
+	AIGenerationStopped:       "生成已停止",
+	AIStructuredOutputInvalid: "模型回答不符合要求的格式",
 
-	AIModelNotFind:    "模型不存在",
-	AIModelCannotOpen: "无法打开模型",
-	AIModelFail:       "模型运行失败",
+	MCPServerUnreachable: "MCP服务连接失败",
+	MCPServerLimit:       "MCP服务数量已达上限",
 }
 
 func (code Code) Code() int64 {
@@ -0,0 +1,202 @@
+package fixture
+
+import (
+	"GopherAI/config"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// 记录和回放模型服务的 HTTP 流量：record 模式照常请求服务并把请求和响应（流式响应按行保存片段）写入 fixture 文件，
+// replay 模式按请求指纹从 fixture 文件返回响应，不访问网络，用于 RAG、MCP 等流程的回归测试
+// 覆盖对话模型、向量模型和 streamable HTTP 方式的 MCP 服务；stdio 和 SSE 方式的 MCP 服务不经过 HTTP 客户端或带有随机会话地址，不记录
+const (
+	ModeRecord = "record"
+	ModeReplay = "replay"
+)
+
+// ErrFixtureNotFound replay 模式下没有与请求对应的 fixture
+var ErrFixtureNotFound = errors.New("fixture not found")
+
+// exchange 一次请求和响应，保存为一个 fixture 文件
+type exchange struct {
+	Request  recordedRequest  `json:"request"`
+	Response recordedResponse `json:"response"`
+}
+
+type recordedRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Body   string `json:"body"`
+}
+
+type recordedResponse struct {
+	StatusCode  int    `json:"statusCode"`
+	ContentType string `json:"contentType"`
+	// Chunks 响应体按行切分的片段，回放时逐个返回，流式响应因此仍逐段到达
+	Chunks []string `json:"chunks"`
+}
+
+// HTTPClient 按配置返回记录或回放 name 服务流量的 HTTP 客户端，未开启时返回 nil，由调用方使用默认客户端
+func HTTPClient(name string) *http.Client {
+	conf := config.GetConfig().Fixtures
+	switch conf.Mode {
+	case ModeRecord, ModeReplay:
+	case "":
+		return nil
+	default:
+		log.Printf("[fixture] unsupported mode %q, fixtures disabled", conf.Mode)
+		return nil
+	}
+	return &http.Client{Transport: &transport{name: name, mode: conf.Mode, dir: conf.Dir, next: http.DefaultTransport}}
+}
+
+type transport struct {
+	name string
+	mode string
+	dir  string
+	next http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	path := filepath.Join(t.dir, t.name+"-"+fingerprint(req, body)+".json")
+
+	if t.mode == ModeReplay {
+		return t.replay(req, path)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &recordingBody{
+		ReadCloser: resp.Body,
+		ctx:        req.Context(),
+		path:       path,
+		exchange: exchange{
+			Request:  recordedRequest{Method: req.Method, URL: req.URL.Path, Body: string(body)},
+			Response: recordedResponse{StatusCode: resp.StatusCode, ContentType: resp.Header.Get("Content-Type")},
+		},
+	}
+	return resp, nil
+}
+
+// replay 从 fixture 文件构造响应
+func (t *transport) replay(req *http.Request, path string) (*http.Response, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s %s (%s)", ErrFixtureNotFound, req.Method, req.URL.Path, filepath.Base(path))
+	}
+	ex := new(exchange)
+	if err := json.Unmarshal(data, ex); err != nil {
+		return nil, fmt.Errorf("parse fixture %s failed: %w", path, err)
+	}
+	header := make(http.Header)
+	if ex.Response.ContentType != "" {
+		header.Set("Content-Type", ex.Response.ContentType)
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", ex.Response.StatusCode, http.StatusText(ex.Response.StatusCode)),
+		StatusCode: ex.Response.StatusCode,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Body:       &chunkReader{chunks: ex.Response.Chunks},
+		Request:    req,
+	}, nil
+}
+
+// fingerprint 请求的指纹：方法、路径和请求体，JSON 请求体按字段名排序后计算，不含域名和鉴权信息
+func fingerprint(req *http.Request, body []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err == nil {
+		if canonical, err := json.Marshal(v); err == nil {
+			body = canonical
+		}
+	}
+	h := sha256.New()
+	h.Write([]byte(req.Method + " " + req.URL.RequestURI() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// recordingBody 记录读到的响应体，关闭时把已读到的内容写入 fixture 文件，不再读取剩余内容（流式响应可能不会结束）；
+// 请求被取消（如生成被停止、客户端断开）时响应不完整，不保存
+type recordingBody struct {
+	io.ReadCloser
+	ctx      context.Context
+	path     string
+	exchange exchange
+	buf      bytes.Buffer
+	once     sync.Once
+}
+
+func (r *recordingBody) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.buf.Write(p[:n])
+	return n, err
+}
+
+func (r *recordingBody) Close() error {
+	r.once.Do(func() {
+		if r.ctx.Err() != nil {
+			return
+		}
+		r.exchange.Response.Chunks = strings.SplitAfter(r.buf.String(), "\n")
+		if err := save(r.path, &r.exchange); err != nil {
+			log.Printf("[fixture] save %s failed: %v", r.path, err)
+		}
+	})
+	return r.ReadCloser.Close()
+}
+
+func save(path string, ex *exchange) error {
+	data, err := json.MarshalIndent(ex, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// chunkReader 逐个返回记录的响应片段
+type chunkReader struct {
+	chunks []string
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for len(c.chunks) > 0 && c.chunks[0] == "" {
+		c.chunks = c.chunks[1:]
+	}
+	if len(c.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.chunks[0])
+	c.chunks[0] = c.chunks[0][n:]
+	return n, nil
+}
+
+func (c *chunkReader) Close() error { return nil }
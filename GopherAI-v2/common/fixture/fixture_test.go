@@ -0,0 +1,173 @@
+package fixture
+
+import (
+	"GopherAI/config"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useFixtures 在测试期间切换流量记录模式
+func useFixtures(t *testing.T, mode string, dir string) {
+	t.Helper()
+	old := config.GetConfig().Fixtures
+	config.GetConfig().Fixtures = config.FixtureConfig{Mode: mode, Dir: dir}
+	t.Cleanup(func() { config.GetConfig().Fixtures = old })
+}
+
+func post(t *testing.T, client *http.Client, url string, body string) (int, string) {
+	t.Helper()
+	resp, err := client.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("post %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	return resp.StatusCode, string(data)
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		status      int
+		body        string
+	}{
+		{name: "json", contentType: "application/json", status: http.StatusOK, body: `{"answer":"hello"}`},
+		{name: "stream", contentType: "text/event-stream", status: http.StatusOK, body: "data: {\"delta\":\"he\"}\n\ndata: {\"delta\":\"llo\"}\n\ndata: [DONE]\n\n"},
+		{name: "error", contentType: "application/json", status: http.StatusTooManyRequests, body: `{"error":"rate limit"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			calls := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			defer srv.Close()
+
+			useFixtures(t, ModeRecord, dir)
+			status, body := post(t, HTTPClient("test"), srv.URL+"/v1/chat", `{"b":2,"a":1}`)
+			if status != tt.status || body != tt.body {
+				t.Fatalf("record got %d %q, want %d %q", status, body, tt.status, tt.body)
+			}
+
+			useFixtures(t, ModeReplay, dir)
+			// 字段顺序不同的同一请求体命中同一个 fixture
+			resp, err := HTTPClient("test").Post(srv.URL+"/v1/chat", "application/json", strings.NewReader(`{"a":1,"b":2}`))
+			if err != nil {
+				t.Fatalf("replay: %v", err)
+			}
+			data, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != tt.status || string(data) != tt.body {
+				t.Fatalf("replay got %d %q, want %d %q", resp.StatusCode, data, tt.status, tt.body)
+			}
+			if got := resp.Header.Get("Content-Type"); got != tt.contentType {
+				t.Fatalf("replay content type %q, want %q", got, tt.contentType)
+			}
+			if calls != 1 {
+				t.Fatalf("server called %d times, want 1", calls)
+			}
+		})
+	}
+}
+
+func TestReplayNotFound(t *testing.T) {
+	useFixtures(t, ModeReplay, t.TempDir())
+	_, err := HTTPClient("test").Post("http://127.0.0.1:1/v1/chat", "application/json", strings.NewReader(`{}`))
+	if !errors.Is(err, ErrFixtureNotFound) {
+		t.Fatalf("got %v, want ErrFixtureNotFound", err)
+	}
+}
+
+func TestRecordEndlessStream(t *testing.T) {
+	dir := t.TempDir()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		// 服务端不结束响应，直到客户端断开
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	useFixtures(t, ModeRecord, dir)
+	resp, err := HTTPClient("test").Post(srv.URL+"/stream", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	buf := make([]byte, len("data: first\n\n"))
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		resp.Body.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close blocked on an endless stream")
+	}
+
+	useFixtures(t, ModeReplay, dir)
+	status, body := post(t, HTTPClient("test"), srv.URL+"/stream", `{}`)
+	if status != http.StatusOK || body != "data: first\n\n" {
+		t.Fatalf("replay got %d %q", status, body)
+	}
+}
+
+func TestRecordCanceledNotSaved(t *testing.T) {
+	dir := t.TempDir()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "partial")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	useFixtures(t, ModeRecord, dir)
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+"/stream", strings.NewReader(`{}`))
+	resp, err := HTTPClient("test").Do(req)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	cancel()
+	resp.Body.Close()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("saved %d fixtures for a canceled request, want 0", len(entries))
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	for _, mode := range []string{"", "unknown"} {
+		t.Run(fmt.Sprintf("mode=%q", mode), func(t *testing.T) {
+			useFixtures(t, mode, t.TempDir())
+			if c := HTTPClient("test"); c != nil {
+				t.Fatalf("got client for mode %q, want nil", mode)
+			}
+		})
+	}
+}
@@ -65,6 +65,10 @@ func migration() error {
 		new(model.User),
 		new(model.Session),
 		new(model.Message),
+		new(model.Summary),
+		new(model.AssistantProfile),
+		new(model.UserMCPServer),
+		new(model.TokenUsage),
 	)
 }
 
@@ -1,6 +1,7 @@
 package rag
 
 import (
+	"GopherAI/common/fixture"
 	"GopherAI/common/redis"
 	redisPkg "GopherAI/common/redis"
 	"GopherAI/config"
@@ -46,9 +47,10 @@ func NewRAGIndexer(filename, embeddingModel string) (*RAGIndexer, error) {
 	// 可以理解为：找一个“翻译官”，
 	// 专门负责把文本翻译成 AI 能理解的“向量表示”
 	embedConfig := &embeddingArk.EmbeddingConfig{
-		BaseURL: config.GetConfig().RagModelConfig.RagBaseUrl, // 向量模型服务地址
-		APIKey:  apiKey,                                       // 鉴权信息
-		Model:   embeddingModel,                               // 使用哪个向量模型
+		BaseURL:    config.GetConfig().RagModelConfig.RagBaseUrl, // 向量模型服务地址
+		APIKey:     apiKey,                                       // 鉴权信息
+		Model:      embeddingModel,                               // 使用哪个向量模型
+		HTTPClient: fixture.HTTPClient("embedding"),              // 开启时记录或回放请求
 	}
 
 	// 创建向量生成器实例
@@ -164,20 +166,28 @@ func DeleteIndex(ctx context.Context, filename string) error {
 	return nil
 }
 
-// NewRAGQuery 创建 RAG 查询器（用于向量检索和问答）
-func NewRAGQuery(ctx context.Context, username string) (*RAGQuery, error) {
+// NewEmbedder 创建配置中的向量模型，用于检索和语义缓存
+func NewEmbedder(ctx context.Context) (embedding.Embedder, error) {
 	cfg := config.GetConfig()
-	apiKey := os.Getenv("OPENAI_API_KEY")
+	embedder, err := embeddingArk.NewEmbedder(ctx, &embeddingArk.EmbeddingConfig{
+		BaseURL:    cfg.RagModelConfig.RagBaseUrl,
+		APIKey:     os.Getenv("OPENAI_API_KEY"),
+		Model:      cfg.RagModelConfig.RagEmbeddingModel,
+		HTTPClient: fixture.HTTPClient("embedding"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embedder: %w", err)
+	}
+	return embedder, nil
+}
 
+// NewRAGQuery 创建 RAG 查询器（用于向量检索和问答）
+// knowledgeBase 为指定的知识库文件名，为空时使用用户上传的文件
+func NewRAGQuery(ctx context.Context, username string, knowledgeBase string) (*RAGQuery, error) {
 	// 创建 embedding 模型
-	embedConfig := &embeddingArk.EmbeddingConfig{
-		BaseURL: cfg.RagModelConfig.RagBaseUrl,
-		APIKey:  apiKey,
-		Model:   cfg.RagModelConfig.RagEmbeddingModel,
-	}
-	embedder, err := embeddingArk.NewEmbedder(ctx, embedConfig)
+	embedder, err := NewEmbedder(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create embedder: %w", err)
+		return nil, err
 	}
 
 	// 获取用户上传的文件名（假设每个用户只有一个文件）
@@ -190,7 +200,10 @@ func NewRAGQuery(ctx context.Context, username string) (*RAGQuery, error) {
 
 	var filename string
 	for _, f := range files {
-		if !f.IsDir() {
+		if f.IsDir() {
+			continue
+		}
+		if knowledgeBase == "" || f.Name() == knowledgeBase {
 			filename = f.Name()
 			break
 		}
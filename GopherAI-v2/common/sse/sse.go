@@ -0,0 +1,155 @@
+package sse
+
+import (
+	"GopherAI/common/code"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ProtocolVersion 流式接口事件协议的版本，随 session 事件下发，协议不兼容变更时递增
+const ProtocolVersion = 1
+
+// 事件名，每条事件的 data 都是一个 JSON 对象
+// 一次生成的事件以 session 开始，以 done（成功）或 error（失败）结束
+const (
+	EventSession      = "session"                // 会话信息：{"version", "sessionId", "turnId"}
+	EventQueue        = "queue"                  // 排队位置：{"position"}
+	EventDelta        = "delta"                  // 回答片段：{"content"}
+	EventStep         = "step"                   // 模型在调用工具前输出的文字：{"content"}，此前下发的片段属于这一步，不属于最终回答
+	EventToolCall     = "tool_call"              // 模型发起工具调用
+	EventToolResult   = "tool_result"            // 工具调用结果
+	EventToolApproval = "tool_approval_required" // 工具调用等待用户批准
+	EventSources      = "sources"                // 知识库检索到的参考文档
+	EventUsage        = "usage"                  // token 用量
+	EventError        = "error"                  // 出错：{"code", "message"}
+	EventDone         = "done"                   // 生成完成
+)
+
+// keepAliveInterval 没有事件时发送保活注释的间隔，避免代理因空闲断开连接
+const keepAliveInterval = 15 * time.Second
+
+// ErrStreamClosed 流已关闭
+var ErrStreamClosed = errors.New("sse stream closed")
+
+// Event 一条事件，ID 为该事件在所属生成过程中的序号（从 1 开始），断线重连时通过 Last-Event-ID 据此补发
+type Event struct {
+	ID    int64           `json:"id"`
+	Name  string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+	Final bool            `json:"final,omitempty"` // 生成过程的最后一个事件，不下发给前端
+}
+
+// ErrorData error 事件的数据
+type ErrorData struct {
+	Code    code.Code `json:"code"`
+	Message string    `json:"message"`
+	Model   string    `json:"model,omitempty"` // 对比模式中出错的模型
+}
+
+// Stream 以 SSE 格式向前端发送事件，可被多个协程同时使用，空闲时定期发送保活注释
+type Stream struct {
+	mu       sync.Mutex
+	writer   http.ResponseWriter
+	flusher  http.Flusher
+	lastSend time.Time
+	closed   bool
+	stop     chan struct{}
+}
+
+// NewStream 设置 SSE 响应头并创建 Stream，writer 不支持 Flush 时返回 false
+// 使用完毕后需调用 Close
+func NewStream(writer http.ResponseWriter) (*Stream, bool) {
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		return nil, false
+	}
+	header := writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("Access-Control-Allow-Origin", "*")
+	header.Set("X-Accel-Buffering", "no") // 禁止代理缓存
+
+	s := &Stream{
+		writer:   writer,
+		flusher:  flusher,
+		lastSend: time.Now(),
+		stop:     make(chan struct{}),
+	}
+	go s.keepAlive()
+	return s, true
+}
+
+// Write 发送一条事件，ID 为 0 时不带 id 字段（不影响前端记录的 Last-Event-ID）
+// SSE 格式：id: <n>\nevent: <name>\ndata: <json>\n\n
+func (s *Stream) Write(e Event) error {
+	frame := "event: " + e.Name + "\ndata: " + string(e.Data) + "\n\n"
+	if e.ID > 0 {
+		frame = "id: " + strconv.FormatInt(e.ID, 10) + "\n" + frame
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrStreamClosed
+	}
+	return s.write(frame)
+}
+
+// Send 发送一条不带 id 的事件，v 以 JSON 编码（JSON 中的换行会被转义，不会破坏 SSE 格式）
+func (s *Stream) Send(event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.Write(Event{Name: event, Data: data})
+}
+
+// Error 发送 error 事件
+func (s *Stream) Error(c code.Code) error {
+	return s.Send(EventError, ErrorData{Code: c, Message: c.Msg()})
+}
+
+// Close 停止保活，之后的 Send 返回 ErrStreamClosed；返回后不会再写 writer
+func (s *Stream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.stop)
+}
+
+// keepAlive 空闲超过 keepAliveInterval 时发送注释行，前端解析时会忽略
+func (s *Stream) keepAlive() {
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if !s.closed && time.Since(s.lastSend) >= keepAliveInterval {
+				s.write(": keep-alive\n\n")
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// write 写入并 flush，调用方需持有 mu
+func (s *Stream) write(frame string) error {
+	if _, err := s.writer.Write([]byte(frame)); err != nil {
+		log.Println("[SSE] Write error:", err)
+		return err
+	}
+	s.flusher.Flush() //  每次必须 flush
+	s.lastSend = time.Now()
+	return nil
+}
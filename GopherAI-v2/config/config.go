@@ -2,8 +2,10 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 )
 
 type MainConfig struct {
@@ -13,13 +15,13 @@ type MainConfig struct {
 }
 
 type RedisConfig struct {
-	RedisEnabled        bool   `json:"enabled"`         // 是否启用 Redis
-	RedisPort           int    `json:"port"`
-	RedisDb             int    `json:"db"`
-	RedisHost           string `json:"host"`
-	RedisPassword       string `json:"password"`
-	IndexName           string `json:"indexName"`       // Redis 索引名称模板
-	IndexNamePrefix     string `json:"indexNamePrefix"` // Redis 索引名称前缀模板
+	RedisEnabled    bool   `json:"enabled"` // 是否启用 Redis
+	RedisPort       int    `json:"port"`
+	RedisDb         int    `json:"db"`
+	RedisHost       string `json:"host"`
+	RedisPassword   string `json:"password"`
+	IndexName       string `json:"indexName"`       // Redis 索引名称模板
+	IndexNamePrefix string `json:"indexNamePrefix"` // Redis 索引名称前缀模板
 }
 
 type MysqlConfig struct {
@@ -40,18 +42,195 @@ type JwtConfig struct {
 
 type RagModelConfig struct {
 	RagEmbeddingModel string `json:"embeddingModel"`
-	RagChatModelName  string `json:"chatModelName"`
 	RagDocDir         string `json:"docDir"`
 	RagBaseUrl        string `json:"baseUrl"`
 	RagDimension      int    `json:"dimension"`
 }
 
+// ModelCapabilities 模型支持的能力
+type ModelCapabilities struct {
+	Streaming bool `json:"streaming"` // 流式输出
+	Tools     bool `json:"tools"`     // 工具调用
+	Vision    bool `json:"vision"`    // 图片输入
+	// JSONMode 服务商的结构化输出模式："json_schema" 按 JSON Schema 约束，"json_object" 只保证是 JSON，为空时只通过提示词约束
+	JSONMode string `json:"jsonMode"`
+}
+
+// GenerationDefaults 模型的默认生成参数，为空的字段使用服务商的默认值
+type GenerationDefaults struct {
+	Temperature *float32 `json:"temperature"`
+	MaxTokens   *int     `json:"maxTokens"`
+	TopP        *float32 `json:"topP"`
+	Stop        []string `json:"stop"`
+	Seed        *int     `json:"seed"`
+}
+
+// GenerationLimits 模型允许的生成参数取值范围，会话和请求中的参数超出时拒绝请求
+type GenerationLimits struct {
+	MaxTemperature float32 `json:"maxTemperature"` // 温度上限，0 使用默认值 2
+	MaxTokens      int     `json:"maxTokens"`      // 最大输出 token 数上限，0 使用 contextLength
+	MaxStop        int     `json:"maxStop"`        // stop 序列数上限，0 使用默认值 4
+	Seed           bool    `json:"seed"`           // 是否支持 seed
+}
+
+// ModelConfig 模型注册表中的一项，模型工厂据此创建模型
+type ModelConfig struct {
+	ID        string `json:"id"`        // 模型类型，即请求和会话中的 modelType
+	Name      string `json:"name"`      // 展示名称
+	Provider  string `json:"provider"`  // 接入方式：openai（OpenAI 兼容接口）/ ollama / rag / mcp / mock
+	Model     string `json:"model"`     // 服务商的模型名
+	BaseURL   string `json:"baseUrl"`   // 服务地址
+	APIKeyEnv string `json:"apiKeyEnv"` // 存放 API Key 的环境变量名，密钥本身不写入配置文件
+	// ModelEnv、BaseURLEnv 存放模型名和服务地址的环境变量名，环境变量不为空时覆盖 Model 和 BaseURL
+	ModelEnv      string            `json:"modelEnv"`
+	BaseURLEnv    string            `json:"baseUrlEnv"`
+	ContextLength int               `json:"contextLength"` // 上下文长度（token）
+	Capabilities  ModelCapabilities `json:"capabilities"`
+	Fallbacks     []string          `json:"fallbacks"` // 本模型不可用时依次尝试的其他模型类型
+	// MaxToolIterations 一轮对话中模型最多连续调用工具的次数（仅 mcp），0 使用默认值
+	MaxToolIterations int `json:"maxToolIterations"`
+	// SemanticCache 是否对该模型启用语义缓存，相似的问题直接返回之前的回答
+	SemanticCache bool               `json:"semanticCache"`
+	Defaults      GenerationDefaults `json:"defaults"` // 默认生成参数，会话和请求中的参数优先
+	Limits        GenerationLimits   `json:"limits"`   // 生成参数的取值范围
+	Mock          MockConfig         `json:"mock"`     // 模拟模型的行为（仅 mock）
+}
+
+// MockConfig 模拟模型的行为（仅 mock），不调用任何服务，用于没有 API Key 的本地开发和端到端测试
+type MockConfig struct {
+	Mode         string `json:"mode"`         // echo：复述最后一个问题；script：按 Fixture 文件中的规则回答
+	Fixture      string `json:"fixture"`      // script 模式的规则文件路径，相对路径相对于声明该模型的配置文件所在目录
+	LatencyMs    int    `json:"latencyMs"`    // 开始回答前的等待时间（毫秒）
+	TokenDelayMs int    `json:"tokenDelayMs"` // 流式输出每个 token 之间的等待时间（毫秒）
+	// FailFirst 该模型前若干次调用返回 Error，FailEvery 之后每 N 次调用返回一次 Error，用于测试重试和降级
+	FailFirst int    `json:"failFirst"`
+	FailEvery int    `json:"failEvery"`
+	Error     string `json:"error"` // 注入的错误信息，如 "429 too many requests" 会被视为临时性错误而重试
+}
+
+// ResilienceConfig 模型调用的重试与熔断策略
+type ResilienceConfig struct {
+	MaxRetries       int `json:"maxRetries"`       // 临时性错误（429/5xx/超时）的最大重试次数
+	InitialBackoffMs int `json:"initialBackoffMs"` // 第一次重试前的等待时间（毫秒），之后每次翻倍
+	MaxBackoffMs     int `json:"maxBackoffMs"`     // 重试等待时间上限（毫秒）
+	BreakerThreshold int `json:"breakerThreshold"` // 连续失败多少次后熔断该服务，0 表示不熔断
+	BreakerCooldown  int `json:"breakerCooldown"`  // 熔断持续时间（秒），期间直接跳过该服务
+}
+
+// MCPServerConfig 一个MCP服务的接入配置
+type MCPServerConfig struct {
+	Name      string            `json:"name"`      // 服务名，作为工具名前缀区分不同服务的同名工具
+	Transport string            `json:"transport"` // 传输方式：stdio / sse / streamable-http
+	Command   string            `json:"command"`   // stdio：启动服务的命令
+	Args      []string          `json:"args"`      // stdio：命令参数
+	Env       []string          `json:"env"`       // stdio：额外的环境变量，格式为 KEY=VALUE
+	URL       string            `json:"url"`       // sse / streamable-http：服务地址
+	Headers   map[string]string `json:"headers"`   // sse / streamable-http：请求头，如鉴权信息
+	Timeout   int               `json:"timeout"`   // 初始化、获取工具和调用工具的超时时间（秒），0 使用默认值
+	// ToolPolicy 该服务工具的默认调用策略：auto（直接调用）/ ask（流式对话中需用户批准）/ deny（禁止调用），为空时为 auto
+	ToolPolicy string `json:"toolPolicy"`
+	// ToolPolicies 按工具名（不含服务名前缀）单独设置的调用策略，覆盖 ToolPolicy
+	ToolPolicies map[string]string `json:"toolPolicies"`
+}
+
+// UserMCPConfig 用户自行注册的MCP服务
+type UserMCPConfig struct {
+	MaxServers int `json:"maxServers"` // 每个用户最多注册的服务数
+	// EncryptionKeyEnv 存放请求头加密密钥的环境变量名，密钥本身不写入配置文件
+	EncryptionKeyEnv string `json:"encryptionKeyEnv"`
+}
+
+// AIHelperConfig 控制内存中 AIHelper 的数量与淘汰策略
+type AIHelperConfig struct {
+	MaxHelpers    int `json:"maxHelpers"`    // 内存中最多保留的 AIHelper 数量，超出后按 LRU 淘汰
+	IdleTTL       int `json:"idleTTL"`       // 空闲多久（秒）后被淘汰，0 表示不按空闲时间淘汰
+	EvictInterval int `json:"evictInterval"` // 空闲淘汰的检查间隔（秒）
+	// 会话正在处理上一轮时，新的请求是否排队等待；false 时直接返回“会话繁忙”
+	QueueTurns bool `json:"queueTurns"`
+}
+
+// ContextConfig 控制每轮对话发送给模型的上下文
+type ContextConfig struct {
+	Strategy     string `json:"strategy"`     // 上下文策略：all / sliding / keepEnds / summary
+	MaxTokens    int    `json:"maxTokens"`    // 上下文 token 预算（本地估算），0 表示不限制
+	KeepFirst    int    `json:"keepFirst"`    // keepEnds 策略保留最前面的消息数
+	KeepLast     int    `json:"keepLast"`     // keepEnds / summary 策略保留最近的消息数
+	SummaryModel string `json:"summaryModel"` // summary 策略生成摘要所用的模型类型
+}
+
+// SemanticCacheConfig 语义缓存：同一用户在同一模型、知识库和系统提示词下提出相似的问题时复用之前的回答
+type SemanticCacheConfig struct {
+	Threshold  float64 `json:"threshold"`  // 问题向量的余弦相似度达到该值视为命中
+	TTL        int     `json:"ttl"`        // 回答的缓存时间（秒）
+	MaxEntries int     `json:"maxEntries"` // 每个作用域最多缓存的回答数
+}
+
+// StructuredOutputConfig 结构化输出：回答不符合请求的 JSON Schema 时把错误告诉模型让其修复
+type StructuredOutputConfig struct {
+	MaxRepairs int `json:"maxRepairs"` // 最多修复的次数，0 表示不修复
+}
+
+// FixtureConfig 模型服务流量的记录和回放，用于不访问网络的回归测试
+type FixtureConfig struct {
+	Mode string `json:"mode"` // record：请求服务并保存请求和响应；replay：只按请求指纹返回保存的响应；为空时不开启
+	Dir  string `json:"dir"`  // fixture 文件所在目录
+}
+
+// UsageQuotaConfig 每个用户的 token 用量上限，0 表示不限制
+type UsageQuotaConfig struct {
+	DailyTokens   int64 `json:"dailyTokens"`   // 每天（自然日）的用量上限
+	MonthlyTokens int64 `json:"monthlyTokens"` // 每月（自然月）的用量上限
+	// Users 按用户名单独设置的上限，覆盖上面的默认值
+	Users map[string]UserQuota `json:"users"`
+}
+
+// UserQuota 单个用户的用量上限，0 表示不限制
+type UserQuota struct {
+	DailyTokens   int64 `json:"dailyTokens"`
+	MonthlyTokens int64 `json:"monthlyTokens"`
+}
+
+// RateLimit 一个令牌桶：容量 Burst，每分钟补充 PerMinute 个令牌，PerMinute 为 0 表示不限制
+type RateLimit struct {
+	PerMinute float64 `json:"perMinute"`
+	Burst     int     `json:"burst"`
+}
+
+// RateLimitConfig 一个路由组的限流规则，按用户和按 IP 分别计数，两者都需有令牌才放行
+type RateLimitConfig struct {
+	User RateLimit `json:"user"` // 按登录用户限流，未登录的请求不计
+	IP   RateLimit `json:"ip"`   // 按客户端 IP 限流
+}
+
 type Config struct {
 	RedisConfig    RedisConfig    `json:"redisConfig"`
 	MysqlConfig    MysqlConfig    `json:"mysqlConfig"`
 	JwtConfig      JwtConfig      `json:"jwtConfig"`
 	MainConfig     MainConfig     `json:"mainConfig"`
 	RagModelConfig RagModelConfig `json:"ragModelConfig"`
+	AIHelperConfig AIHelperConfig `json:"aiHelperConfig"`
+	// ContextConfig 按模型类型配置上下文策略，"default" 为未单独配置的模型兜底
+	ContextConfig map[string]ContextConfig `json:"contextConfig"`
+	// Models 模型注册表，按顺序展示给前端
+	Models           []ModelConfig    `json:"models"`
+	ResilienceConfig ResilienceConfig `json:"resilienceConfig"`
+	// MCPServers mcp 模型可使用的MCP服务，各服务的工具会汇总后交给模型
+	MCPServers    []MCPServerConfig `json:"mcpServers"`
+	UserMCPConfig UserMCPConfig     `json:"userMcpConfig"`
+	// ToolApprovalTimeout 等待用户批准工具调用的时间（秒），超时视为拒绝
+	ToolApprovalTimeout int `json:"toolApprovalTimeout"`
+	// StreamBufferTTL 流式生成的事件缓冲保留时间（秒），期间断线的前端可重连补发；BigCache 模式固定为其淘汰窗口
+	StreamBufferTTL int `json:"streamBufferTTL"`
+	// UsageQuota 每个用户的 token 用量上限，超出后拒绝新的对话请求
+	UsageQuota UsageQuotaConfig `json:"usageQuota"`
+	// RateLimit 按路由组（user / AI / file）配置的限流规则，未配置的路由组不限流
+	RateLimit map[string]RateLimitConfig `json:"rateLimit"`
+	// SemanticCache 语义缓存的参数，需在模型注册表中对模型单独开启
+	SemanticCache SemanticCacheConfig `json:"semanticCache"`
+	// StructuredOutput 请求要求以 JSON Schema 格式回答时的参数
+	StructuredOutput StructuredOutputConfig `json:"structuredOutput"`
+	// Fixtures 对话模型（OpenAI 兼容接口、Ollama）、向量模型和 streamable HTTP 方式的 MCP 服务的流量记录与回放
+	Fixtures FixtureConfig `json:"fixtures"`
 }
 
 // config 全局配置实例，在 init() 中初始化
@@ -60,19 +239,144 @@ var config = &Config{
 		IndexName:       "rag_docs:%s:idx",
 		IndexNamePrefix: "rag_docs:%s:",
 	},
+	AIHelperConfig: AIHelperConfig{
+		MaxHelpers:    1000,
+		IdleTTL:       1800,
+		EvictInterval: 60,
+		QueueTurns:    true,
+	},
+	ResilienceConfig: ResilienceConfig{
+		MaxRetries:       2,
+		InitialBackoffMs: 500,
+		MaxBackoffMs:     4000,
+		BreakerThreshold: 5,
+		BreakerCooldown:  30,
+	},
+	UserMCPConfig: UserMCPConfig{
+		MaxServers:       10,
+		EncryptionKeyEnv: "MCP_HEADER_KEY",
+	},
+	ToolApprovalTimeout: 120,
+	StreamBufferTTL:     600,
+	SemanticCache: SemanticCacheConfig{
+		Threshold:  0.95,
+		TTL:        86400,
+		MaxEntries: 200,
+	},
+	StructuredOutput: StructuredOutputConfig{
+		MaxRepairs: 2,
+	},
+	Fixtures: FixtureConfig{
+		Dir: "testdata/fixtures",
+	},
+	ContextConfig: map[string]ContextConfig{
+		"default": {
+			Strategy:  "sliding",
+			MaxTokens: 6000,
+		},
+	},
 }
 
+// configFile 配置文件路径
+const configFile = "config/config.json"
+
+// devModelsEnv 指定开发用模型注册表文件（模型配置的 JSON 数组，如本地模拟模型）的环境变量，
+// 设置后其中的模型追加到注册表，未设置时不加载
+const devModelsEnv = "GOPHERAI_DEV_MODELS"
+
 func init() {
-	data, err := os.ReadFile("config/config.json")
+	path := findConfigFile()
+	data, err := os.ReadFile(path)
 	if err != nil {
 		log.Fatal("读取配置文件失败: ", err.Error())
 	}
 	if err := json.Unmarshal(data, config); err != nil {
 		log.Fatal("解析配置文件失败: ", err.Error())
 	}
+	resolveModelPaths(config.Models, filepath.Dir(path))
+
+	if path := os.Getenv(devModelsEnv); path != "" {
+		if err := loadDevModels(path); err != nil {
+			log.Fatal("加载开发用模型失败: ", err.Error())
+		}
+	}
+}
+
+// findConfigFile 从当前目录开始逐级向上查找配置文件，在子目录中运行（如 go test）时也能读到
+func findConfigFile() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return configFile
+	}
+	for {
+		path := filepath.Join(dir, configFile)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return configFile
+		}
+		dir = parent
+	}
+}
+
+// loadDevModels 将开发用模型注册表文件中的模型追加到注册表，模型类型不能与已有模型重复
+func loadDevModels(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var models []ModelConfig
+	if err := json.Unmarshal(data, &models); err != nil {
+		return err
+	}
+	resolveModelPaths(models, filepath.Dir(path))
+	for _, m := range models {
+		if _, ok := config.GetModelConfig(m.ID); ok {
+			return fmt.Errorf("model %q already exists", m.ID)
+		}
+		config.Models = append(config.Models, m)
+	}
+	return nil
+}
+
+// resolveModelPaths 将模型配置中的相对路径转换为相对于配置文件所在目录 dir 的路径
+func resolveModelPaths(models []ModelConfig, dir string) {
+	for i := range models {
+		if f := models[i].Mock.Fixture; f != "" && !filepath.IsAbs(f) {
+			models[i].Mock.Fixture = filepath.Join(dir, f)
+		}
+	}
 }
 
 // GetConfig 获取全局配置
 func GetConfig() *Config {
 	return config
 }
+
+// GetContextConfig 获取指定模型类型的上下文配置，未单独配置时使用 "default"
+func (c *Config) GetContextConfig(modelType string) ContextConfig {
+	if conf, ok := c.ContextConfig[modelType]; ok {
+		return conf
+	}
+	return c.ContextConfig["default"]
+}
+
+// GetModelConfig 获取注册表中指定模型类型的配置
+func (c *Config) GetModelConfig(modelType string) (ModelConfig, bool) {
+	for _, m := range c.Models {
+		if m.ID == modelType {
+			return m, true
+		}
+	}
+	return ModelConfig{}, false
+}
+
+// GetUserQuota 获取指定用户的用量上限，未单独配置时使用默认值
+func (c *Config) GetUserQuota(userName string) UserQuota {
+	if quota, ok := c.UsageQuota.Users[userName]; ok {
+		return quota
+	}
+	return UserQuota{DailyTokens: c.UsageQuota.DailyTokens, MonthlyTokens: c.UsageQuota.MonthlyTokens}
+}
@@ -0,0 +1,132 @@
+package mcpserver
+
+import (
+	"GopherAI/common/code"
+	"GopherAI/controller"
+	"GopherAI/model"
+	"GopherAI/service/mcpserver"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	MCPServerRequest struct {
+		ID        uint              `json:"id,omitempty"`
+		Name      string            `json:"name" binding:"required"` // 服务名，作为工具名前缀
+		Transport string            `json:"transport"`               // sse / streamable-http，默认 streamable-http
+		URL       string            `json:"url" binding:"required"`
+		Headers   map[string]string `json:"headers"` // 请求头（如鉴权信息），修改时不传表示保留原有请求头
+		Timeout   int               `json:"timeout"` // 超时时间（秒），0 使用默认值
+		// ToolPolicy 工具的默认调用策略：auto / ask / deny，ToolPolicies 按工具名单独设置
+		ToolPolicy   string            `json:"toolPolicy"`
+		ToolPolicies map[string]string `json:"toolPolicies"`
+	}
+
+	MCPServerResponse struct {
+		Server *model.UserMCPServer `json:"server,omitempty"`
+		Tools  []string             `json:"tools,omitempty"` // 连通性检查时获取到的工具名
+		controller.Response
+	}
+
+	GetMCPServersResponse struct {
+		Servers []model.UserMCPServer `json:"servers"`
+		controller.Response
+	}
+
+	DeleteMCPServerRequest struct {
+		ID uint `json:"id" binding:"required"`
+	}
+
+	DeleteMCPServerResponse struct {
+		controller.Response
+	}
+)
+
+func (r *MCPServerRequest) toModel() *model.UserMCPServer {
+	return &model.UserMCPServer{
+		ID:           r.ID,
+		Name:         r.Name,
+		Transport:    r.Transport,
+		URL:          r.URL,
+		Timeout:      r.Timeout,
+		ToolPolicy:   r.ToolPolicy,
+		ToolPolicies: r.ToolPolicies,
+	}
+}
+
+func GetMCPServers(c *gin.Context) {
+	res := new(GetMCPServersResponse)
+	userName := c.GetString("userName") // From JWT middleware
+
+	servers, code_ := mcpserver.GetServersByUserName(userName)
+	if code_ != code.CodeSuccess {
+		c.JSON(http.StatusOK, res.CodeOf(code_))
+		return
+	}
+
+	res.Success()
+	res.Servers = servers
+	c.JSON(http.StatusOK, res)
+}
+
+func CreateMCPServer(c *gin.Context) {
+	req := new(MCPServerRequest)
+	res := new(MCPServerResponse)
+	userName := c.GetString("userName") // From JWT middleware
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusOK, res.CodeOf(code.CodeInvalidParams))
+		return
+	}
+
+	created, tools, code_ := mcpserver.CreateServer(c.Request.Context(), userName, req.toModel(), req.Headers)
+	if code_ != code.CodeSuccess {
+		c.JSON(http.StatusOK, res.CodeOf(code_))
+		return
+	}
+
+	res.Success()
+	res.Server = created
+	res.Tools = tools
+	c.JSON(http.StatusOK, res)
+}
+
+func UpdateMCPServer(c *gin.Context) {
+	req := new(MCPServerRequest)
+	res := new(MCPServerResponse)
+	userName := c.GetString("userName") // From JWT middleware
+	if err := c.ShouldBindJSON(req); err != nil || req.ID == 0 {
+		c.JSON(http.StatusOK, res.CodeOf(code.CodeInvalidParams))
+		return
+	}
+
+	updated, tools, code_ := mcpserver.UpdateServer(c.Request.Context(), userName, req.toModel(), req.Headers)
+	if code_ != code.CodeSuccess {
+		c.JSON(http.StatusOK, res.CodeOf(code_))
+		return
+	}
+
+	res.Success()
+	res.Server = updated
+	res.Tools = tools
+	c.JSON(http.StatusOK, res)
+}
+
+func DeleteMCPServer(c *gin.Context) {
+	req := new(DeleteMCPServerRequest)
+	res := new(DeleteMCPServerResponse)
+	userName := c.GetString("userName") // From JWT middleware
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusOK, res.CodeOf(code.CodeInvalidParams))
+		return
+	}
+
+	code_ := mcpserver.DeleteServer(userName, req.ID)
+	if code_ != code.CodeSuccess {
+		c.JSON(http.StatusOK, res.CodeOf(code_))
+		return
+	}
+
+	res.Success()
+	c.JSON(http.StatusOK, res)
+}
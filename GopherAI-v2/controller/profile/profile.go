@@ -0,0 +1,125 @@
+package profile
+
+import (
+	"GopherAI/common/code"
+	"GopherAI/controller"
+	"GopherAI/model"
+	"GopherAI/service/profile"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	ProfileRequest struct {
+		ID               uint                   `json:"id,omitempty"`
+		Name             string                 `json:"name" binding:"required"`
+		SystemPrompt     string                 `json:"systemPrompt"`
+		ModelType        string                 `json:"modelType"`        // 默认模型类型
+		GenerationParams model.GenerationParams `json:"generationParams"` // 生成参数
+		KnowledgeBase    string                 `json:"knowledgeBase"`    // 知识库文件名（可选）
+	}
+
+	ProfileResponse struct {
+		Profile *model.AssistantProfile `json:"profile,omitempty"`
+		controller.Response
+	}
+
+	GetProfilesResponse struct {
+		Profiles []model.AssistantProfile `json:"profiles"`
+		controller.Response
+	}
+
+	DeleteProfileRequest struct {
+		ID uint `json:"id" binding:"required"`
+	}
+
+	DeleteProfileResponse struct {
+		controller.Response
+	}
+)
+
+func (r *ProfileRequest) toModel() *model.AssistantProfile {
+	return &model.AssistantProfile{
+		ID:               r.ID,
+		Name:             r.Name,
+		SystemPrompt:     r.SystemPrompt,
+		ModelType:        r.ModelType,
+		GenerationParams: r.GenerationParams,
+		KnowledgeBase:    r.KnowledgeBase,
+	}
+}
+
+func GetProfiles(c *gin.Context) {
+	res := new(GetProfilesResponse)
+	userName := c.GetString("userName") // From JWT middleware
+
+	profiles, code_ := profile.GetProfilesByUserName(userName)
+	if code_ != code.CodeSuccess {
+		c.JSON(http.StatusOK, res.CodeOf(code_))
+		return
+	}
+
+	res.Success()
+	res.Profiles = profiles
+	c.JSON(http.StatusOK, res)
+}
+
+func CreateProfile(c *gin.Context) {
+	req := new(ProfileRequest)
+	res := new(ProfileResponse)
+	userName := c.GetString("userName") // From JWT middleware
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusOK, res.CodeOf(code.CodeInvalidParams))
+		return
+	}
+
+	created, code_ := profile.CreateProfile(userName, req.toModel())
+	if code_ != code.CodeSuccess {
+		c.JSON(http.StatusOK, res.CodeOf(code_))
+		return
+	}
+
+	res.Success()
+	res.Profile = created
+	c.JSON(http.StatusOK, res)
+}
+
+func UpdateProfile(c *gin.Context) {
+	req := new(ProfileRequest)
+	res := new(ProfileResponse)
+	userName := c.GetString("userName") // From JWT middleware
+	if err := c.ShouldBindJSON(req); err != nil || req.ID == 0 {
+		c.JSON(http.StatusOK, res.CodeOf(code.CodeInvalidParams))
+		return
+	}
+
+	updated, code_ := profile.UpdateProfile(userName, req.toModel())
+	if code_ != code.CodeSuccess {
+		c.JSON(http.StatusOK, res.CodeOf(code_))
+		return
+	}
+
+	res.Success()
+	res.Profile = updated
+	c.JSON(http.StatusOK, res)
+}
+
+func DeleteProfile(c *gin.Context) {
+	req := new(DeleteProfileRequest)
+	res := new(DeleteProfileResponse)
+	userName := c.GetString("userName") // From JWT middleware
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusOK, res.CodeOf(code.CodeInvalidParams))
+		return
+	}
+
+	code_ := profile.DeleteProfile(userName, req.ID)
+	if code_ != code.CodeSuccess {
+		c.JSON(http.StatusOK, res.CodeOf(code_))
+		return
+	}
+
+	res.Success()
+	c.JSON(http.StatusOK, res)
+}
@@ -2,11 +2,13 @@ package session
 
 import (
 	"GopherAI/common/code"
+	"GopherAI/common/sse"
 	"GopherAI/controller"
 	"GopherAI/model"
 	"GopherAI/service/session"
-	"fmt"
+	"encoding/json"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -16,14 +18,26 @@ type (
 		controller.Response
 		Sessions []model.SessionInfo `json:"sessions,omitempty"`
 	}
+	GetModelsResponse struct {
+		controller.Response
+		Models []model.ModelInfo `json:"models"`
+	}
 	CreateSessionAndSendMessageRequest struct {
-		UserQuestion string `json:"question" binding:"required"`  // 用户问题;
-		ModelType    string `json:"modelType" binding:"required"` // 模型类型;
+		UserQuestion string `json:"question" binding:"required"` // 用户问题;
+		ModelType    string `json:"modelType"`                   // 模型类型，使用助手配置时可省略;
+		ProfileID    uint   `json:"profileId,omitempty"`         // 助手配置ID（可选）
+		SystemPrompt string `json:"systemPrompt,omitempty"`      // 系统提示词（可选），优先于助手配置
+		// Options 生成参数（可选），保存为会话的默认参数，优先于助手配置和模型默认值
+		Options *model.GenerationParams `json:"options,omitempty"`
+		// ResponseSchema 回答需符合的 JSON Schema（可选），只支持非流式接口
+		ResponseSchema json.RawMessage `json:"responseSchema,omitempty"`
 	}
 
 	CreateSessionAndSendMessageResponse struct {
-		AiInformation string `json:"Information,omitempty"` // AI回答
-		SessionID     string `json:"sessionId,omitempty"`   // 当前会话ID
+		AiInformation string                  `json:"Information,omitempty"` // AI回答
+		SessionID     string                  `json:"sessionId,omitempty"`   // 当前会话ID
+		Options       *model.GenerationParams `json:"options,omitempty"`     // 实际使用的生成参数
+		Data          json.RawMessage         `json:"data,omitempty"`        // 请求了 ResponseSchema 时为解析后的回答
 		controller.Response
 	}
 
@@ -31,10 +45,25 @@ type (
 		UserQuestion string `json:"question" binding:"required"`            // 用户问题;
 		ModelType    string `json:"modelType" binding:"required"`           // 模型类型;
 		SessionID    string `json:"sessionId,omitempty" binding:"required"` // 当前会话ID
+		// Options 生成参数（可选），只用于本轮对话，优先于会话的参数
+		Options *model.GenerationParams `json:"options,omitempty"`
+		// ResponseSchema 回答需符合的 JSON Schema（可选），只支持非流式接口
+		ResponseSchema json.RawMessage `json:"responseSchema,omitempty"`
 	}
 
 	ChatSendResponse struct {
-		AiInformation string `json:"Information,omitempty"` // AI回答
+		AiInformation string                  `json:"Information,omitempty"` // AI回答
+		Options       *model.GenerationParams `json:"options,omitempty"`     // 实际使用的生成参数
+		Data          json.RawMessage         `json:"data,omitempty"`        // 请求了 ResponseSchema 时为解析后的回答
+		controller.Response
+	}
+
+	SwitchModelRequest struct {
+		SessionID string `json:"sessionId" binding:"required"` // 当前会话ID
+		ModelType string `json:"modelType" binding:"required"` // 切换后的模型类型
+	}
+
+	SwitchModelResponse struct {
 		controller.Response
 	}
 
@@ -45,6 +74,74 @@ type (
 		History []model.History `json:"history"`
 		controller.Response
 	}
+
+	RegenerateRequest struct {
+		SessionID string `json:"sessionId" binding:"required"` // 当前会话ID
+	}
+
+	EditMessageRequest struct {
+		SessionID    string `json:"sessionId" binding:"required"` // 当前会话ID
+		MessageID    string `json:"messageId" binding:"required"` // 被修改的用户问题ID
+		UserQuestion string `json:"question" binding:"required"`  // 修改后的问题
+	}
+
+	SwitchBranchRequest struct {
+		SessionID string `json:"sessionId" binding:"required"` // 当前会话ID
+		MessageID string `json:"messageId" binding:"required"` // 目标分支上的消息ID
+	}
+
+	ForkSessionRequest struct {
+		SessionID string `json:"sessionId" binding:"required"` // 原会话ID
+		MessageID string `json:"messageId" binding:"required"` // 新会话复制到这条消息为止
+	}
+
+	CompareRequest struct {
+		SessionID    string   `json:"sessionId" binding:"required"`  // 当前会话ID
+		UserQuestion string   `json:"question" binding:"required"`   // 用户问题
+		ModelTypes   []string `json:"modelTypes" binding:"required"` // 参与对比的模型类型
+	}
+
+	ResumeStreamRequest struct {
+		SessionID string `json:"sessionId" binding:"required"` // 当前会话ID
+		TurnID    string `json:"turnId,omitempty"`             // session 事件中的生成过程ID，为空时使用会话最近一次的生成过程
+	}
+
+	StopRequest struct {
+		SessionID string `json:"sessionId" binding:"required"` // 当前会话ID
+	}
+
+	StopResponse struct {
+		controller.Response
+	}
+
+	ForkSessionResponse struct {
+		SessionID string `json:"sessionId,omitempty"` // 新会话ID
+		controller.Response
+	}
+
+	SessionMCPServersRequest struct {
+		SessionID string `json:"sessionId" binding:"required"` // 当前会话ID
+	}
+
+	SetSessionMCPServerRequest struct {
+		SessionID string `json:"sessionId" binding:"required"` // 当前会话ID
+		Name      string `json:"name" binding:"required"`      // MCP服务名
+		Enabled   *bool  `json:"enabled" binding:"required"`   // 是否在该会话中启用
+	}
+
+	ToolApprovalRequest struct {
+		ApprovalID string `json:"approvalId" binding:"required"` // tool_approval_required 事件中的审批ID
+		Approved   *bool  `json:"approved" binding:"required"`   // 是否允许调用
+	}
+
+	ToolApprovalResponse struct {
+		controller.Response
+	}
+
+	SessionMCPServersResponse struct {
+		Servers []model.MCPServerInfo `json:"servers"`
+		controller.Response
+	}
 )
 
 func GetUserSessionsByUserName(c *gin.Context) {
@@ -62,16 +159,23 @@ func GetUserSessionsByUserName(c *gin.Context) {
 	c.JSON(http.StatusOK, res)
 }
 
+func GetModels(c *gin.Context) {
+	res := new(GetModelsResponse)
+	res.Success()
+	res.Models = session.GetModels()
+	c.JSON(http.StatusOK, res)
+}
+
 func CreateSessionAndSendMessage(c *gin.Context) {
 	req := new(CreateSessionAndSendMessageRequest)
 	res := new(CreateSessionAndSendMessageResponse)
 	userName := c.GetString("userName") // From JWT middleware
-	if err := c.ShouldBindJSON(req); err != nil {
+	if err := c.ShouldBindJSON(req); err != nil || (req.ModelType == "" && req.ProfileID == 0) {
 		c.JSON(http.StatusOK, res.CodeOf(code.CodeInvalidParams))
 		return
 	}
 	//内部会创建会话并发送消息，并会将AI回答、当前会话返回
-	session_id, aiInformation, code_ := session.CreateSessionAndSendMessage(userName, req.UserQuestion, req.ModelType)
+	session_id, aiInformation, options, code_ := session.CreateSessionAndSendMessage(c.Request.Context(), userName, req.UserQuestion, req.ModelType, req.ProfileID, req.SystemPrompt, req.Options, req.ResponseSchema)
 
 	if code_ != code.CodeSuccess {
 		c.JSON(http.StatusOK, res.CodeOf(code_))
@@ -81,40 +185,39 @@ func CreateSessionAndSendMessage(c *gin.Context) {
 	res.Success()
 	res.AiInformation = aiInformation
 	res.SessionID = session_id
+	res.Options = options
+	if req.ResponseSchema != nil {
+		res.Data = json.RawMessage(aiInformation)
+	}
 	c.JSON(http.StatusOK, res)
 }
 
 func CreateStreamSessionAndSendMessage(c *gin.Context) {
 	req := new(CreateSessionAndSendMessageRequest)
 	userName := c.GetString("userName") // From JWT middleware
-	if err := c.ShouldBindJSON(req); err != nil {
+	// 结构化输出需校验完整的回答，流式接口不支持
+	if err := c.ShouldBindJSON(req); err != nil || (req.ModelType == "" && req.ProfileID == 0) || req.ResponseSchema != nil {
 		c.JSON(http.StatusOK, gin.H{"error": "Invalid parameters"})
 		return
 	}
 
-	// 设置SSE头
-	c.Header("Content-Type", "text/event-stream")
-	c.Header("Cache-Control", "no-cache")
-	c.Header("Connection", "keep-alive")
-	c.Header("Access-Control-Allow-Origin", "*")
-	c.Header("X-Accel-Buffering", "no") // 禁止代理缓存
+	stream, ok := newStream(c)
+	if !ok {
+		return
+	}
+	defer stream.Close()
 
-	// 先创建会话并立即把 sessionId 下发给前端，随后再开始流式输出
-	sessionID, code_ := session.CreateStreamSessionOnly(userName, req.UserQuestion)
+	// 先创建会话，流式输出的第一个 session 事件带有 sessionId，前端据此绑定当前会话，侧边栏即可出现新标签
+	sessionID, code_ := session.CreateStreamSessionOnly(userName, req.UserQuestion, req.ModelType, req.ProfileID, req.SystemPrompt, req.Options)
 	if code_ != code.CodeSuccess {
-		c.SSEvent("error", gin.H{"message": "Failed to create session"})
+		stream.Error(code_)
 		return
 	}
 
-	// 先把 sessionId 通过 data 事件发送给前端，前端据此绑定当前会话，侧边栏即可出现新标签
-	c.Writer.WriteString(fmt.Sprintf("data: {\"sessionId\": \"%s\"}\n\n", sessionID))
-	c.Writer.Flush()
-
-	// 然后开始把本次回答进行流式发送（包含最后的 [DONE]）
-	code_ = session.StreamMessageToExistingSession(userName, sessionID, req.UserQuestion, req.ModelType, http.ResponseWriter(c.Writer))
+	// 生成参数已保存为会话的默认参数
+	code_ = session.StreamMessageToExistingSession(c.Request.Context(), userName, sessionID, req.UserQuestion, req.ModelType, nil, stream)
 	if code_ != code.CodeSuccess {
-		c.SSEvent("error", gin.H{"message": "Failed to send message"})
-		return
+		stream.Error(code_)
 	}
 }
 
@@ -127,7 +230,7 @@ func ChatSend(c *gin.Context) {
 		return
 	}
 	// 发送消息，并会将AI回答返回
-	aiInformation, code_ := session.ChatSend(userName, req.SessionID, req.UserQuestion, req.ModelType)
+	aiInformation, options, code_ := session.ChatSend(c.Request.Context(), userName, req.SessionID, req.UserQuestion, req.ModelType, req.Options, req.ResponseSchema)
 
 	if code_ != code.CodeSuccess {
 		c.JSON(http.StatusOK, res.CodeOf(code_))
@@ -136,31 +239,51 @@ func ChatSend(c *gin.Context) {
 
 	res.Success()
 	res.AiInformation = aiInformation
+	res.Options = options
+	if req.ResponseSchema != nil {
+		res.Data = json.RawMessage(aiInformation)
+	}
 	c.JSON(http.StatusOK, res)
 }
 
 func ChatStreamSend(c *gin.Context) {
 	req := new(ChatSendRequest)
 	userName := c.GetString("userName") // From JWT middleware
-	if err := c.ShouldBindJSON(req); err != nil {
+	// 结构化输出需校验完整的回答，流式接口不支持
+	if err := c.ShouldBindJSON(req); err != nil || req.ResponseSchema != nil {
 		c.JSON(http.StatusOK, gin.H{"error": "Invalid parameters"})
 		return
 	}
 
-	// 设置SSE头
-	c.Header("Content-Type", "text/event-stream")
-	c.Header("Cache-Control", "no-cache")
-	c.Header("Connection", "keep-alive")
-	c.Header("Access-Control-Allow-Origin", "*")
-	c.Header("X-Accel-Buffering", "no") // 禁止代理缓存
+	stream, ok := newStream(c)
+	if !ok {
+		return
+	}
+	defer stream.Close()
 
+	code_ := session.ChatStreamSend(c.Request.Context(), userName, req.SessionID, req.UserQuestion, req.ModelType, req.Options, stream)
+	if code_ != code.CodeSuccess {
+		stream.Error(code_)
+	}
 
-	code_ := session.ChatStreamSend(userName, req.SessionID, req.UserQuestion, req.ModelType, http.ResponseWriter(c.Writer))
+}
+
+func SwitchModel(c *gin.Context) {
+	req := new(SwitchModelRequest)
+	res := new(SwitchModelResponse)
+	userName := c.GetString("userName") // From JWT middleware
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusOK, res.CodeOf(code.CodeInvalidParams))
+		return
+	}
+	code_ := session.SwitchSessionModel(c.Request.Context(), userName, req.SessionID, req.ModelType)
 	if code_ != code.CodeSuccess {
-		c.SSEvent("error", gin.H{"message": "Failed to send message"})
+		c.JSON(http.StatusOK, res.CodeOf(code_))
 		return
 	}
 
+	res.Success()
+	c.JSON(http.StatusOK, res)
 }
 
 func ChatHistory(c *gin.Context) {
@@ -181,3 +304,253 @@ func ChatHistory(c *gin.Context) {
 	res.History = history
 	c.JSON(http.StatusOK, res)
 }
+
+func Regenerate(c *gin.Context) {
+	req := new(RegenerateRequest)
+	res := new(ChatSendResponse)
+	userName := c.GetString("userName") // From JWT middleware
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusOK, res.CodeOf(code.CodeInvalidParams))
+		return
+	}
+	aiInformation, code_ := session.RegenerateReply(c.Request.Context(), userName, req.SessionID)
+	if code_ != code.CodeSuccess {
+		c.JSON(http.StatusOK, res.CodeOf(code_))
+		return
+	}
+
+	res.Success()
+	res.AiInformation = aiInformation
+	c.JSON(http.StatusOK, res)
+}
+
+func RegenerateStream(c *gin.Context) {
+	req := new(RegenerateRequest)
+	userName := c.GetString("userName") // From JWT middleware
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusOK, gin.H{"error": "Invalid parameters"})
+		return
+	}
+
+	stream, ok := newStream(c)
+	if !ok {
+		return
+	}
+	defer stream.Close()
+
+	code_ := session.RegenerateReplyStream(c.Request.Context(), userName, req.SessionID, stream)
+	if code_ != code.CodeSuccess {
+		stream.Error(code_)
+	}
+}
+
+func EditMessage(c *gin.Context) {
+	req := new(EditMessageRequest)
+	res := new(ChatSendResponse)
+	userName := c.GetString("userName") // From JWT middleware
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusOK, res.CodeOf(code.CodeInvalidParams))
+		return
+	}
+	aiInformation, code_ := session.EditMessage(c.Request.Context(), userName, req.SessionID, req.MessageID, req.UserQuestion)
+	if code_ != code.CodeSuccess {
+		c.JSON(http.StatusOK, res.CodeOf(code_))
+		return
+	}
+
+	res.Success()
+	res.AiInformation = aiInformation
+	c.JSON(http.StatusOK, res)
+}
+
+func EditMessageStream(c *gin.Context) {
+	req := new(EditMessageRequest)
+	userName := c.GetString("userName") // From JWT middleware
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusOK, gin.H{"error": "Invalid parameters"})
+		return
+	}
+
+	stream, ok := newStream(c)
+	if !ok {
+		return
+	}
+	defer stream.Close()
+
+	code_ := session.EditMessageStream(c.Request.Context(), userName, req.SessionID, req.MessageID, req.UserQuestion, stream)
+	if code_ != code.CodeSuccess {
+		stream.Error(code_)
+	}
+}
+
+func SwitchBranch(c *gin.Context) {
+	req := new(SwitchBranchRequest)
+	res := new(ChatHistoryResponse)
+	userName := c.GetString("userName") // From JWT middleware
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusOK, res.CodeOf(code.CodeInvalidParams))
+		return
+	}
+	history, code_ := session.SwitchBranch(c.Request.Context(), userName, req.SessionID, req.MessageID)
+	if code_ != code.CodeSuccess {
+		c.JSON(http.StatusOK, res.CodeOf(code_))
+		return
+	}
+
+	res.Success()
+	res.History = history
+	c.JSON(http.StatusOK, res)
+}
+
+func ForkSession(c *gin.Context) {
+	req := new(ForkSessionRequest)
+	res := new(ForkSessionResponse)
+	userName := c.GetString("userName") // From JWT middleware
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusOK, res.CodeOf(code.CodeInvalidParams))
+		return
+	}
+	sessionID, code_ := session.ForkSession(userName, req.SessionID, req.MessageID)
+	if code_ != code.CodeSuccess {
+		c.JSON(http.StatusOK, res.CodeOf(code_))
+		return
+	}
+
+	res.Success()
+	res.SessionID = sessionID
+	c.JSON(http.StatusOK, res)
+}
+
+func StopGeneration(c *gin.Context) {
+	req := new(StopRequest)
+	res := new(StopResponse)
+	userName := c.GetString("userName") // From JWT middleware
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusOK, res.CodeOf(code.CodeInvalidParams))
+		return
+	}
+	code_ := session.StopGeneration(userName, req.SessionID)
+	if code_ != code.CodeSuccess {
+		c.JSON(http.StatusOK, res.CodeOf(code_))
+		return
+	}
+
+	res.Success()
+	c.JSON(http.StatusOK, res)
+}
+
+func CompareStream(c *gin.Context) {
+	req := new(CompareRequest)
+	userName := c.GetString("userName") // From JWT middleware
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusOK, gin.H{"error": "Invalid parameters"})
+		return
+	}
+
+	stream, ok := newStream(c)
+	if !ok {
+		return
+	}
+	defer stream.Close()
+
+	code_ := session.CompareStream(c.Request.Context(), userName, req.SessionID, req.UserQuestion, req.ModelTypes, stream)
+	if code_ != code.CodeSuccess {
+		stream.Error(code_)
+	}
+}
+
+// ResumeStream 断线后重新连接流式生成，请求头 Last-Event-ID 为已收到的最后一个事件的 id
+func ResumeStream(c *gin.Context) {
+	req := new(ResumeStreamRequest)
+	userName := c.GetString("userName") // From JWT middleware
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusOK, gin.H{"error": "Invalid parameters"})
+		return
+	}
+	var lastEventID int64
+	if header := c.GetHeader("Last-Event-ID"); header != "" {
+		id, err := strconv.ParseInt(header, 10, 64)
+		if err != nil || id < 0 {
+			c.JSON(http.StatusOK, gin.H{"error": "Invalid parameters"})
+			return
+		}
+		lastEventID = id
+	}
+
+	stream, ok := newStream(c)
+	if !ok {
+		return
+	}
+	defer stream.Close()
+
+	code_ := session.ResumeStream(c.Request.Context(), userName, req.SessionID, req.TurnID, lastEventID, stream)
+	if code_ != code.CodeSuccess {
+		stream.Error(code_)
+	}
+}
+
+func GetSessionMCPServers(c *gin.Context) {
+	req := new(SessionMCPServersRequest)
+	res := new(SessionMCPServersResponse)
+	userName := c.GetString("userName") // From JWT middleware
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusOK, res.CodeOf(code.CodeInvalidParams))
+		return
+	}
+	servers, code_ := session.GetSessionMCPServers(userName, req.SessionID)
+	if code_ != code.CodeSuccess {
+		c.JSON(http.StatusOK, res.CodeOf(code_))
+		return
+	}
+
+	res.Success()
+	res.Servers = servers
+	c.JSON(http.StatusOK, res)
+}
+
+func SetSessionMCPServer(c *gin.Context) {
+	req := new(SetSessionMCPServerRequest)
+	res := new(SessionMCPServersResponse)
+	userName := c.GetString("userName") // From JWT middleware
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusOK, res.CodeOf(code.CodeInvalidParams))
+		return
+	}
+	servers, code_ := session.SetSessionMCPServer(userName, req.SessionID, req.Name, *req.Enabled)
+	if code_ != code.CodeSuccess {
+		c.JSON(http.StatusOK, res.CodeOf(code_))
+		return
+	}
+
+	res.Success()
+	res.Servers = servers
+	c.JSON(http.StatusOK, res)
+}
+
+func ResolveToolApproval(c *gin.Context) {
+	req := new(ToolApprovalRequest)
+	res := new(ToolApprovalResponse)
+	userName := c.GetString("userName") // From JWT middleware
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusOK, res.CodeOf(code.CodeInvalidParams))
+		return
+	}
+	code_ := session.ResolveToolApproval(userName, req.ApprovalID, *req.Approved)
+	if code_ != code.CodeSuccess {
+		c.JSON(http.StatusOK, res.CodeOf(code_))
+		return
+	}
+
+	res.Success()
+	c.JSON(http.StatusOK, res)
+}
+
+// newStream 创建 SSE 流，响应不支持流式输出时返回错误并返回 false
+func newStream(c *gin.Context) (*sse.Stream, bool) {
+	stream, ok := sse.NewStream(c.Writer)
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{"error": "Streaming unsupported"})
+		return nil, false
+	}
+	return stream, true
+}
@@ -3,6 +3,8 @@ package user
 import (
 	"GopherAI/common/code"
 	"GopherAI/controller"
+	"GopherAI/model"
+	"GopherAI/service/usage"
 	"GopherAI/service/user"
 	"net/http"
 
@@ -31,8 +33,19 @@ type (
 		Token    string `json:"token,omitempty"`
 		Username string `json:"username,omitempty"` // 返回生成的用户名
 	}
+	// 用量查询，Days 为按模型、按天统计的天数（含当天）
+	GetUsageRequest struct {
+		Days int `form:"days" binding:"omitempty,min=1,max=366"`
+	}
+	GetUsageResponse struct {
+		controller.Response
+		Usage *model.UserUsage `json:"usage,omitempty"`
+	}
 )
 
+// defaultUsageDays 未指定天数时统计最近 30 天
+const defaultUsageDays = 30
+
 func Login(c *gin.Context) {
 
 	req := new(LoginRequest)
@@ -74,3 +87,26 @@ func Register(c *gin.Context) {
 	res.Username = username // 返回生成的用户名供用户登录使用
 	c.JSON(http.StatusOK, res)
 }
+
+func GetUsage(c *gin.Context) {
+	req := new(GetUsageRequest)
+	res := new(GetUsageResponse)
+	userName := c.GetString("userName") // From JWT middleware
+	if err := c.ShouldBindQuery(req); err != nil {
+		c.JSON(http.StatusOK, res.CodeOf(code.CodeInvalidParams))
+		return
+	}
+	if req.Days == 0 {
+		req.Days = defaultUsageDays
+	}
+
+	usage_, code_ := usage.GetUserUsage(userName, req.Days)
+	if code_ != code.CodeSuccess {
+		c.JSON(http.StatusOK, res.CodeOf(code_))
+		return
+	}
+
+	res.Success()
+	res.Usage = usage_
+	c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,46 @@
+package mcpserver
+
+import (
+	"GopherAI/common/mysql"
+	"GopherAI/model"
+)
+
+func GetServersByUserName(userName string) ([]model.UserMCPServer, error) {
+	var servers []model.UserMCPServer
+	err := mysql.DB.Where("user_name = ?", userName).Order("id asc").Find(&servers).Error
+	return servers, err
+}
+
+func GetServerByID(id uint) (*model.UserMCPServer, error) {
+	var server model.UserMCPServer
+	err := mysql.DB.Where("id = ?", id).First(&server).Error
+	return &server, err
+}
+
+// CountServersByName 统计用户下同名服务的数量（不含 excludeID）
+func CountServersByName(userName string, name string, excludeID uint) (int64, error) {
+	var count int64
+	err := mysql.DB.Model(&model.UserMCPServer{}).
+		Where("user_name = ? AND name = ? AND id <> ?", userName, name, excludeID).
+		Count(&count).Error
+	return count, err
+}
+
+func CountServersByUserName(userName string) (int64, error) {
+	var count int64
+	err := mysql.DB.Model(&model.UserMCPServer{}).Where("user_name = ?", userName).Count(&count).Error
+	return count, err
+}
+
+func CreateServer(server *model.UserMCPServer) (*model.UserMCPServer, error) {
+	err := mysql.DB.Create(server).Error
+	return server, err
+}
+
+func UpdateServer(server *model.UserMCPServer) error {
+	return mysql.DB.Save(server).Error
+}
+
+func DeleteServer(id uint) error {
+	return mysql.DB.Delete(&model.UserMCPServer{}, id).Error
+}
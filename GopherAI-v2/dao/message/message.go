@@ -7,7 +7,7 @@ import (
 
 func GetMessagesBySessionID(sessionID string) ([]model.Message, error) {
 	var msgs []model.Message
-	err := mysql.DB.Where("session_id = ?", sessionID).Order("created_at asc").Find(&msgs).Error
+	err := mysql.DB.Where("session_id = ?", sessionID).Order("created_at asc, id asc").Find(&msgs).Error
 	return msgs, err
 }
 
@@ -30,3 +30,11 @@ func GetAllMessages() ([]model.Message, error) {
 	err := mysql.DB.Order("created_at asc").Find(&msgs).Error
 	return msgs, err
 }
+
+// UpdateMessageTree 更新消息的ID与父消息ID
+func UpdateMessageTree(id uint, messageID string, parentID string) error {
+	return mysql.DB.Model(&model.Message{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"message_id": messageID,
+		"parent_id":  parentID,
+	}).Error
+}
@@ -0,0 +1,31 @@
+package profile
+
+import (
+	"GopherAI/common/mysql"
+	"GopherAI/model"
+)
+
+func GetProfilesByUserName(userName string) ([]model.AssistantProfile, error) {
+	var profiles []model.AssistantProfile
+	err := mysql.DB.Where("user_name = ?", userName).Order("id asc").Find(&profiles).Error
+	return profiles, err
+}
+
+func GetProfileByID(id uint) (*model.AssistantProfile, error) {
+	var profile model.AssistantProfile
+	err := mysql.DB.Where("id = ?", id).First(&profile).Error
+	return &profile, err
+}
+
+func CreateProfile(profile *model.AssistantProfile) (*model.AssistantProfile, error) {
+	err := mysql.DB.Create(profile).Error
+	return profile, err
+}
+
+func UpdateProfile(profile *model.AssistantProfile) error {
+	return mysql.DB.Save(profile).Error
+}
+
+func DeleteProfile(id uint) error {
+	return mysql.DB.Delete(&model.AssistantProfile{}, id).Error
+}
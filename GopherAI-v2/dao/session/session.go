@@ -3,11 +3,13 @@ package session
 import (
 	"GopherAI/common/mysql"
 	"GopherAI/model"
+
+	"gorm.io/gorm"
 )
 
-func GetSessionsByUserName(UserName int64) ([]model.Session, error) {
+func GetSessionsByUserName(UserName string) ([]model.Session, error) {
 	var sessions []model.Session
-	err := mysql.DB.Where("user_name = ?", UserName).Find(&sessions).Error
+	err := mysql.DB.Where("user_name = ?", UserName).Order("created_at desc").Find(&sessions).Error
 	return sessions, err
 }
 
@@ -16,8 +18,41 @@ func CreateSession(session *model.Session) (*model.Session, error) {
 	return session, err
 }
 
+// CreateSessionWithMessages 在一个事务中创建会话及其消息，任一步失败时都不会留下会话
+func CreateSessionWithMessages(session *model.Session, messages []*model.Message) error {
+	return mysql.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(session).Error; err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+		return tx.Create(messages).Error
+	})
+}
+
 func GetSessionByID(sessionID string) (*model.Session, error) {
 	var session model.Session
 	err := mysql.DB.Where("id = ?", sessionID).First(&session).Error
 	return &session, err
 }
+
+// UpdateSessionModel 更新会话绑定的模型类型与配置
+func UpdateSessionModel(sessionID string, modelType string, modelConfig string) error {
+	return mysql.DB.Model(&model.Session{}).Where("id = ?", sessionID).Updates(map[string]interface{}{
+		"model_type":   modelType,
+		"model_config": modelConfig,
+	}).Error
+}
+
+// UpdateActiveLeaf 更新会话当前分支的叶子消息
+func UpdateActiveLeaf(sessionID string, leafID string) error {
+	return mysql.DB.Model(&model.Session{}).Where("id = ?", sessionID).Update("active_leaf_id", leafID).Error
+}
+
+// UpdateDisabledMCPServers 更新会话中停用的MCP服务
+func UpdateDisabledMCPServers(sessionID string, names []string) error {
+	// 使用结构体更新以便按 json 序列化，Select 保证空列表也会被写入
+	return mysql.DB.Model(&model.Session{}).Where("id = ?", sessionID).Select("disabled_mcp_servers").
+		Updates(&model.Session{DisabledMCPServers: names}).Error
+}
@@ -0,0 +1,17 @@
+package summary
+
+import (
+	"GopherAI/common/mysql"
+	"GopherAI/model"
+)
+
+func GetSummaryBySessionID(sessionID string) (*model.Summary, error) {
+	var summary model.Summary
+	err := mysql.DB.Where("session_id = ?", sessionID).First(&summary).Error
+	return &summary, err
+}
+
+// SaveSummary 保存摘要，已存在时覆盖
+func SaveSummary(summary *model.Summary) error {
+	return mysql.DB.Save(summary).Error
+}
@@ -0,0 +1,53 @@
+package usage
+
+import (
+	"GopherAI/common/mysql"
+	"GopherAI/model"
+	"time"
+)
+
+// usageColumns 汇总查询的用量列
+const usageColumns = "COALESCE(SUM(prompt_tokens), 0) AS prompt_tokens, " +
+	"COALESCE(SUM(completion_tokens), 0) AS completion_tokens, " +
+	"COALESCE(SUM(prompt_tokens + completion_tokens), 0) AS total_tokens"
+
+func CreateUsages(usages []*model.TokenUsage) error {
+	if len(usages) == 0 {
+		return nil
+	}
+	return mysql.DB.Create(&usages).Error
+}
+
+// SumUsage 汇总用户自 since 起的用量
+func SumUsage(userName string, since time.Time) (*model.UsageSummary, error) {
+	var sum model.UsageSummary
+	err := mysql.DB.Model(&model.TokenUsage{}).
+		Select(usageColumns).
+		Where("user_name = ? AND created_at >= ?", userName, since).
+		Scan(&sum).Error
+	return &sum, err
+}
+
+// GetUsageByModel 按模型汇总用户自 since 起的用量
+func GetUsageByModel(userName string, since time.Time) ([]model.ModelUsage, error) {
+	var usages []model.ModelUsage
+	err := mysql.DB.Model(&model.TokenUsage{}).
+		Select("model_type, "+usageColumns).
+		Where("user_name = ? AND created_at >= ?", userName, since).
+		Group("model_type").
+		Order("total_tokens DESC").
+		Scan(&usages).Error
+	return usages, err
+}
+
+// GetUsageByDay 按天汇总用户自 since 起的用量，按日期升序
+func GetUsageByDay(userName string, since time.Time) ([]model.DailyUsage, error) {
+	var usages []model.DailyUsage
+	err := mysql.DB.Model(&model.TokenUsage{}).
+		Select("DATE_FORMAT(created_at, '%Y-%m-%d') AS date, "+usageColumns).
+		Where("user_name = ? AND created_at >= ?", userName, since).
+		Group("date").
+		Order("date").
+		Scan(&usages).Error
+	return usages, err
+}
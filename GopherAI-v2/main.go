@@ -6,7 +6,6 @@ import (
 	"GopherAI/common/mysql"
 	"GopherAI/common/redis"
 	"GopherAI/config"
-	"GopherAI/dao/message"
 	"GopherAI/router"
 	"fmt"
 	"log"
@@ -19,36 +18,6 @@ func StartServer(addr string, port int) error {
 	return r.Run(fmt.Sprintf("%s:%d", addr, port))
 }
 
-// 从数据库加载消息并初始化 AIHelperManager
-func readDataFromDB() error {
-	manager := aihelper.GetGlobalManager()
-	// 从数据库读取所有消息
-	msgs, err := message.GetAllMessages()
-	if err != nil {
-		return err
-	}
-	// 遍历数据库消息
-	for i := range msgs {
-		m := &msgs[i]
-		//默认openai模型
-		modelType := "1"
-		config := make(map[string]interface{})
-
-		// 创建对应的 AIHelper
-		helper, err := manager.GetOrCreateAIHelper(m.UserName, m.SessionID, modelType, config)
-		if err != nil {
-			log.Printf("[readDataFromDB] failed to create helper for user=%s session=%s: %v", m.UserName, m.SessionID, err)
-			continue
-		}
-		log.Println("readDataFromDB init:  ", helper.SessionID)
-		// 添加消息到内存中(不开启存储功能)
-		helper.AddMessage(m.Content, m.UserName, m.IsUser, false)
-	}
-
-	log.Println("AIHelperManager init success ")
-	return nil
-}
-
 func main() {
 	conf := config.GetConfig()
 	host := conf.MainConfig.Host
@@ -60,8 +29,8 @@ func main() {
 		return
 	}
 
-	//初始化AIHelperManager
-	readDataFromDB()
+	//启动AIHelper淘汰协程（会话在首次访问时才从数据库加载）
+	aihelper.GetGlobalManager().StartEvictionLoop()
 
 	//初始化缓存（Redis 或 BigCache）
 	if err := cache.Init(); err != nil {
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"GopherAI/common/cache"
+	"GopherAI/common/code"
+	"GopherAI/config"
+	"GopherAI/controller"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Response 被限流时的响应，RetryAfter 为建议的等待秒数，同时写入 Retry-After 响应头
+type Response struct {
+	controller.Response
+	RetryAfter int `json:"retry_after"`
+}
+
+// Limit 按配置中 group 路由组的规则限流，需放在 jwt.Auth 之后才能按用户限流
+// 缓存出错时放行请求，避免限流组件故障导致服务不可用
+func Limit(group string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rule, ok := config.GetConfig().RateLimit[group]
+		if !ok {
+			c.Next()
+			return
+		}
+
+		if wait, limited := take("gopherai:ratelimit:"+group+":ip:"+c.ClientIP(), rule.IP); limited {
+			reject(c, wait)
+			return
+		}
+		if userName := c.GetString("userName"); userName != "" {
+			if wait, limited := take("gopherai:ratelimit:"+group+":user:"+userName, rule.User); limited {
+				reject(c, wait)
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// take 从令牌桶取一个令牌，令牌不足时返回 true 和需要等待的时间
+func take(key string, limit config.RateLimit) (time.Duration, bool) {
+	if limit.PerMinute <= 0 {
+		return 0, false
+	}
+	burst := limit.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	allowed, wait, err := cache.TakeToken(key, limit.PerMinute/60, burst)
+	if err != nil {
+		log.Println("ratelimit TakeToken error:", err)
+		return 0, false
+	}
+	return wait, !allowed
+}
+
+func reject(c *gin.Context, wait time.Duration) {
+	res := new(Response)
+	res.CodeOf(code.CodeTooManyRequests)
+	res.RetryAfter = int(math.Ceil(wait.Seconds()))
+	if res.RetryAfter < 1 {
+		res.RetryAfter = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(res.RetryAfter))
+	c.AbortWithStatusJSON(http.StatusOK, res)
+}
@@ -0,0 +1,28 @@
+package model
+
+// ModelCapabilities 模型支持的能力
+type ModelCapabilities struct {
+	Streaming bool   `json:"streaming"`
+	Tools     bool   `json:"tools"`
+	Vision    bool   `json:"vision"`
+	JSONMode  string `json:"jsonMode"` // 为空时只通过提示词约束结构化输出
+}
+
+// GenerationLimits 模型允许的生成参数取值范围
+type GenerationLimits struct {
+	MaxTemperature float32 `json:"maxTemperature"`
+	MaxTokens      int     `json:"maxTokens"`
+	MaxStop        int     `json:"maxStop"`
+	Seed           bool    `json:"seed"` // 是否支持 seed
+}
+
+// ModelInfo 模型目录中的一项，供前端展示可选模型（不含服务地址、密钥等配置）
+type ModelInfo struct {
+	ID            string            `json:"id"` // 即请求中的 modelType
+	Name          string            `json:"name"`
+	Provider      string            `json:"provider"`
+	ContextLength int               `json:"contextLength"`
+	Capabilities  ModelCapabilities `json:"capabilities"`
+	Defaults      GenerationParams  `json:"defaults"` // 默认生成参数，为空的字段使用服务商的默认值
+	Limits        GenerationLimits  `json:"limits"`
+}
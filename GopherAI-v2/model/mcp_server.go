@@ -0,0 +1,32 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UserMCPServer 用户自行注册的MCP服务，mcp 模型会在全局配置的服务之外使用其中的工具
+type UserMCPServer struct {
+	ID           uint              `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserName     string            `gorm:"index;not null;type:varchar(50)" json:"username"`
+	Name         string            `gorm:"type:varchar(32);not null" json:"name"`      // 服务名，作为工具名前缀，同一用户下唯一
+	Transport    string            `gorm:"type:varchar(20);not null" json:"transport"` // sse / streamable-http
+	URL          string            `gorm:"type:varchar(255);not null" json:"url"`
+	Headers      string            `gorm:"type:text" json:"-"`                             // 加密后的请求头
+	HeaderNames  []string          `gorm:"type:text;serializer:json" json:"header_names"`  // 请求头名称，请求头的值不返回给前端
+	Timeout      int               `json:"timeout"`                                        // 超时时间（秒），0 使用默认值
+	ToolPolicy   string            `gorm:"type:varchar(10)" json:"tool_policy"`            // 工具的默认调用策略：auto / ask / deny
+	ToolPolicies map[string]string `gorm:"type:text;serializer:json" json:"tool_policies"` // 按工具名单独设置的调用策略
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt    `gorm:"index" json:"-"`
+}
+
+// MCPServerInfo 会话可用的一个MCP服务及其在会话中的启用状态
+type MCPServerInfo struct {
+	Name      string `json:"name"`
+	Transport string `json:"transport"`
+	Global    bool   `json:"global"` // 是否为全局配置的服务，否则为用户注册的服务
+	Enabled   bool   `json:"enabled"`
+}
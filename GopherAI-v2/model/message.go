@@ -4,16 +4,57 @@ import (
 	"time"
 )
 
+// 消息类型
+const (
+	MessageKindUser       = "user"
+	MessageKindAssistant  = "assistant"
+	MessageKindToolCall   = "tool_call"   // 模型请求调用一个工具
+	MessageKindToolResult = "tool_result" // 工具的执行结果，Content 为交给模型的结果文本
+)
+
 type Message struct {
 	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	MessageID string    `gorm:"index;type:varchar(36)" json:"message_id"` // 生成时即确定的唯一ID，异步持久化前即可建立父子关系
+	ParentID  string    `gorm:"index;type:varchar(36)" json:"parent_id"`  // 父消息ID，会话的第一条消息为空
 	SessionID string    `gorm:"index;not null;type:varchar(36)" json:"session_id"`
 	UserName  string    `gorm:"type:varchar(20)" json:"username"`
+	Kind      string    `gorm:"type:varchar(20)" json:"kind"` // 消息类型，旧数据为空，按 IsUser 区分问题和回答
 	Content   string    `gorm:"type:text" json:"content"`
 	IsUser    bool      `gorm:"not null;" json:"is_user"`
+	Stopped   bool      `gorm:"not null;default:false" json:"stopped"` // 回答在生成途中被停止，内容不完整
+	ModelType string    `gorm:"type:varchar(20)" json:"model_type"`    // 实际生成该回答的模型，切换到备用模型时与会话的模型不同
 	CreatedAt time.Time `json:"created_at"`
+
+	// 以下字段仅用于 tool_call / tool_result 消息
+	ToolCallID    string `gorm:"type:varchar(64)" json:"tool_call_id,omitempty"` // 模型给出的调用ID，关联调用与结果
+	ToolName      string `gorm:"type:varchar(100)" json:"tool_name,omitempty"`   // 交给模型的工具名（含服务名前缀）
+	ToolArguments string `gorm:"type:text" json:"tool_arguments,omitempty"`      // 调用参数（JSON）
+	LatencyMs     int64  `json:"latency_ms,omitempty"`                           // 工具执行耗时（毫秒）
+	Error         string `gorm:"type:text" json:"error,omitempty"`               // 工具执行失败或被拒绝的原因
+}
+
+// GetKind 获取消息类型，兼容没有保存类型的旧数据
+func (m *Message) GetKind() string {
+	if m.Kind != "" {
+		return m.Kind
+	}
+	if m.IsUser {
+		return MessageKindUser
+	}
+	return MessageKindAssistant
 }
 
 type History struct {
-	IsUser  bool   `json:"is_user"`
-	Content string `json:"content"`
+	MessageID     string   `json:"message_id"`
+	ParentID      string   `json:"parent_id"`
+	Kind          string   `json:"kind"`
+	IsUser        bool     `json:"is_user"`
+	Content       string   `json:"content"`
+	Stopped       bool     `json:"stopped,omitempty"`
+	ModelType     string   `json:"model_type,omitempty"`
+	ToolName      string   `json:"tool_name,omitempty"`
+	ToolArguments string   `json:"tool_arguments,omitempty"`
+	LatencyMs     int64    `json:"latency_ms,omitempty"`
+	Error         string   `json:"error,omitempty"`
+	Siblings      []string `json:"siblings,omitempty"` // 同一父消息下的所有分支（含自身），按创建顺序排列
 }
@@ -0,0 +1,30 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// GenerationParams 模型生成参数，字段为空时使用模型默认值
+type GenerationParams struct {
+	Temperature *float32 `json:"temperature,omitempty"`
+	MaxTokens   *int     `json:"max_tokens,omitempty"`
+	TopP        *float32 `json:"top_p,omitempty"`
+	Stop        []string `json:"stop,omitempty"` // 遇到这些序列时停止生成
+	Seed        *int     `json:"seed,omitempty"` // 随机种子，相同种子尽量输出相同内容
+}
+
+// AssistantProfile 可复用的助手配置，新会话可以基于它创建
+type AssistantProfile struct {
+	ID               uint             `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserName         string           `gorm:"index;not null;type:varchar(50)" json:"username"`
+	Name             string           `gorm:"type:varchar(50);not null" json:"name"`
+	SystemPrompt     string           `gorm:"type:text" json:"system_prompt"`
+	ModelType        string           `gorm:"type:varchar(20)" json:"model_type"` // 默认模型类型
+	GenerationParams GenerationParams `gorm:"type:text;serializer:json" json:"generation_params"`
+	KnowledgeBase    string           `gorm:"type:varchar(100)" json:"knowledge_base"` // RAG 使用的知识库文件名，为空时使用用户上传的文件
+	CreatedAt        time.Time        `json:"created_at"`
+	UpdatedAt        time.Time        `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt   `gorm:"index" json:"-"`
+}
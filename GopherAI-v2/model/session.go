@@ -7,15 +7,24 @@ import (
 )
 
 type Session struct {
-	ID        string         `gorm:"primaryKey;type:varchar(36)" json:"id"`
-	UserName  string         `gorm:"index;not null" json:"username"`
-	Title     string         `gorm:"type:varchar(100)" json:"title"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	ID               string           `gorm:"primaryKey;type:varchar(36)" json:"id"`
+	UserName         string           `gorm:"index;not null" json:"username"`
+	Title            string           `gorm:"type:varchar(100)" json:"title"`
+	ModelType        string           `gorm:"type:varchar(20)" json:"model_type"` // 会话绑定的模型类型，重新加载时据此恢复
+	ModelConfig      string           `gorm:"type:text" json:"-"`                 // 创建模型所用的配置（JSON 编码）
+	ProfileID        uint             `gorm:"index" json:"profile_id"`            // 创建会话时使用的助手配置，0 表示未使用
+	SystemPrompt     string           `gorm:"type:text" json:"system_prompt"`
+	GenerationParams GenerationParams `gorm:"type:text;serializer:json" json:"generation_params"`
+	ActiveLeafID     string           `gorm:"type:varchar(36)" json:"active_leaf_id"` // 当前分支的最后一条消息
+	// DisabledMCPServers 在该会话中停用的MCP服务名，新注册的服务默认启用
+	DisabledMCPServers []string       `gorm:"type:text;serializer:json" json:"disabled_mcp_servers"`
+	CreatedAt          time.Time      `json:"created_at"`
+	UpdatedAt          time.Time      `json:"updated_at"`
+	DeletedAt          gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 type SessionInfo struct {
 	SessionID string `json:"sessionId"`
 	Title     string `json:"name"`
+	ModelType string `json:"modelType"`
 }
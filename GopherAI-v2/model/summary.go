@@ -0,0 +1,14 @@
+package model
+
+import (
+	"time"
+)
+
+// Summary 会话早期消息的滚动摘要，每个会话最多一条
+type Summary struct {
+	SessionID     string    `gorm:"primaryKey;type:varchar(36)" json:"session_id"`
+	Content       string    `gorm:"type:text" json:"content"`
+	LastMessageID string    `gorm:"type:varchar(36)" json:"last_message_id"` // 摘要覆盖到的最后一条消息（含），不在当前分支上时摘要不生效
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
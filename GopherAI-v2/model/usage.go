@@ -0,0 +1,52 @@
+package model
+
+import (
+	"time"
+)
+
+// TokenUsage 一次对话中某个模型消耗的 token，用于统计和额度控制
+type TokenUsage struct {
+	ID               uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserName         string    `gorm:"index:idx_usage_user_time;type:varchar(50)" json:"username"`
+	SessionID        string    `gorm:"type:varchar(36)" json:"session_id"`
+	MessageID        string    `gorm:"index;type:varchar(36)" json:"message_id"` // 对应的回答消息，上下文摘要等不产生消息的调用为空
+	ModelType        string    `gorm:"type:varchar(20)" json:"model_type"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	Estimated        bool      `gorm:"not null;default:false" json:"estimated"` // 服务商未返回用量，按本地规则估算
+	CreatedAt        time.Time `gorm:"index:idx_usage_user_time" json:"created_at"`
+}
+
+// UsageSummary 一段时间内的 token 用量
+type UsageSummary struct {
+	PromptTokens     int64 `json:"promptTokens"`
+	CompletionTokens int64 `json:"completionTokens"`
+	TotalTokens      int64 `json:"totalTokens"`
+}
+
+// ModelUsage 按模型汇总的用量
+type ModelUsage struct {
+	ModelType string `json:"modelType"`
+	UsageSummary
+}
+
+// DailyUsage 按天汇总的用量
+type DailyUsage struct {
+	Date string `json:"date"` // YYYY-MM-DD
+	UsageSummary
+}
+
+// UsageQuota 用户的用量上限，0 表示不限制
+type UsageQuota struct {
+	DailyTokens   int64 `json:"dailyTokens"`
+	MonthlyTokens int64 `json:"monthlyTokens"`
+}
+
+// UserUsage 用户的用量统计
+type UserUsage struct {
+	Today   UsageSummary `json:"today"`
+	Month   UsageSummary `json:"month"`
+	Quota   UsageQuota   `json:"quota"`
+	ByModel []ModelUsage `json:"byModel"` // 统计区间内按模型汇总
+	ByDay   []DailyUsage `json:"byDay"`   // 统计区间内按天汇总
+}
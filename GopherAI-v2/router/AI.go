@@ -1,22 +1,57 @@
 package router
 
 import (
+	"GopherAI/controller/mcpserver"
+	"GopherAI/controller/profile"
 	"GopherAI/controller/session"
+	"GopherAI/middleware/ratelimit"
 
 	"github.com/gin-gonic/gin"
 )
 
 func AIRouter(r *gin.RouterGroup) {
-
-	// 聊天相关接口
+	// 发起生成的接口会调用模型，按 AI 路由组的规则限流
+	{
+		generate := r.Group("", ratelimit.Limit("AI"))
+		generate.POST("/chat/send-new-session", session.CreateSessionAndSendMessage)
+		generate.POST("/chat/send", session.ChatSend)
+		generate.POST("/chat/send-stream-new-session", session.CreateStreamSessionAndSendMessage)
+		generate.POST("/chat/send-stream", session.ChatStreamSend)
+		generate.POST("/chat/compare-stream", session.CompareStream)
+		generate.POST("/chat/regenerate", session.Regenerate)
+		generate.POST("/chat/regenerate-stream", session.RegenerateStream)
+		generate.POST("/chat/edit", session.EditMessage)
+		generate.POST("/chat/edit-stream", session.EditMessageStream)
+	}
+	// 聊天相关的查询与控制接口不限流，避免被限流时无法续接、停止正在进行的生成
 	{
+		r.GET("/models", session.GetModels)
 		r.GET("/chat/sessions", session.GetUserSessionsByUserName)
-		r.POST("/chat/send-new-session", session.CreateSessionAndSendMessage)
-		r.POST("/chat/send", session.ChatSend)
 		r.POST("/chat/history", session.ChatHistory)
+		r.POST("/chat/switch-model", session.SwitchModel)
+		r.POST("/chat/resume-stream", session.ResumeStream)
+		r.POST("/chat/stop", session.StopGeneration)
+		r.POST("/chat/tool-approval", session.ResolveToolApproval)
+		// 分支相关：切换分支、从某条消息分叉出新会话
+		r.POST("/chat/switch-branch", session.SwitchBranch)
+		r.POST("/chat/fork", session.ForkSession)
+	}
+	// MCP服务相关接口：用户注册的服务，以及各服务在会话中的开关
+	{
+		r.GET("/mcp-servers", mcpserver.GetMCPServers)
+		r.POST("/mcp-server/create", mcpserver.CreateMCPServer)
+		r.POST("/mcp-server/update", mcpserver.UpdateMCPServer)
+		r.POST("/mcp-server/delete", mcpserver.DeleteMCPServer)
+		r.POST("/chat/mcp-servers", session.GetSessionMCPServers)
+		r.POST("/chat/set-mcp-server", session.SetSessionMCPServer)
+	}
 
-		r.POST("/chat/send-stream-new-session", session.CreateStreamSessionAndSendMessage)
-		r.POST("/chat/send-stream", session.ChatStreamSend)
+	// 助手配置相关接口
+	{
+		r.GET("/profiles", profile.GetProfiles)
+		r.POST("/profile/create", profile.CreateProfile)
+		r.POST("/profile/update", profile.UpdateProfile)
+		r.POST("/profile/delete", profile.DeleteProfile)
 	}
 
 }
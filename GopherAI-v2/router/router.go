@@ -2,6 +2,7 @@ package router
 
 import (
 	"GopherAI/middleware/jwt"
+	"GopherAI/middleware/ratelimit"
 
 	"github.com/gin-gonic/gin"
 )
@@ -22,7 +23,7 @@ func InitRouter() *gin.Engine {
 
 	{
 		FileGroup := enterRouter.Group("/file")
-		FileGroup.Use(jwt.Auth())
+		FileGroup.Use(jwt.Auth(), ratelimit.Limit("file"))
 		FileRouter(FileGroup)
 	}
 
@@ -2,13 +2,16 @@ package router
 
 import (
 	"GopherAI/controller/user"
+	"GopherAI/middleware/jwt"
+	"GopherAI/middleware/ratelimit"
 
 	"github.com/gin-gonic/gin"
 )
 
 func RegisterUserRouter(r *gin.RouterGroup) {
 	{
-		r.POST("/register", user.Register)
-		r.POST("/login", user.Login)
+		r.POST("/register", ratelimit.Limit("user"), user.Register)
+		r.POST("/login", ratelimit.Limit("user"), user.Login)
+		r.GET("/usage", jwt.Auth(), user.GetUsage)
 	}
 }
@@ -1,6 +1,7 @@
 package file
 
 import (
+	"GopherAI/common/aihelper"
 	"GopherAI/common/rag"
 	"GopherAI/config"
 	"GopherAI/utils"
@@ -47,6 +48,8 @@ func UploadRagFile(username string, file *multipart.FileHeader) (string, error)
 		log.Printf("Failed to clean user directory %s: %v", userDir, err)
 		return "", err
 	}
+	// 知识库文档变化，之前缓存的回答不再适用
+	aihelper.InvalidateSemanticCache(username)
 
 	// 生成UUID作为唯一文件名
 	uuid := utils.GenerateUUID()
@@ -97,5 +100,7 @@ func UploadRagFile(username string, file *multipart.FileHeader) (string, error)
 	}
 
 	log.Printf("File indexed successfully: %s", filename)
+	// 上传期间可能缓存了没有参考文档的回答
+	aihelper.InvalidateSemanticCache(username)
 	return filePath, nil
 }
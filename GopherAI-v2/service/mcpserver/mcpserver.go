@@ -0,0 +1,202 @@
+package mcpserver
+
+import (
+	"GopherAI/common/aihelper"
+	"GopherAI/common/code"
+	"GopherAI/config"
+	"GopherAI/dao/mcpserver"
+	"GopherAI/model"
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+// maxServerTimeout 用户服务允许配置的最大超时时间（秒）
+const maxServerTimeout = 300
+
+// serverName 服务名会作为工具名前缀交给模型，只允许模型接口支持的字符
+var serverName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
+
+func GetServersByUserName(userName string) ([]model.UserMCPServer, code.Code) {
+	servers, err := mcpserver.GetServersByUserName(userName)
+	if err != nil {
+		log.Println("GetServersByUserName error:", err)
+		return nil, code.CodeServerBusy
+	}
+	return servers, code.CodeSuccess
+}
+
+// GetUserServer 获取属于当前用户的MCP服务
+func GetUserServer(userName string, id uint) (*model.UserMCPServer, code.Code) {
+	s, err := mcpserver.GetServerByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, code.CodeRecordNotFound
+		}
+		log.Println("GetUserServer GetServerByID error:", err)
+		return nil, code.CodeServerBusy
+	}
+	if s.UserName != userName {
+		return nil, code.CodeRecordNotFound
+	}
+	return s, code.CodeSuccess
+}
+
+// CreateServer 注册MCP服务，保存前会连接服务获取工具列表，返回服务提供的工具名
+func CreateServer(ctx context.Context, userName string, s *model.UserMCPServer, headers map[string]string) (*model.UserMCPServer, []string, code.Code) {
+	s.ID = 0
+	s.UserName = userName
+	count, err := mcpserver.CountServersByUserName(userName)
+	if err != nil {
+		log.Println("CreateServer CountServersByUserName error:", err)
+		return nil, nil, code.CodeServerBusy
+	}
+	if max := config.GetConfig().UserMCPConfig.MaxServers; max > 0 && count >= int64(max) {
+		return nil, nil, code.MCPServerLimit
+	}
+	if code_ := validateServer(s); code_ != code.CodeSuccess {
+		return nil, nil, code_
+	}
+	if code_ := setHeaders(s, headers); code_ != code.CodeSuccess {
+		return nil, nil, code_
+	}
+	tools, code_ := testServer(ctx, s)
+	if code_ != code.CodeSuccess {
+		return nil, nil, code_
+	}
+
+	created, err := mcpserver.CreateServer(s)
+	if err != nil {
+		log.Println("CreateServer error:", err)
+		return nil, nil, code.CodeServerBusy
+	}
+	return created, tools, code.CodeSuccess
+}
+
+// UpdateServer 修改MCP服务，headers 为 nil 时保留原有的请求头；保存前同样会检查连通性
+func UpdateServer(ctx context.Context, userName string, s *model.UserMCPServer, headers map[string]string) (*model.UserMCPServer, []string, code.Code) {
+	existing, code_ := GetUserServer(userName, s.ID)
+	if code_ != code.CodeSuccess {
+		return nil, nil, code_
+	}
+
+	existing.Name = s.Name
+	existing.Transport = s.Transport
+	existing.URL = s.URL
+	existing.Timeout = s.Timeout
+	existing.ToolPolicy = s.ToolPolicy
+	existing.ToolPolicies = s.ToolPolicies
+	if code_ := validateServer(existing); code_ != code.CodeSuccess {
+		return nil, nil, code_
+	}
+	if headers != nil {
+		if code_ := setHeaders(existing, headers); code_ != code.CodeSuccess {
+			return nil, nil, code_
+		}
+	}
+	tools, code_ := testServer(ctx, existing)
+	if code_ != code.CodeSuccess {
+		return nil, nil, code_
+	}
+
+	if err := mcpserver.UpdateServer(existing); err != nil {
+		log.Println("UpdateServer error:", err)
+		return nil, nil, code.CodeServerBusy
+	}
+	aihelper.CloseUserMCPServer(existing.ID)
+	return existing, tools, code.CodeSuccess
+}
+
+func DeleteServer(userName string, id uint) code.Code {
+	if _, code_ := GetUserServer(userName, id); code_ != code.CodeSuccess {
+		return code_
+	}
+	if err := mcpserver.DeleteServer(id); err != nil {
+		log.Println("DeleteServer error:", err)
+		return code.CodeServerBusy
+	}
+	aihelper.CloseUserMCPServer(id)
+	return code.CodeSuccess
+}
+
+// validateServer 校验服务名、传输方式、地址、超时时间和工具调用策略
+// 用户服务只允许通过网络接入，不允许 stdio，避免在服务器上执行任意命令
+func validateServer(s *model.UserMCPServer) code.Code {
+	if !serverName.MatchString(s.Name) {
+		return code.CodeInvalidParams
+	}
+	// 不能与全局服务重名，否则工具名前缀和会话中的开关无法区分
+	for _, global := range config.GetConfig().MCPServers {
+		if global.Name == s.Name {
+			return code.CodeInvalidParams
+		}
+	}
+	count, err := mcpserver.CountServersByName(s.UserName, s.Name, s.ID)
+	if err != nil {
+		log.Println("validateServer CountServersByName error:", err)
+		return code.CodeServerBusy
+	}
+	if count > 0 {
+		return code.CodeInvalidParams
+	}
+
+	if s.Transport == "" {
+		s.Transport = aihelper.MCPTransportStreamableHTTP
+	}
+	if s.Transport != aihelper.MCPTransportStreamableHTTP && s.Transport != aihelper.MCPTransportSSE {
+		return code.CodeInvalidParams
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return code.CodeInvalidParams
+	}
+	if s.Timeout < 0 || s.Timeout > maxServerTimeout {
+		return code.CodeInvalidParams
+	}
+	if !aihelper.ValidToolPolicy(s.ToolPolicy) {
+		return code.CodeInvalidParams
+	}
+	for _, policy := range s.ToolPolicies {
+		if !aihelper.ValidToolPolicy(policy) {
+			return code.CodeInvalidParams
+		}
+	}
+	return code.CodeSuccess
+}
+
+// setHeaders 加密保存请求头，并记录请求头名称用于展示
+func setHeaders(s *model.UserMCPServer, headers map[string]string) code.Code {
+	encrypted, err := aihelper.EncryptMCPHeaders(headers)
+	if err != nil {
+		log.Println("setHeaders EncryptMCPHeaders error:", err)
+		return code.CodeServerBusy
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	s.Headers = encrypted
+	s.HeaderNames = names
+	return code.CodeSuccess
+}
+
+// testServer 连接服务并获取工具列表，检查配置是否可用
+func testServer(ctx context.Context, s *model.UserMCPServer) ([]string, code.Code) {
+	conf, err := aihelper.UserMCPServerConfig(s)
+	if err != nil {
+		log.Println("testServer UserMCPServerConfig error:", err)
+		return nil, code.CodeServerBusy
+	}
+	tools, err := aihelper.TestMCPServer(ctx, conf)
+	if err != nil {
+		log.Println("testServer TestMCPServer error:", err)
+		return nil, code.MCPServerUnreachable
+	}
+	return tools, code.CodeSuccess
+}
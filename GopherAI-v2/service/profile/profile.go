@@ -0,0 +1,105 @@
+package profile
+
+import (
+	"GopherAI/common/aihelper"
+	"GopherAI/common/code"
+	"GopherAI/dao/profile"
+	"GopherAI/model"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+
+	"gorm.io/gorm"
+)
+
+func GetProfilesByUserName(userName string) ([]model.AssistantProfile, code.Code) {
+	profiles, err := profile.GetProfilesByUserName(userName)
+	if err != nil {
+		log.Println("GetProfilesByUserName error:", err)
+		return nil, code.CodeServerBusy
+	}
+	return profiles, code.CodeSuccess
+}
+
+// GetUserProfile 获取属于当前用户的助手配置
+func GetUserProfile(userName string, id uint) (*model.AssistantProfile, code.Code) {
+	p, err := profile.GetProfileByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, code.CodeRecordNotFound
+		}
+		log.Println("GetUserProfile GetProfileByID error:", err)
+		return nil, code.CodeServerBusy
+	}
+	if p.UserName != userName {
+		return nil, code.CodeRecordNotFound
+	}
+	return p, code.CodeSuccess
+}
+
+func CreateProfile(userName string, p *model.AssistantProfile) (*model.AssistantProfile, code.Code) {
+	p.ID = 0
+	p.UserName = userName
+	if code_ := validateProfile(p); code_ != code.CodeSuccess {
+		return nil, code_
+	}
+
+	created, err := profile.CreateProfile(p)
+	if err != nil {
+		log.Println("CreateProfile error:", err)
+		return nil, code.CodeServerBusy
+	}
+	return created, code.CodeSuccess
+}
+
+func UpdateProfile(userName string, p *model.AssistantProfile) (*model.AssistantProfile, code.Code) {
+	existing, code_ := GetUserProfile(userName, p.ID)
+	if code_ != code.CodeSuccess {
+		return nil, code_
+	}
+
+	existing.Name = p.Name
+	existing.SystemPrompt = p.SystemPrompt
+	existing.ModelType = p.ModelType
+	existing.GenerationParams = p.GenerationParams
+	existing.KnowledgeBase = p.KnowledgeBase
+	if code_ := validateProfile(existing); code_ != code.CodeSuccess {
+		return nil, code_
+	}
+
+	if err := profile.UpdateProfile(existing); err != nil {
+		log.Println("UpdateProfile error:", err)
+		return nil, code.CodeServerBusy
+	}
+	return existing, code.CodeSuccess
+}
+
+func DeleteProfile(userName string, id uint) code.Code {
+	if _, code_ := GetUserProfile(userName, id); code_ != code.CodeSuccess {
+		return code_
+	}
+	if err := profile.DeleteProfile(id); err != nil {
+		log.Println("DeleteProfile error:", err)
+		return code.CodeServerBusy
+	}
+	return code.CodeSuccess
+}
+
+// validateProfile 校验模型类型已注册、知识库文件存在
+func validateProfile(p *model.AssistantProfile) code.Code {
+	if p.Name == "" {
+		return code.CodeInvalidParams
+	}
+	if p.ModelType != "" && !aihelper.GetGlobalFactory().HasModelType(p.ModelType) {
+		return code.AIModelNotFind
+	}
+	if p.KnowledgeBase != "" {
+		// 只允许引用用户自己目录下的文件
+		p.KnowledgeBase = filepath.Base(p.KnowledgeBase)
+		if _, err := os.Stat(filepath.Join("uploads", p.UserName, p.KnowledgeBase)); err != nil {
+			return code.CodeRecordNotFound
+		}
+	}
+	return code.CodeSuccess
+}
@@ -0,0 +1,245 @@
+package session
+
+import (
+	"GopherAI/common/cache"
+	"GopherAI/common/code"
+	"GopherAI/common/sse"
+	"GopherAI/config"
+	"GopherAI/dao/session"
+	"GopherAI/model"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 每次流式生成作为一个生成过程（run）在后台执行：事件先写入缓存中的事件缓冲，再由请求转发给前端
+// 前端断开不会中断生成，回答照常保存；重连时按 Last-Event-ID 补发缺失的事件，生成未结束时继续实时转发
+
+// defaultStreamBufferTTL 未配置时事件缓冲的保留时间（秒）
+const defaultStreamBufferTTL = 600
+
+// streamPollInterval 生成过程不在本实例执行时，轮询事件缓冲的间隔
+const streamPollInterval = 500 * time.Millisecond
+
+// streamRun 一次正在执行的流式生成
+type streamRun struct {
+	key string // 事件缓冲的键
+
+	mu       sync.Mutex
+	nextID   int64
+	finished bool
+	changed  chan struct{} // 追加事件后关闭并替换，用于唤醒转发
+}
+
+var (
+	runs   = make(map[string]*streamRun) // 事件缓冲的键 -> 本实例正在执行的生成过程
+	runsMu sync.Mutex
+)
+
+func streamRunKey(sessionID string, turnID string) string {
+	return "gopherai:stream:" + sessionID + ":" + turnID
+}
+
+// streamFinalKey 生成过程结束的标记，值为最后一条事件（done 或 error）
+func streamFinalKey(key string) string {
+	return key + ":final"
+}
+
+// latestTurnKey 记录会话最近一次生成过程的 turnID
+func latestTurnKey(sessionID string) string {
+	return "gopherai:stream:latest:" + sessionID
+}
+
+func streamBufferTTL() time.Duration {
+	ttl := config.GetConfig().StreamBufferTTL
+	if ttl <= 0 {
+		ttl = defaultStreamBufferTTL
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// newStreamRun 登记会话的一次生成过程，并记为会话最近的生成过程
+func newStreamRun(sessionID string) (*streamRun, string) {
+	turnID := uuid.New().String()
+	run := &streamRun{
+		key:     streamRunKey(sessionID, turnID),
+		nextID:  1,
+		changed: make(chan struct{}),
+	}
+	runsMu.Lock()
+	runs[run.key] = run
+	runsMu.Unlock()
+
+	if err := cache.Set(latestTurnKey(sessionID), []byte(turnID), streamBufferTTL()); err != nil {
+		log.Println("newStreamRun save latest turn error:", err)
+	}
+	return run, turnID
+}
+
+// emit 追加一条事件
+func (r *streamRun) emit(event string, v interface{}) {
+	r.append(event, v, false)
+}
+
+// finish 追加最后一条事件并写入结束标记，生成过程结束
+func (r *streamRun) finish(event string, v interface{}) {
+	if raw := r.append(event, v, true); raw != nil {
+		if err := cache.Set(streamFinalKey(r.key), raw, streamBufferTTL()); err != nil {
+			log.Println("streamRun save final event error:", err)
+		}
+	}
+	runsMu.Lock()
+	delete(runs, r.key)
+	runsMu.Unlock()
+}
+
+// append 追加一条事件，返回写入的事件，未写入时返回 nil
+func (r *streamRun) append(event string, v interface{}, final bool) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Println("streamRun marshal event error:", err)
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.finished {
+		return nil
+	}
+	r.finished = final
+	raw, _ := json.Marshal(sse.Event{ID: r.nextID, Name: event, Data: data, Final: final})
+	// 写入失败时丢弃该事件，id 不递增，保证缓冲中的 id 连续
+	written := raw
+	if err := cache.AppendStreamEvent(r.key, r.nextID, raw, streamBufferTTL()); err != nil {
+		log.Println("streamRun append event error:", err)
+		written = nil
+	} else {
+		r.nextID++
+	}
+	close(r.changed)
+	r.changed = make(chan struct{})
+	return written
+}
+
+// wait 返回下次追加事件时关闭的 channel
+func (r *streamRun) wait() <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.changed
+}
+
+// runStream 在后台执行 generate 并把事件转发给前端，generate 返回 done 事件的数据或错误码
+// options 为本轮实际使用的生成参数，随 session 事件下发，为空时不下发
+// 生成与请求解绑：前端断开后继续执行直至完成，仍可通过停止接口中止
+func runStream(ctx context.Context, stream *sse.Stream, sessionID string, options *model.GenerationParams, generate func(ctx context.Context, run *streamRun) (doneData, code.Code)) code.Code {
+	run, turnID := newStreamRun(sessionID)
+	run.emit(sse.EventSession, sessionData{Version: sse.ProtocolVersion, SessionID: sessionID, TurnID: turnID, Options: options})
+
+	go func(ctx context.Context) {
+		done, code_ := generate(ctx, run)
+		if code_ != code.CodeSuccess {
+			run.finish(sse.EventError, sse.ErrorData{Code: code_, Message: code_.Msg()})
+			return
+		}
+		run.finish(sse.EventDone, done)
+	}(context.WithoutCancel(ctx))
+
+	return relayStream(ctx, stream, run.key, 0)
+}
+
+// relayStream 把事件缓冲中 id 大于 lastEventID 的事件转发给前端，直到生成结束或前端断开
+func relayStream(ctx context.Context, stream *sse.Stream, key string, lastEventID int64) code.Code {
+	for {
+		runsMu.Lock()
+		run := runs[key]
+		runsMu.Unlock()
+		// 先取得唤醒 channel 再读取，避免漏掉读取期间追加的事件
+		var changed <-chan struct{}
+		var poll <-chan time.Time
+		if run != nil {
+			changed = run.wait()
+		} else {
+			poll = time.After(streamPollInterval)
+		}
+
+		events, err := cache.ReadStreamEvents(key, lastEventID)
+		if err != nil {
+			log.Println("relayStream ReadStreamEvents error:", err)
+			return code.CodeServerBusy
+		}
+		for _, raw := range events {
+			var e sse.Event
+			if err := json.Unmarshal(raw, &e); err != nil {
+				log.Println("relayStream unmarshal event error:", err)
+				return code.CodeServerBusy
+			}
+			if err := stream.Write(e); err != nil {
+				// 前端已断开，生成继续进行
+				return code.CodeSuccess
+			}
+			lastEventID = e.ID
+			if e.Final {
+				return code.CodeSuccess
+			}
+		}
+
+		if run == nil && len(events) == 0 {
+			// 生成过程已结束：最后一条事件已转发过时直接返回，否则补发后返回
+			if raw, err := cache.Get(streamFinalKey(key)); err == nil {
+				var e sse.Event
+				if err := json.Unmarshal(raw, &e); err == nil && e.ID > lastEventID {
+					stream.Write(e)
+				}
+				return code.CodeSuccess
+			}
+			// 生成过程不在本实例执行，缓冲过期后不再等待
+			exist, err := cache.StreamEventsExist(key)
+			if err != nil {
+				log.Println("relayStream StreamEventsExist error:", err)
+				return code.CodeServerBusy
+			}
+			if !exist {
+				return code.CodeRecordNotFound
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return code.CodeSuccess
+		case <-changed:
+		case <-poll:
+		}
+	}
+}
+
+// ResumeStream 重新连接会话的流式生成：补发 id 大于 lastEventID 的事件，生成未结束时继续实时转发
+// turnID 为空时连接会话最近一次的生成过程
+func ResumeStream(ctx context.Context, userName string, sessionID string, turnID string, lastEventID int64, stream *sse.Stream) code.Code {
+	sess, err := session.GetSessionByID(sessionID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return code.CodeRecordNotFound
+		}
+		log.Println("ResumeStream GetSessionByID error:", err)
+		return code.CodeServerBusy
+	}
+	if sess.UserName != userName {
+		return code.CodeRecordNotFound
+	}
+
+	if turnID == "" {
+		latest, err := cache.Get(latestTurnKey(sessionID))
+		if err != nil {
+			// 没有生成记录或已过期
+			return code.CodeRecordNotFound
+		}
+		turnID = string(latest)
+	}
+	return relayStream(ctx, stream, streamRunKey(sessionID, turnID), lastEventID)
+}
@@ -3,140 +3,241 @@ package session
 import (
 	"GopherAI/common/aihelper"
 	"GopherAI/common/code"
+	"GopherAI/common/sse"
+	"GopherAI/config"
+	"GopherAI/dao/mcpserver"
 	"GopherAI/dao/session"
 	"GopherAI/model"
+	"GopherAI/service/profile"
+	"GopherAI/service/usage"
 	"context"
+	"encoding/json"
+	"errors"
 	"log"
-	"net/http"
 
 	"github.com/google/uuid"
 )
 
-var ctx = context.Background()
-
 func GetUserSessionsByUserName(userName string) ([]model.SessionInfo, error) {
-	//获取用户的所有会话ID
-
-	manager := aihelper.GetGlobalManager()
-	Sessions := manager.GetUserSessions(userName)
+	//从数据库获取用户的所有会话（AIHelper 只在访问时才加载，内存中的会话并不完整）
+	Sessions, err := session.GetSessionsByUserName(userName)
+	if err != nil {
+		return nil, err
+	}
 
-	var SessionInfos []model.SessionInfo
+	SessionInfos := make([]model.SessionInfo, 0, len(Sessions))
 
-	for _, session := range Sessions {
+	for _, s := range Sessions {
 		SessionInfos = append(SessionInfos, model.SessionInfo{
-			SessionID: session,
-			Title:     session, // 暂时用sessionID作为标题，后续重构需要的时候可以更改
+			SessionID: s.ID,
+			Title:     s.Title,
+			ModelType: s.ModelType,
 		})
 	}
 
 	return SessionInfos, nil
 }
 
-func CreateSessionAndSendMessage(userName string, userQuestion string, modelType string) (string, string, code.Code) {
-	//1：创建一个新的会话
+// GetModels 获取模型目录
+func GetModels() []model.ModelInfo {
+	models := aihelper.GetGlobalFactory().Models()
+	infos := make([]model.ModelInfo, 0, len(models))
+	for _, m := range models {
+		infos = append(infos, model.ModelInfo{
+			ID:            m.ID,
+			Name:          m.Name,
+			Provider:      m.Provider,
+			ContextLength: m.ContextLength,
+			Capabilities: model.ModelCapabilities{
+				Streaming: m.Capabilities.Streaming,
+				Tools:     m.Capabilities.Tools,
+				Vision:    m.Capabilities.Vision,
+				JSONMode:  m.Capabilities.JSONMode,
+			},
+			Defaults: aihelper.DefaultGenerationParams(m.ID),
+			Limits:   aihelper.GenerationLimits(m.ID),
+		})
+	}
+	return infos
+}
+
+// newModelConfig 创建模型所需的配置，会随会话一起持久化，不要放入密钥等敏感信息
+func newModelConfig(userName string) map[string]interface{} {
+	return map[string]interface{}{
+		"username": userName, // 用于 RAG/MCP 模型获取用户文档（若当前用户选择了RAG模型，该字段将会被用到）
+	}
+}
+
+// createSession 创建会话；指定了助手配置时以其为模板，请求中的模型类型、系统提示词和生成参数优先
+// 生成参数保存为会话的默认参数，并按模型允许的范围校验
+func createSession(userName string, title string, modelType string, profileID uint, systemPrompt string, options *model.GenerationParams) (*model.Session, code.Code) {
+	config := newModelConfig(userName)
 	newSession := &model.Session{
-		ID:       uuid.New().String(),
-		UserName: userName,
-		Title:    userQuestion, // 可以根据需求设置标题，这边暂时用用户第一次的问题作为标题
+		ID:           uuid.New().String(),
+		UserName:     userName,
+		Title:        title,
+		ModelType:    modelType,
+		ProfileID:    profileID,
+		SystemPrompt: systemPrompt,
+	}
+
+	if profileID != 0 {
+		p, code_ := profile.GetUserProfile(userName, profileID)
+		if code_ != code.CodeSuccess {
+			return nil, code_
+		}
+		if newSession.ModelType == "" {
+			newSession.ModelType = p.ModelType
+		}
+		if newSession.SystemPrompt == "" {
+			newSession.SystemPrompt = p.SystemPrompt
+		}
+		newSession.GenerationParams = p.GenerationParams
+		if p.KnowledgeBase != "" {
+			config["knowledgeBase"] = p.KnowledgeBase
+		}
+	}
+	if newSession.ModelType == "" {
+		return nil, code.CodeInvalidParams
 	}
+	if options != nil {
+		newSession.GenerationParams = aihelper.MergeGenerationParams(newSession.GenerationParams, *options)
+	}
+	params := aihelper.MergeGenerationParams(aihelper.DefaultGenerationParams(newSession.ModelType), newSession.GenerationParams)
+	if err := aihelper.ValidateGenerationParams(newSession.ModelType, params); err != nil {
+		log.Println("createSession ValidateGenerationParams error:", err)
+		return nil, code.CodeInvalidOptions
+	}
+	newSession.ModelConfig = aihelper.EncodeModelConfig(config)
+
 	createdSession, err := session.CreateSession(newSession)
 	if err != nil {
-		log.Println("CreateSessionAndSendMessage CreateSession error:", err)
-		return "", "", code.CodeServerBusy
+		log.Println("createSession CreateSession error:", err)
+		return nil, code.CodeServerBusy
 	}
+	return createdSession, code.CodeSuccess
+}
 
-	//2：获取AIHelper并通过其管理消息
-	manager := aihelper.GetGlobalManager()
-	config := map[string]interface{}{
-		"apiKey":   "your-api-key", // TODO: 从配置中获取
-		"username": userName,       // 用于 RAG 模型获取用户文档
+// CreateSessionAndSendMessage 创建会话并生成回答，返回会话ID、回答和实际使用的生成参数
+func CreateSessionAndSendMessage(ctx context.Context, userName string, userQuestion string, modelType string, profileID uint, systemPrompt string, options *model.GenerationParams, responseSchema json.RawMessage) (string, string, *model.GenerationParams, code.Code) {
+	if code_ := usage.CheckQuota(userName); code_ != code.CodeSuccess {
+		return "", "", nil, code_
+	}
+	ctx, code_ := withResponseSchema(ctx, responseSchema)
+	if code_ != code.CodeSuccess {
+		return "", "", nil, code_
 	}
-	helper, err := manager.GetOrCreateAIHelper(userName, createdSession.ID, modelType, config)
+	//1：创建一个新的会话，这边暂时用用户第一次的问题作为标题
+	createdSession, code_ := createSession(userName, userQuestion, modelType, profileID, systemPrompt, options)
+	if code_ != code.CodeSuccess {
+		return "", "", nil, code_
+	}
+
+	//2：获取AIHelper并通过其管理消息（模型配置从会话中恢复）
+	manager := aihelper.GetGlobalManager()
+	helper, err := manager.GetOrCreateAIHelper(userName, createdSession.ID, createdSession.ModelType, newModelConfig(userName))
 	if err != nil {
 		log.Println("CreateSessionAndSendMessage GetOrCreateAIHelper error:", err)
-		return "", "", code.AIModelFail
+		return "", "", nil, code.AIModelFail
 	}
+	defer helper.Unpin()
+	params, _ := helper.GenerationParams("", model.GenerationParams{})
 
 	//3：生成AI回复
+	release, code_ := acquireTurn(ctx, helper, nil)
+	if code_ != code.CodeSuccess {
+		return "", "", nil, code_
+	}
+	defer release()
 	aiResponse, err_ := helper.GenerateResponse(userName, ctx, userQuestion)
 	if err_ != nil {
 		log.Println("CreateSessionAndSendMessage GenerateResponse error:", err_)
-		return "", "", code.AIModelFail
+		return "", "", nil, generateErrorCode(err_)
 	}
 
-	return createdSession.ID, aiResponse.Content, code.CodeSuccess
+	return createdSession.ID, aiResponse.Content, &params, code.CodeSuccess
 }
 
-func CreateStreamSessionOnly(userName string, userQuestion string) (string, code.Code) {
-	newSession := &model.Session{
-		ID:       uuid.New().String(),
-		UserName: userName,
-		Title:    userQuestion,
+func CreateStreamSessionOnly(userName string, userQuestion string, modelType string, profileID uint, systemPrompt string, options *model.GenerationParams) (string, code.Code) {
+	if code_ := usage.CheckQuota(userName); code_ != code.CodeSuccess {
+		return "", code_
 	}
-	createdSession, err := session.CreateSession(newSession)
-	if err != nil {
-		log.Println("CreateStreamSessionOnly CreateSession error:", err)
-		return "", code.CodeServerBusy
+	createdSession, code_ := createSession(userName, userQuestion, modelType, profileID, systemPrompt, options)
+	if code_ != code.CodeSuccess {
+		return "", code_
 	}
 	return createdSession.ID, code.CodeSuccess
 }
 
-func StreamMessageToExistingSession(userName string, sessionID string, userQuestion string, modelType string, writer http.ResponseWriter) code.Code {
-	// 确保 writer 支持 Flush
-	flusher, ok := writer.(http.Flusher)
-	if !ok {
-		log.Println("StreamMessageToExistingSession: streaming unsupported")
-		return code.CodeServerBusy
+// StreamMessageToExistingSession 向已有会话发送问题并流式生成回答，options 为只用于本轮的生成参数（可为空）
+func StreamMessageToExistingSession(ctx context.Context, userName string, sessionID string, userQuestion string, modelType string, options *model.GenerationParams, stream *sse.Stream) code.Code {
+	if code_ := usage.CheckQuota(userName); code_ != code.CodeSuccess {
+		return code_
 	}
-
 	manager := aihelper.GetGlobalManager()
-	config := map[string]interface{}{
-		"apiKey":   "your-api-key", // TODO: 从配置中获取
-		"username": userName,       // 用于 RAG 模型获取用户文档
-	}
-	helper, err := manager.GetOrCreateAIHelper(userName, sessionID, modelType, config)
+	helper, err := manager.GetOrCreateAIHelper(userName, sessionID, modelType, newModelConfig(userName))
 	if err != nil {
 		log.Println("StreamMessageToExistingSession GetOrCreateAIHelper error:", err)
+		if errors.Is(err, aihelper.ErrSessionNotFound) {
+			return code.CodeRecordNotFound
+		}
 		return code.AIModelFail
 	}
-
-	cb := func(msg string) {
-		// 直接发送数据，不转义
-		// SSE 格式：data: <content>\n\n
-		log.Printf("[SSE] Sending chunk: %s (len=%d)\n", msg, len(msg))
-		_, err := writer.Write([]byte("data: " + msg + "\n\n"))
-		if err != nil {
-			log.Println("[SSE] Write error:", err)
-			return
-		}
-		flusher.Flush() //  每次必须 flush
-		log.Println("[SSE] Flushed")
+	ctx, params, code_ := requestOptions(ctx, helper, modelType, options)
+	if code_ != code.CodeSuccess {
+		helper.Unpin()
+		return code_
 	}
 
-	_, err_ := helper.StreamResponse(userName, ctx, cb, userQuestion)
-	if err_ != nil {
-		log.Println("StreamMessageToExistingSession StreamResponse error:", err_)
-		return code.AIModelFail
-	}
+	return streamToWriter(ctx, stream, helper, userName, modelType, params, func(ctx context.Context, cb aihelper.StreamCallback) (*model.Message, error) {
+		return helper.StreamResponse(userName, ctx, cb, userQuestion)
+	})
+}
 
-	_, err = writer.Write([]byte("data: [DONE]\n\n"))
-	if err != nil {
-		log.Println("StreamMessageToExistingSession write DONE error:", err)
-		return code.AIModelFail
-	}
-	flusher.Flush()
+// streamToWriter 在后台获取会话轮次后执行 generate，并把产生的内容以 SSE 事件写给前端
+// options 为本轮实际使用的生成参数，随 session 事件下发，为空时不下发
+// 依次下发 session、排队期间的 queue、生成过程中的 delta / tool_call / tool_result / sources / usage，以 done 或 error 结束
+// 工具调用需要用户批准时下发 tool_approval_required 事件，generate 需使用传入的 ctx 才能收到审批和生成事件
+// modelType 不为空时取得轮次后才切换到该模型，避免关闭其他轮次正在使用的模型
+// 生成在后台进行，结束后才释放对 helper 的固定，调用方不再调用 Unpin
+func streamToWriter(ctx context.Context, stream *sse.Stream, helper *aihelper.AIHelper, userName string, modelType string, options *model.GenerationParams, generate func(ctx context.Context, cb aihelper.StreamCallback) (*model.Message, error)) code.Code {
+	return runStream(ctx, stream, helper.SessionID, options, func(ctx context.Context, run *streamRun) (doneData, code.Code) {
+		defer helper.Unpin()
+		release, code_ := acquireTurn(ctx, helper, sendQueuePosition(run))
+		if code_ != code.CodeSuccess {
+			return doneData{}, code_
+		}
+		defer release()
+		if code_ := switchModel(helper, userName, modelType); code_ != code.CodeSuccess {
+			return doneData{}, code_
+		}
 
-	return code.CodeSuccess
+		cb := func(msg string) {
+			run.emit(sse.EventDelta, deltaData{Content: msg})
+		}
+
+		ctx = aihelper.WithToolApprovalNotifier(ctx, func(approval *aihelper.ToolApproval) {
+			run.emit(sse.EventToolApproval, approval)
+		})
+		ctx = aihelper.WithStreamEvents(ctx, streamEvents(run, ""))
+		reply, err := generate(ctx, cb)
+		if err != nil {
+			log.Println("streamToWriter generate error:", err)
+			return doneData{}, generateErrorCode(err)
+		}
+		return doneData{MessageID: reply.MessageID, Stopped: reply.Stopped}, code.CodeSuccess
+	})
 }
 
-func CreateStreamSessionAndSendMessage(userName string, userQuestion string, modelType string, writer http.ResponseWriter) (string, code.Code) {
+func CreateStreamSessionAndSendMessage(ctx context.Context, userName string, userQuestion string, modelType string, profileID uint, systemPrompt string, options *model.GenerationParams, stream *sse.Stream) (string, code.Code) {
 
-	sessionID, code_ := CreateStreamSessionOnly(userName, userQuestion)
+	sessionID, code_ := CreateStreamSessionOnly(userName, userQuestion, modelType, profileID, systemPrompt, options)
 	if code_ != code.CodeSuccess {
 		return "", code_
 	}
 
-	code_ = StreamMessageToExistingSession(userName, sessionID, userQuestion, modelType, writer)
+	// 生成参数已保存为会话的默认参数
+	code_ = StreamMessageToExistingSession(ctx, userName, sessionID, userQuestion, modelType, nil, stream)
 	if code_ != code.CodeSuccess {
 
 		return sessionID, code_
@@ -145,52 +246,498 @@ func CreateStreamSessionAndSendMessage(userName string, userQuestion string, mod
 	return sessionID, code.CodeSuccess
 }
 
-func ChatSend(userName string, sessionID string, userQuestion string, modelType string) (string, code.Code) {
+// ChatSend 向已有会话发送问题并生成回答，options 为只用于本轮的生成参数（可为空），同时返回实际使用的生成参数
+func ChatSend(ctx context.Context, userName string, sessionID string, userQuestion string, modelType string, options *model.GenerationParams, responseSchema json.RawMessage) (string, *model.GenerationParams, code.Code) {
+	if code_ := usage.CheckQuota(userName); code_ != code.CodeSuccess {
+		return "", nil, code_
+	}
 	//1：获取AIHelper
 	manager := aihelper.GetGlobalManager()
-	config := map[string]interface{}{
-		"username": userName, // 用于 RAG 模型获取用户文档（若当前用户选择了RAG模型，该字段将会被用到）
-	}
-	helper, err := manager.GetOrCreateAIHelper(userName, sessionID, modelType, config)
+	helper, err := manager.GetOrCreateAIHelper(userName, sessionID, modelType, newModelConfig(userName))
 	if err != nil {
 		log.Println("ChatSend GetOrCreateAIHelper error:", err)
-		return "", code.AIModelFail
+		if errors.Is(err, aihelper.ErrSessionNotFound) {
+			return "", nil, code.CodeRecordNotFound
+		}
+		return "", nil, code.AIModelFail
+	}
+	defer helper.Unpin()
+	ctx, params, code_ := requestOptions(ctx, helper, modelType, options)
+	if code_ != code.CodeSuccess {
+		return "", nil, code_
+	}
+	ctx, code_ = withResponseSchema(ctx, responseSchema)
+	if code_ != code.CodeSuccess {
+		return "", nil, code_
 	}
 
-	//2：生成AI回复
+	//2：排队获取轮次后生成AI回复，保证一问一答不与其他请求交错
+	release, code_ := acquireTurn(ctx, helper, nil)
+	if code_ != code.CodeSuccess {
+		return "", nil, code_
+	}
+	defer release()
+	if code_ := switchModel(helper, userName, modelType); code_ != code.CodeSuccess {
+		return "", nil, code_
+	}
 	aiResponse, err_ := helper.GenerateResponse(userName, ctx, userQuestion)
 	if err_ != nil {
 		log.Println("ChatSend GenerateResponse error:", err_)
-		return "", code.AIModelFail
+		return "", nil, generateErrorCode(err_)
 	}
 
-	return aiResponse.Content, code.CodeSuccess
+	return aiResponse.Content, params, code.CodeSuccess
+}
+
+// SwitchSessionModel 切换会话使用的模型，消息历史保留
+func SwitchSessionModel(ctx context.Context, userName string, sessionID string, modelType string) code.Code {
+	helper, code_ := getAIHelper(userName, sessionID)
+	if code_ != code.CodeSuccess {
+		return code_
+	}
+	defer helper.Unpin()
+	// 等待正在进行的轮次结束，避免替换掉正在使用的模型
+	release, code_ := acquireTurn(ctx, helper, nil)
+	if code_ != code.CodeSuccess {
+		return code_
+	}
+	defer release()
+
+	return switchModel(helper, userName, modelType)
+}
+
+// switchModel 把会话切换到 modelType，调用方需持有会话的轮次；modelType 为空或与当前模型相同时不切换
+func switchModel(helper *aihelper.AIHelper, userName string, modelType string) code.Code {
+	if modelType == "" || modelType == helper.GetModelType() {
+		return code.CodeSuccess
+	}
+	if err := aihelper.GetGlobalManager().SwitchModel(helper, modelType, newModelConfig(userName)); err != nil {
+		log.Println("switchModel SwitchModel error:", err)
+		return code.AIModelCannotOpen
+	}
+	return code.CodeSuccess
 }
 
 func GetChatHistory(userName string, sessionID string) ([]model.History, code.Code) {
-	// 获取AIHelper中的消息历史
+	// 获取当前分支的消息历史（AIHelper 未加载时直接读数据库）
 	manager := aihelper.GetGlobalManager()
-	helper, exists := manager.GetAIHelper(userName, sessionID)
-	if !exists {
+	history, err := manager.GetSessionHistory(userName, sessionID)
+	if err != nil {
+		log.Println("GetChatHistory GetSessionHistory error:", err)
+		if errors.Is(err, aihelper.ErrSessionNotFound) {
+			return nil, code.CodeRecordNotFound
+		}
 		return nil, code.CodeServerBusy
 	}
 
-	messages := helper.GetMessages()
-	history := make([]model.History, 0, len(messages))
+	return history, code.CodeSuccess
+}
 
-	// 转换消息为历史格式（根据消息顺序或内容判断用户/AI消息）
-	for i, msg := range messages {
-		isUser := i%2 == 0
-		history = append(history, model.History{
-			IsUser:  isUser,
-			Content: msg.Content,
-		})
+func ChatStreamSend(ctx context.Context, userName string, sessionID string, userQuestion string, modelType string, options *model.GenerationParams, stream *sse.Stream) code.Code {
+
+	return StreamMessageToExistingSession(ctx, userName, sessionID, userQuestion, modelType, options, stream)
+}
+
+// maxCompareModels 对比模式最多同时使用的模型数
+const maxCompareModels = 4
+
+// CompareStream 用多个模型同时回答同一个问题，并把各模型的回答通过同一个 SSE 连接交替下发，事件中带有所属的模型
+// 每个回答都保存为该问题下的一个分支，之后可通过切换分支选择其中一个继续对话
+// 某个模型失败时下发带 model 的 error 事件，成功时下发带 model 的 done 事件，全部结束后下发不带 model 的 done 事件
+func CompareStream(ctx context.Context, userName string, sessionID string, userQuestion string, modelTypes []string, stream *sse.Stream) code.Code {
+	if code_ := usage.CheckQuota(userName); code_ != code.CodeSuccess {
+		return code_
+	}
+	modelTypes, code_ := checkCompareModels(modelTypes)
+	if code_ != code.CodeSuccess {
+		return code_
+	}
+	helper, code_ := getAIHelper(userName, sessionID)
+	if code_ != code.CodeSuccess {
+		return code_
 	}
 
-	return history, code.CodeSuccess
+	return runStream(ctx, stream, helper.SessionID, nil, func(ctx context.Context, run *streamRun) (doneData, code.Code) {
+		defer helper.Unpin()
+		release, code_ := acquireTurn(ctx, helper, sendQueuePosition(run))
+		if code_ != code.CodeSuccess {
+			return doneData{}, code_
+		}
+		defer release()
+
+		results, err := helper.Compare(userName, ctx, func(modelType string, msg string) {
+			run.emit(sse.EventDelta, deltaData{Model: modelType, Content: msg})
+		}, func(modelType string) *aihelper.StreamEvents {
+			return streamEvents(run, modelType)
+		}, userQuestion, modelTypes)
+
+		for _, r := range results {
+			if r.Err != nil {
+				log.Printf("CompareStream model %s error: %v", r.ModelType, r.Err)
+				c := generateErrorCode(r.Err)
+				run.emit(sse.EventError, sse.ErrorData{Code: c, Message: c.Msg(), Model: r.ModelType})
+				continue
+			}
+			run.emit(sse.EventDone, doneData{Model: r.ModelType, MessageID: r.Message.MessageID, Stopped: r.Message.Stopped})
+		}
+		if err != nil {
+			return doneData{}, generateErrorCode(err)
+		}
+		return doneData{}, code.CodeSuccess
+	})
+}
+
+// checkCompareModels 校验对比模式的模型列表并去重
+func checkCompareModels(modelTypes []string) ([]string, code.Code) {
+	factory := aihelper.GetGlobalFactory()
+	seen := make(map[string]bool, len(modelTypes))
+	out := make([]string, 0, len(modelTypes))
+	for _, modelType := range modelTypes {
+		if seen[modelType] {
+			continue
+		}
+		if !factory.HasModelType(modelType) {
+			return nil, code.AIModelNotFind
+		}
+		seen[modelType] = true
+		out = append(out, modelType)
+	}
+	if len(out) == 0 || len(out) > maxCompareModels {
+		return nil, code.CodeInvalidParams
+	}
+	return out, code.CodeSuccess
+}
+
+// getAIHelper 获取会话的AIHelper，沿用会话当前的模型，使用完后需调用 Unpin
+func getAIHelper(userName string, sessionID string) (*aihelper.AIHelper, code.Code) {
+	manager := aihelper.GetGlobalManager()
+	helper, err := manager.GetOrCreateAIHelper(userName, sessionID, "", newModelConfig(userName))
+	if err != nil {
+		log.Println("getAIHelper GetOrCreateAIHelper error:", err)
+		if errors.Is(err, aihelper.ErrSessionNotFound) {
+			return nil, code.CodeRecordNotFound
+		}
+		return nil, code.AIModelFail
+	}
+	return helper, code.CodeSuccess
+}
+
+// requestOptions 按本轮使用的模型 modelType（为空时为会话当前的模型）校验本轮请求的生成参数，返回带有这些参数的 ctx 和本轮实际使用的生成参数
+func requestOptions(ctx context.Context, helper *aihelper.AIHelper, modelType string, options *model.GenerationParams) (context.Context, *model.GenerationParams, code.Code) {
+	var override model.GenerationParams
+	if options != nil {
+		override = *options
+	}
+	params, err := helper.GenerationParams(modelType, override)
+	if err != nil {
+		log.Println("requestOptions GenerationParams error:", err)
+		return ctx, nil, code.CodeInvalidOptions
+	}
+	if options != nil {
+		ctx = aihelper.WithGenerationParams(ctx, override)
+	}
+	return ctx, &params, code.CodeSuccess
+}
+
+// withResponseSchema 解析请求要求的 JSON Schema 并放入 ctx，未要求时原样返回 ctx
+func withResponseSchema(ctx context.Context, raw json.RawMessage) (context.Context, code.Code) {
+	if raw == nil {
+		return ctx, code.CodeSuccess
+	}
+	s, err := aihelper.ParseResponseSchema(raw)
+	if err != nil {
+		log.Println("withResponseSchema ParseResponseSchema error:", err)
+		return ctx, code.CodeInvalidParams
+	}
+	return aihelper.WithResponseSchema(ctx, s), code.CodeSuccess
+}
+
+// acquireTurn 获取会话的轮次，onPosition 不为空时报告排队位置
+func acquireTurn(ctx context.Context, helper *aihelper.AIHelper, onPosition func(int)) (func(), code.Code) {
+	release, err := helper.AcquireTurn(ctx, onPosition)
+	if err != nil {
+		log.Println("acquireTurn AcquireTurn error:", err)
+		if errors.Is(err, aihelper.ErrSessionBusy) {
+			return nil, code.CodeSessionBusy
+		}
+		// 排队期间请求被取消
+		return nil, code.AIGenerationStopped
+	}
+	return release, code.CodeSuccess
+}
+
+// generateErrorCode 将分支操作和模型调用的错误转换为错误码
+func generateErrorCode(err error) code.Code {
+	switch {
+	case errors.Is(err, aihelper.ErrGenerationStopped):
+		return code.AIGenerationStopped
+	case errors.Is(err, aihelper.ErrStructuredOutputInvalid):
+		return code.AIStructuredOutputInvalid
+	case errors.Is(err, aihelper.ErrMessageNotFound):
+		return code.CodeRecordNotFound
+	case errors.Is(err, aihelper.ErrNotUserMessage), errors.Is(err, aihelper.ErrNothingToRegenerate):
+		return code.CodeInvalidParams
+	default:
+		return code.AIModelFail
+	}
+}
+
+// RegenerateReply 为当前分支最后一个问题重新生成回答，原回答作为兄弟分支保留
+func RegenerateReply(ctx context.Context, userName string, sessionID string) (string, code.Code) {
+	if code_ := usage.CheckQuota(userName); code_ != code.CodeSuccess {
+		return "", code_
+	}
+	helper, code_ := getAIHelper(userName, sessionID)
+	if code_ != code.CodeSuccess {
+		return "", code_
+	}
+	defer helper.Unpin()
+
+	release, code_ := acquireTurn(ctx, helper, nil)
+	if code_ != code.CodeSuccess {
+		return "", code_
+	}
+	defer release()
+
+	aiResponse, err := helper.Regenerate(userName, ctx, nil)
+	if err != nil {
+		log.Println("RegenerateReply Regenerate error:", err)
+		return "", generateErrorCode(err)
+	}
+	return aiResponse.Content, code.CodeSuccess
+}
+
+// RegenerateReplyStream 流式重新生成回答
+func RegenerateReplyStream(ctx context.Context, userName string, sessionID string, stream *sse.Stream) code.Code {
+	if code_ := usage.CheckQuota(userName); code_ != code.CodeSuccess {
+		return code_
+	}
+	helper, code_ := getAIHelper(userName, sessionID)
+	if code_ != code.CodeSuccess {
+		return code_
+	}
+
+	return streamToWriter(ctx, stream, helper, userName, "", nil, func(ctx context.Context, cb aihelper.StreamCallback) (*model.Message, error) {
+		return helper.Regenerate(userName, ctx, cb)
+	})
+}
+
+// EditMessage 修改一个用户问题，从该问题处创建新分支并生成回答
+func EditMessage(ctx context.Context, userName string, sessionID string, messageID string, userQuestion string) (string, code.Code) {
+	if code_ := usage.CheckQuota(userName); code_ != code.CodeSuccess {
+		return "", code_
+	}
+	helper, code_ := getAIHelper(userName, sessionID)
+	if code_ != code.CodeSuccess {
+		return "", code_
+	}
+	defer helper.Unpin()
+
+	release, code_ := acquireTurn(ctx, helper, nil)
+	if code_ != code.CodeSuccess {
+		return "", code_
+	}
+	defer release()
+
+	aiResponse, err := helper.EditMessage(userName, ctx, nil, messageID, userQuestion)
+	if err != nil {
+		log.Println("EditMessage EditMessage error:", err)
+		return "", generateErrorCode(err)
+	}
+	return aiResponse.Content, code.CodeSuccess
 }
 
-func ChatStreamSend(userName string, sessionID string, userQuestion string, modelType string, writer http.ResponseWriter) code.Code {
+// EditMessageStream 修改一个用户问题并流式生成回答
+func EditMessageStream(ctx context.Context, userName string, sessionID string, messageID string, userQuestion string, stream *sse.Stream) code.Code {
+	if code_ := usage.CheckQuota(userName); code_ != code.CodeSuccess {
+		return code_
+	}
+	helper, code_ := getAIHelper(userName, sessionID)
+	if code_ != code.CodeSuccess {
+		return code_
+	}
+
+	return streamToWriter(ctx, stream, helper, userName, "", nil, func(ctx context.Context, cb aihelper.StreamCallback) (*model.Message, error) {
+		return helper.EditMessage(userName, ctx, cb, messageID, userQuestion)
+	})
+}
 
-	return StreamMessageToExistingSession(userName, sessionID, userQuestion, modelType, writer)
+// SwitchBranch 切换到包含指定消息的分支，返回切换后的历史记录
+func SwitchBranch(ctx context.Context, userName string, sessionID string, messageID string) ([]model.History, code.Code) {
+	helper, code_ := getAIHelper(userName, sessionID)
+	if code_ != code.CodeSuccess {
+		return nil, code_
+	}
+	defer helper.Unpin()
+	release, code_ := acquireTurn(ctx, helper, nil)
+	if code_ != code.CodeSuccess {
+		return nil, code_
+	}
+	defer release()
+
+	if err := helper.SwitchBranch(messageID); err != nil {
+		log.Println("SwitchBranch SwitchBranch error:", err)
+		return nil, generateErrorCode(err)
+	}
+	return helper.GetHistory(), code.CodeSuccess
+}
+
+// ForkSession 以指定消息为终点复制一条分支，创建一个新会话，返回新会话ID
+func ForkSession(userName string, sessionID string, messageID string) (string, code.Code) {
+	helper, code_ := getAIHelper(userName, sessionID)
+	if code_ != code.CodeSuccess {
+		return "", code_
+	}
+	defer helper.Unpin()
+
+	path, err := helper.GetPathTo(messageID)
+	if err != nil {
+		log.Println("ForkSession GetPathTo error:", err)
+		return "", generateErrorCode(err)
+	}
+
+	source, err := session.GetSessionByID(sessionID)
+	if err != nil {
+		log.Println("ForkSession GetSessionByID error:", err)
+		return "", code.CodeServerBusy
+	}
+
+	// 新会话沿用原会话的模型、系统提示词、生成参数和MCP服务开关
+	forked := &model.Session{
+		ID:                 uuid.New().String(),
+		UserName:           userName,
+		Title:              source.Title,
+		ModelType:          helper.GetModelType(),
+		ModelConfig:        aihelper.EncodeModelConfig(helper.GetModelConfig()),
+		ProfileID:          source.ProfileID,
+		SystemPrompt:       source.SystemPrompt,
+		GenerationParams:   source.GenerationParams,
+		DisabledMCPServers: helper.GetDisabledMCPServers(),
+	}
+
+	// 复制分支上的消息，重新生成消息ID并串成一条链
+	msgs := make([]*model.Message, 0, len(path))
+	parentID := ""
+	for _, m := range path {
+		msg := &model.Message{
+			MessageID: uuid.New().String(),
+			ParentID:  parentID,
+			SessionID: forked.ID,
+			UserName:  m.UserName,
+			Kind:      m.Kind,
+			Content:   m.Content,
+			IsUser:    m.IsUser,
+			Stopped:   m.Stopped,
+			ModelType: m.ModelType,
+
+			ToolCallID:    m.ToolCallID,
+			ToolName:      m.ToolName,
+			ToolArguments: m.ToolArguments,
+			LatencyMs:     m.LatencyMs,
+			Error:         m.Error,
+		}
+		msgs = append(msgs, msg)
+		parentID = msg.MessageID
+	}
+	forked.ActiveLeafID = parentID
+
+	if err := session.CreateSessionWithMessages(forked, msgs); err != nil {
+		log.Println("ForkSession CreateSessionWithMessages error:", err)
+		return "", code.CodeServerBusy
+	}
+	return forked.ID, code.CodeSuccess
+}
+
+// StopGeneration 停止会话正在进行的生成，已生成的部分回答会带上停止标记保存
+func StopGeneration(userName string, sessionID string) code.Code {
+	// 只查找内存：会话没有加载就不可能有正在进行的生成
+	helper, ok := aihelper.GetGlobalManager().GetAIHelper(userName, sessionID)
+	if !ok || !helper.Stop() {
+		return code.CodeRecordNotFound
+	}
+	return code.CodeSuccess
+}
+
+// GetSessionMCPServers 获取会话可用的MCP服务（全局配置的服务和用户注册的服务）及其在会话中的启用状态
+func GetSessionMCPServers(userName string, sessionID string) ([]model.MCPServerInfo, code.Code) {
+	helper, code_ := getAIHelper(userName, sessionID)
+	if code_ != code.CodeSuccess {
+		return nil, code_
+	}
+	defer helper.Unpin()
+	return sessionMCPServers(userName, helper)
+}
+
+// SetSessionMCPServer 在会话中启用或停用一个MCP服务，从下一轮对话开始生效
+func SetSessionMCPServer(userName string, sessionID string, name string, enabled bool) ([]model.MCPServerInfo, code.Code) {
+	helper, code_ := getAIHelper(userName, sessionID)
+	if code_ != code.CodeSuccess {
+		return nil, code_
+	}
+	defer helper.Unpin()
+	infos, code_ := sessionMCPServers(userName, helper)
+	if code_ != code.CodeSuccess {
+		return nil, code_
+	}
+	found := false
+	for i := range infos {
+		if infos[i].Name == name {
+			infos[i].Enabled = enabled
+			found = true
+		}
+	}
+	if !found {
+		return nil, code.CodeRecordNotFound
+	}
+
+	if err := helper.SetMCPServerEnabled(name, enabled); err != nil {
+		log.Println("SetSessionMCPServer SetMCPServerEnabled error:", err)
+		return nil, code.CodeServerBusy
+	}
+	return infos, code.CodeSuccess
+}
+
+// sessionMCPServers 列出用户可用的MCP服务，按会话中的开关设置启用状态
+func sessionMCPServers(userName string, helper *aihelper.AIHelper) ([]model.MCPServerInfo, code.Code) {
+	userServers, err := mcpserver.GetServersByUserName(userName)
+	if err != nil {
+		log.Println("sessionMCPServers GetServersByUserName error:", err)
+		return nil, code.CodeServerBusy
+	}
+
+	disabled := make(map[string]bool)
+	for _, name := range helper.GetDisabledMCPServers() {
+		disabled[name] = true
+	}
+	globalServers := config.GetConfig().MCPServers
+	infos := make([]model.MCPServerInfo, 0, len(globalServers)+len(userServers))
+	for _, s := range globalServers {
+		transport := s.Transport
+		if transport == "" {
+			transport = aihelper.MCPTransportStreamableHTTP
+		}
+		infos = append(infos, model.MCPServerInfo{
+			Name:      s.Name,
+			Transport: transport,
+			Global:    true,
+			Enabled:   !disabled[s.Name],
+		})
+	}
+	for _, s := range userServers {
+		infos = append(infos, model.MCPServerInfo{
+			Name:      s.Name,
+			Transport: s.Transport,
+			Enabled:   !disabled[s.Name],
+		})
+	}
+	return infos, code.CodeSuccess
+}
+
+// ResolveToolApproval 批准或拒绝流式对话中等待批准的工具调用
+func ResolveToolApproval(userName string, approvalID string, approved bool) code.Code {
+	if err := aihelper.ResolveToolApproval(userName, approvalID, approved); err != nil {
+		// 审批已超时、对话已结束或不属于当前用户
+		return code.CodeRecordNotFound
+	}
+	return code.CodeSuccess
 }
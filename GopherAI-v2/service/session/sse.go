@@ -0,0 +1,79 @@
+package session
+
+import (
+	"GopherAI/common/aihelper"
+	"GopherAI/common/sse"
+	"GopherAI/model"
+)
+
+// 流式接口各事件的数据，对比模式下带有产生该事件的模型
+type (
+	sessionData struct {
+		Version   int    `json:"version"`
+		SessionID string `json:"sessionId"`
+		TurnID    string `json:"turnId"` // 本次生成过程的ID，断线重连时使用
+		// Options 本轮实际使用的生成参数
+		Options *model.GenerationParams `json:"options,omitempty"`
+	}
+	queueData struct {
+		Position int `json:"position"`
+	}
+	deltaData struct {
+		Model   string `json:"model,omitempty"`
+		Content string `json:"content"`
+	}
+	stepData struct {
+		Model string `json:"model,omitempty"`
+		*aihelper.StepEvent
+	}
+	toolCallData struct {
+		Model string `json:"model,omitempty"`
+		*aihelper.ToolCallEvent
+	}
+	toolResultData struct {
+		Model string `json:"model,omitempty"`
+		*aihelper.ToolResultEvent
+	}
+	sourcesData struct {
+		Model   string            `json:"model,omitempty"`
+		Sources []aihelper.Source `json:"sources"`
+	}
+	usageData struct {
+		Model string `json:"model,omitempty"`
+		*aihelper.Usage
+	}
+	// doneData 不带 model 时表示整个流结束；对比模式中带 model 时表示该模型回答完成
+	doneData struct {
+		Model     string `json:"model,omitempty"`
+		MessageID string `json:"messageId,omitempty"` // 保存的回答消息ID，可用于切换分支
+		Stopped   bool   `json:"stopped,omitempty"`   // 回答被中途停止
+	}
+)
+
+// sendQueuePosition 返回报告排队位置的回调
+func sendQueuePosition(run *streamRun) func(int) {
+	return func(position int) {
+		run.emit(sse.EventQueue, queueData{Position: position})
+	}
+}
+
+// streamEvents 把生成过程中的中间步骤、工具调用、参考文档和用量转发为 SSE 事件，modelType 为空表示非对比模式
+func streamEvents(run *streamRun, modelType string) *aihelper.StreamEvents {
+	return &aihelper.StreamEvents{
+		Step: func(step *aihelper.StepEvent) {
+			run.emit(sse.EventStep, stepData{Model: modelType, StepEvent: step})
+		},
+		ToolCall: func(call *aihelper.ToolCallEvent) {
+			run.emit(sse.EventToolCall, toolCallData{Model: modelType, ToolCallEvent: call})
+		},
+		ToolResult: func(result *aihelper.ToolResultEvent) {
+			run.emit(sse.EventToolResult, toolResultData{Model: modelType, ToolResultEvent: result})
+		},
+		Sources: func(sources []aihelper.Source) {
+			run.emit(sse.EventSources, sourcesData{Model: modelType, Sources: sources})
+		},
+		Usage: func(usage *aihelper.Usage) {
+			run.emit(sse.EventUsage, usageData{Model: modelType, Usage: usage})
+		},
+	}
+}
@@ -0,0 +1,84 @@
+package usage
+
+import (
+	"GopherAI/common/code"
+	"GopherAI/config"
+	"GopherAI/dao/usage"
+	"GopherAI/model"
+	"log"
+	"time"
+)
+
+// startOfDay 当天零点（本地时间）
+func startOfDay(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+}
+
+// startOfMonth 当月一日零点（本地时间）
+func startOfMonth(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+}
+
+// CheckQuota 检查用户当天和当月的用量是否已达上限，达到上限时返回 CodeQuotaExceeded
+// 检查在生成前进行，最后一轮对话可能使用量略超上限
+func CheckQuota(userName string) code.Code {
+	quota := config.GetConfig().GetUserQuota(userName)
+	now := time.Now()
+	limits := []struct {
+		tokens int64
+		since  time.Time
+	}{
+		{quota.DailyTokens, startOfDay(now)},
+		{quota.MonthlyTokens, startOfMonth(now)},
+	}
+	for _, limit := range limits {
+		if limit.tokens <= 0 {
+			continue
+		}
+		sum, err := usage.SumUsage(userName, limit.since)
+		if err != nil {
+			log.Println("CheckQuota SumUsage error:", err)
+			return code.CodeServerBusy
+		}
+		if sum.TotalTokens >= limit.tokens {
+			return code.CodeQuotaExceeded
+		}
+	}
+	return code.CodeSuccess
+}
+
+// GetUserUsage 获取用户当天、当月的用量和上限，以及最近 days 天按模型、按天的用量
+func GetUserUsage(userName string, days int) (*model.UserUsage, code.Code) {
+	now := time.Now()
+	since := startOfDay(now).AddDate(0, 0, 1-days)
+
+	today, err := usage.SumUsage(userName, startOfDay(now))
+	if err != nil {
+		log.Println("GetUserUsage SumUsage error:", err)
+		return nil, code.CodeServerBusy
+	}
+	month, err := usage.SumUsage(userName, startOfMonth(now))
+	if err != nil {
+		log.Println("GetUserUsage SumUsage error:", err)
+		return nil, code.CodeServerBusy
+	}
+	byModel, err := usage.GetUsageByModel(userName, since)
+	if err != nil {
+		log.Println("GetUserUsage GetUsageByModel error:", err)
+		return nil, code.CodeServerBusy
+	}
+	byDay, err := usage.GetUsageByDay(userName, since)
+	if err != nil {
+		log.Println("GetUserUsage GetUsageByDay error:", err)
+		return nil, code.CodeServerBusy
+	}
+
+	quota := config.GetConfig().GetUserQuota(userName)
+	return &model.UserUsage{
+		Today:   *today,
+		Month:   *month,
+		Quota:   model.UsageQuota{DailyTokens: quota.DailyTokens, MonthlyTokens: quota.MonthlyTokens},
+		ByModel: byModel,
+		ByDay:   byDay,
+	}, code.CodeSuccess
+}
@@ -49,22 +49,42 @@ func ConvertToModelMessage(sessionID string, userName string, msg *schema.Messag
 	}
 }
 
-// 将数据库消息转换为 schema 消息（供 AI 使用）
+// 将数据库消息转换为 schema 消息（供 AI 使用），每条消息对应一条 schema 消息
 func ConvertToSchemaMessages(msgs []*model.Message) []*schema.Message {
 	schemaMsgs := make([]*schema.Message, 0, len(msgs))
 	for _, m := range msgs {
-		role := schema.Assistant
-		if m.IsUser {
-			role = schema.User
+		switch m.GetKind() {
+		case model.MessageKindUser:
+			schemaMsgs = append(schemaMsgs, schema.UserMessage(m.Content))
+		case model.MessageKindToolCall:
+			// 每次工具调用对应一条带单个调用的助手消息，其后紧跟该调用的结果
+			schemaMsgs = append(schemaMsgs, schema.AssistantMessage("", []schema.ToolCall{{
+				ID:       m.ToolCallID,
+				Type:     "function",
+				Function: schema.FunctionCall{Name: m.ToolName, Arguments: m.ToolArguments},
+			}}))
+		case model.MessageKindToolResult:
+			schemaMsgs = append(schemaMsgs, schema.ToolMessage(m.Content, m.ToolCallID, schema.WithToolName(m.ToolName)))
+		default:
+			schemaMsgs = append(schemaMsgs, schema.AssistantMessage(m.Content, nil))
 		}
-		schemaMsgs = append(schemaMsgs, &schema.Message{
-			Role:    role,
-			Content: m.Content,
-		})
 	}
 	return schemaMsgs
 }
 
+// 在消息前插入系统提示词，提示词为空时原样返回
+func PrependSystemMessage(systemPrompt string, msgs []*schema.Message) []*schema.Message {
+	if systemPrompt == "" {
+		return msgs
+	}
+	out := make([]*schema.Message, 0, len(msgs)+1)
+	out = append(out, &schema.Message{
+		Role:    schema.System,
+		Content: systemPrompt,
+	})
+	return append(out, msgs...)
+}
+
 // RemoveAllFilesInDir 删除目录中的所有文件（不删除子目录）
 func RemoveAllFilesInDir(dir string) error {
 	entries, err := os.ReadDir(dir)
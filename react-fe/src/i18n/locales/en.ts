@@ -73,9 +73,6 @@ export default {
     session: "Session",
     syncHistory: "Sync history",
     model: "Model",
-    alibailian: "Alibaba Bailian",
-    alibailianRAG: "Alibaba Bailian RAG",
-    alibailianMCP: "Alibaba Bailian MCP",
     streaming: "Streaming",
     uploadDoc: "Upload document",
     inputPlaceholder: "Type a message...",
@@ -100,6 +97,8 @@ export default {
     ttsTimeout: "Voice synthesis timeout",
     ttsRequestFailed: "Failed to request voice API",
     newSession: "New session",
+    toolApproval: "AI wants to call tool {{server}}/{{tool}} with arguments: {{arguments}}\nAllow it?",
+    toolApprovalFailed: "Failed to submit tool approval",
   },
 
   // Theme
@@ -73,9 +73,6 @@ export default {
     session: "会话",
     syncHistory: "同步历史",
     model: "模型",
-    alibailian: "阿里百炼",
-    alibailianRAG: "阿里百炼 RAG",
-    alibailianMCP: "阿里百炼 MCP",
     streaming: "流式响应",
     uploadDoc: "上传文档",
     inputPlaceholder: "输入消息...",
@@ -100,6 +97,8 @@ export default {
     ttsTimeout: "语音合成超时",
     ttsRequestFailed: "请求语音接口失败",
     newSession: "新会话",
+    toolApproval: "AI 请求调用工具 {{server}}/{{tool}}，参数：{{arguments}}\n是否允许？",
+    toolApprovalFailed: "提交工具调用审批失败",
   },
 
   // Theme
@@ -0,0 +1,61 @@
+import type { StreamEvent } from "@/types"
+
+// 读取后端的 SSE 流，每解析出一条事件调用一次 onEvent
+// 事件之间以空行分隔，以 ":" 开头的保活注释会被忽略
+export async function readSSE(
+  response: Response,
+  onEvent: (event: StreamEvent) => void
+) {
+  // 请求在开始流式输出前被拒绝（如限流）时，后端返回普通 JSON，转换为 error 事件
+  if (response.headers.get("Content-Type")?.includes("application/json")) {
+    const body = await response.json()
+    onEvent({
+      id: "",
+      event: "error",
+      data: { code: body.status_code, message: body.status_msg },
+    } as StreamEvent)
+    return
+  }
+
+  const reader = response.body?.getReader()
+  if (!reader) throw new Error("No reader available")
+
+  const decoder = new TextDecoder()
+  let buffer = ""
+
+  const dispatch = (block: string) => {
+    let event = "message"
+    let id = ""
+    const data: string[] = []
+    for (const line of block.split("\n")) {
+      if (!line || line.startsWith(":")) continue
+      const idx = line.indexOf(":")
+      const field = idx === -1 ? line : line.slice(0, idx)
+      let value = idx === -1 ? "" : line.slice(idx + 1)
+      if (value.startsWith(" ")) value = value.slice(1)
+      if (field === "event") event = value
+      else if (field === "id") id = value
+      else if (field === "data") data.push(value)
+    }
+    if (data.length === 0) return
+    try {
+      onEvent({ id, event, data: JSON.parse(data.join("\n")) } as StreamEvent)
+    } catch (err) {
+      console.error("Invalid SSE event:", err)
+    }
+  }
+
+  while (true) {
+    const { done, value } = await reader.read()
+    if (done) break
+    buffer += decoder.decode(value, { stream: true }).replace(/\r\n?/g, "\n")
+
+    let sep = buffer.indexOf("\n\n")
+    while (sep !== -1) {
+      dispatch(buffer.slice(0, sep))
+      buffer = buffer.slice(sep + 2)
+      sep = buffer.indexOf("\n\n")
+    }
+  }
+  if (buffer.trim()) dispatch(buffer)
+}
@@ -4,9 +4,13 @@ import { useTranslation } from "react-i18next";
 import api from "@/services/api";
 import type {
   SessionsResponse,
+  ModelsResponse,
+  ModelInfo,
   HistoryResponse,
   ChatResponse,
   TTSResponse,
+  ToolApproval,
+  StreamEvent,
 } from "@/types";
 import { Button } from "@/components/ui/button";
 import {
@@ -30,6 +34,7 @@ import {
 } from "lucide-react";
 import { toast } from "sonner";
 import { SettingsBar } from "@/components/SettingsBar";
+import { readSSE } from "@/lib/sse";
 
 interface Message {
   role: "user" | "assistant";
@@ -45,6 +50,9 @@ interface Session {
   messages: Message[];
 }
 
+// 流式连接中断后最多重连的次数
+const MAX_STREAM_RETRIES = 3;
+
 export default function AIChat() {
   const { t } = useTranslation();
   const navigate = useNavigate();
@@ -54,6 +62,7 @@ export default function AIChat() {
   const [currentMessages, setCurrentMessages] = useState<Message[]>([]);
   const [inputMessage, setInputMessage] = useState("");
   const [loading, setLoading] = useState(false);
+  const [models, setModels] = useState<ModelInfo[]>([]);
   const [selectedModel, setSelectedModel] = useState("1");
   const [isStreaming, setIsStreaming] = useState(false);
   const [uploading, setUploading] = useState(false);
@@ -72,8 +81,30 @@ export default function AIChat() {
 
   useEffect(() => {
     loadSessions();
+    loadModels();
   }, []);
 
+  const loadModels = async () => {
+    try {
+      const response = await api.get<ModelsResponse>("/AI/models");
+      if (
+        response.data &&
+        response.data.status_code === 1000 &&
+        Array.isArray(response.data.models)
+      ) {
+        const list = response.data.models;
+        setModels(list);
+        if (list.length > 0) {
+          setSelectedModel((prev) =>
+            list.some((m) => m.id === prev) ? prev : list[0].id
+          );
+        }
+      }
+    } catch (error) {
+      console.error("Load models error:", error);
+    }
+  };
+
   const loadSessions = async () => {
     try {
       const response = await api.get<SessionsResponse>("/AI/chat/sessions");
@@ -123,10 +154,13 @@ export default function AIChat() {
           response.data.status_code === 1000 &&
           Array.isArray(response.data.history)
         ) {
-          const messages: Message[] = response.data.history.map((item) => ({
-            role: item.is_user ? "user" : "assistant",
-            content: item.content,
-          }));
+          // 工具调用记录不在对话中展示
+          const messages: Message[] = response.data.history
+            .filter((item) => item.kind !== "tool_call" && item.kind !== "tool_result")
+            .map((item) => ({
+              role: item.is_user ? "user" : "assistant",
+              content: item.content,
+            }));
           setSessions((prev) => ({
             ...prev,
             [sessionId]: { ...prev[sessionId], messages },
@@ -156,10 +190,13 @@ export default function AIChat() {
         response.data.status_code === 1000 &&
         Array.isArray(response.data.history)
       ) {
-        const messages: Message[] = response.data.history.map((item) => ({
-          role: item.is_user ? "user" : "assistant",
-          content: item.content,
-        }));
+        // 工具调用记录不在对话中展示
+        const messages: Message[] = response.data.history
+          .filter((item) => item.kind !== "tool_call" && item.kind !== "tool_result")
+          .map((item) => ({
+            role: item.is_user ? "user" : "assistant",
+            content: item.content,
+          }));
         setSessions((prev) => ({
           ...prev,
           [currentSessionId]: { ...prev[currentSessionId], messages },
@@ -207,6 +244,26 @@ export default function AIChat() {
     }
   };
 
+  // 工具调用需要批准时询问用户，并把结果提交给后端
+  const resolveToolApproval = async (approval: ToolApproval) => {
+    const approved = window.confirm(
+      t("chat.toolApproval", {
+        server: approval.server,
+        tool: approval.tool,
+        arguments: approval.arguments,
+      })
+    );
+    try {
+      await api.post("/AI/chat/tool-approval", {
+        approvalId: approval.approvalId,
+        approved,
+      });
+    } catch (error) {
+      console.error("Tool approval error:", error);
+      toast.error(t("chat.toolApprovalFailed"));
+    }
+  };
+
   const handleStreaming = async (question: string) => {
     const aiMessage: Message = {
       role: "assistant",
@@ -230,83 +287,86 @@ export default function AIChat() {
       : { question, modelType: selectedModel, sessionId: currentSessionId };
 
     try {
-      const response = await fetch(url, {
+      let response = await fetch(url, {
         method: "POST",
         headers,
         body: JSON.stringify(body),
       });
 
-      if (!response.ok) {
-        setLoading(false);
-        throw new Error("Network response was not ok");
-      }
-
-      const reader = response.body?.getReader();
-      if (!reader) throw new Error("No reader available");
-
-      const decoder = new TextDecoder();
-      let buffer = "";
       let accumulatedContent = "";
-
-      while (true) {
-        const { done, value } = await reader.read();
-        if (done) break;
-
-        const chunk = decoder.decode(value, { stream: true });
-        buffer += chunk;
-
-        const lines = buffer.split("\n");
-        buffer = lines.pop() || "";
-
-        for (const line of lines) {
-          const trimmedLine = line.trim();
-          if (!trimmedLine) continue;
-
-          if (trimmedLine.startsWith("data:")) {
-            const data = trimmedLine.slice(5).trim();
-
-            if (data === "[DONE]") {
-              setLoading(false);
-              setCurrentMessages((prev) => {
-                const newMessages = [...prev];
-                const lastIdx = newMessages.length - 1;
-                if (newMessages[lastIdx]?.role === "assistant") {
-                  newMessages[lastIdx] = {
-                    ...newMessages[lastIdx],
-                    meta: { status: "done" },
-                  };
-                }
-                return newMessages;
-              });
-            } else if (data.startsWith("{")) {
-              try {
-                const parsed = JSON.parse(data);
-                if (parsed.sessionId) {
-                  const newSid = String(parsed.sessionId);
-                  if (tempSession) {
-                    setSessions((prev) => ({
-                      ...prev,
-                      [newSid]: {
-                        id: newSid,
-                        name: t("chat.newSession"),
-                        messages: [],
-                      },
-                    }));
-                    setCurrentSessionId(newSid);
-                    setTempSession(false);
-                  }
-                }
-              } catch {
-                accumulatedContent += data;
-                updateStreamingMessage(accumulatedContent);
-              }
-            } else {
-              accumulatedContent += data;
-              updateStreamingMessage(accumulatedContent);
+      let streamError = "";
+      let finished = false;
+      // 断线重连所需：session 事件中的会话和生成过程ID，以及收到的最后一个事件ID
+      let streamSessionId = "";
+      let turnId = "";
+      let lastEventId = "";
+
+      const handleEvent = (ev: StreamEvent) => {
+        if (ev.id) lastEventId = ev.id;
+        switch (ev.event) {
+          case "session": {
+            const newSid = String(ev.data.sessionId);
+            streamSessionId = newSid;
+            turnId = ev.data.turnId;
+            if (tempSession) {
+              setSessions((prev) => ({
+                ...prev,
+                [newSid]: {
+                  id: newSid,
+                  name: t("chat.newSession"),
+                  messages: [],
+                },
+              }));
+              setCurrentSessionId(newSid);
+              setTempSession(false);
             }
+            break;
           }
+          case "delta":
+            accumulatedContent += ev.data.content;
+            updateStreamingMessage(accumulatedContent);
+            break;
+          case "step":
+            // 之前的片段是调用工具前的中间步骤，不属于最终回答
+            accumulatedContent = "";
+            updateStreamingMessage(accumulatedContent);
+            break;
+          case "tool_approval_required":
+            void resolveToolApproval(ev.data);
+            break;
+          case "error":
+            streamError = ev.data.message;
+            finished = true;
+            break;
+          case "done":
+            finished = true;
+            break;
         }
+      };
+
+      for (let attempt = 0; ; attempt++) {
+        if (!response.ok) {
+          setLoading(false);
+          throw new Error("Network response was not ok");
+        }
+        try {
+          await readSSE(response, handleEvent);
+        } catch (err) {
+          if (!turnId || attempt >= MAX_STREAM_RETRIES) throw err;
+        }
+        if (finished) break;
+        // 连接中断但生成仍在后台进行，按 Last-Event-ID 重连补发
+        if (!turnId || attempt >= MAX_STREAM_RETRIES) {
+          throw new Error("Stream interrupted");
+        }
+        await new Promise((resolve) => setTimeout(resolve, 1000 * (attempt + 1)));
+        response = await fetch("/api/AI/chat/resume-stream", {
+          method: "POST",
+          headers: { ...headers, "Last-Event-ID": lastEventId },
+          body: JSON.stringify({ sessionId: streamSessionId, turnId }),
+        });
       }
+      if (streamError) throw new Error(streamError);
 
       setLoading(false);
       setCurrentMessages((prev) => {
@@ -622,9 +682,11 @@ export default function AIChat() {
                 <SelectValue />
               </SelectTrigger>
               <SelectContent>
-                <SelectItem value="1">{t("chat.alibailian")}</SelectItem>
-                <SelectItem value="2">{t("chat.alibailianRAG")}</SelectItem>
-                <SelectItem value="3">{t("chat.alibailianMCP")}</SelectItem>
+                {models.map((m) => (
+                  <SelectItem key={m.id} value={m.id}>
+                    {m.name}
+                  </SelectItem>
+                ))}
               </SelectContent>
             </Select>
           </div>
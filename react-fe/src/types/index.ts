@@ -19,9 +19,104 @@ export interface SessionsResponse {
   }>;
 }
 
+export interface ModelInfo {
+  id: string;
+  name: string;
+  provider: string;
+  contextLength: number;
+  capabilities: {
+    streaming: boolean;
+    tools: boolean;
+    vision: boolean;
+  };
+  defaults: GenerationOptions;
+  limits: {
+    maxTemperature: number;
+    maxTokens: number;
+    maxStop: number;
+    seed: boolean;
+  };
+}
+
+// 生成参数，未设置的字段使用会话或模型的默认值
+export interface GenerationOptions {
+  temperature?: number;
+  max_tokens?: number;
+  top_p?: number;
+  stop?: string[];
+  seed?: number;
+}
+
+export interface ToolApproval {
+  approvalId: string;
+  server: string;
+  tool: string;
+  arguments: string;
+  timeout: number;
+}
+
+// 流式接口的事件，data 均为 JSON；流以 session 开始，以 done 或 error 结束
+export type StreamEvent = { id: string } & (
+  | {
+      event: "session";
+      data: {
+        version: number;
+        sessionId: string;
+        turnId: string;
+        options?: GenerationOptions;
+      };
+    }
+  | { event: "queue"; data: { position: number } }
+  | { event: "delta"; data: { model?: string; content: string } }
+  | { event: "step"; data: { model?: string; content: string } }
+  | {
+      event: "tool_call";
+      data: { model?: string; callId: string; name: string; arguments: string };
+    }
+  | {
+      event: "tool_result";
+      data: {
+        model?: string;
+        callId: string;
+        name: string;
+        result: string;
+        error?: string;
+        latencyMs: number;
+      };
+    }
+  | { event: "tool_approval_required"; data: ToolApproval }
+  | {
+      event: "sources";
+      data: {
+        model?: string;
+        sources: Array<{ id: string; snippet: string; score?: number }>;
+      };
+    }
+  | {
+      event: "usage";
+      data: {
+        model?: string;
+        promptTokens: number;
+        completionTokens: number;
+        totalTokens: number;
+      };
+    }
+  | { event: "error"; data: { model?: string; code: number; message: string } }
+  | {
+      event: "done";
+      data: { model?: string; messageId?: string; stopped?: boolean };
+    }
+);
+
+export interface ModelsResponse {
+  status_code: number;
+  models: ModelInfo[];
+}
+
 export interface HistoryResponse {
   status_code: number;
   history: Array<{
+    kind?: "user" | "assistant" | "tool_call" | "tool_result";
     is_user: boolean;
     content: string;
   }>;
@@ -0,0 +1,54 @@
+// 读取后端的 SSE 流，每解析出一条事件调用一次 onEvent({ id, event, data })
+// data 为解析后的 JSON；事件之间以空行分隔，以 ":" 开头的保活注释会被忽略
+export async function readSSE(response, onEvent) {
+  // 请求在开始流式输出前被拒绝（如限流）时，后端返回普通 JSON，转换为 error 事件
+  if ((response.headers.get('Content-Type') || '').includes('application/json')) {
+    const body = await response.json()
+    await onEvent({ id: '', event: 'error', data: { code: body.status_code, message: body.status_msg } })
+    return
+  }
+
+  const reader = response.body.getReader()
+  const decoder = new TextDecoder()
+  let buffer = ''
+
+  const dispatch = async (block) => {
+    let event = 'message'
+    let id = ''
+    const data = []
+    for (const line of block.split('\n')) {
+      if (!line || line.startsWith(':')) continue
+      const idx = line.indexOf(':')
+      const field = idx === -1 ? line : line.slice(0, idx)
+      let value = idx === -1 ? '' : line.slice(idx + 1)
+      if (value.startsWith(' ')) value = value.slice(1)
+      if (field === 'event') event = value
+      else if (field === 'id') id = value
+      else if (field === 'data') data.push(value)
+    }
+    if (data.length === 0) return
+    let parsed
+    try {
+      parsed = JSON.parse(data.join('\n'))
+    } catch (err) {
+      console.error('[SSE] Invalid event:', err)
+      return
+    }
+    await onEvent({ id, event, data: parsed })
+  }
+
+  // eslint-disable-next-line no-constant-condition
+  while (true) {
+    const { done, value } = await reader.read()
+    if (done) break
+    buffer += decoder.decode(value, { stream: true }).replace(/\r\n?/g, '\n')
+
+    let sep = buffer.indexOf('\n\n')
+    while (sep !== -1) {
+      await dispatch(buffer.slice(0, sep))
+      buffer = buffer.slice(sep + 2)
+      sep = buffer.indexOf('\n\n')
+    }
+  }
+  if (buffer.trim()) await dispatch(buffer)
+}